@@ -0,0 +1,367 @@
+package inference
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+)
+
+// ConflictResolution names a built-in Strategy.
+type ConflictResolution string
+
+const (
+	// ResolveByOrder fires the activations by Inference.Order
+	ResolveByOrder ConflictResolution = "order"
+	// ResolveBySalience fires the activations with the highest
+	// Inference.Salience first
+	ResolveBySalience ConflictResolution = "salience"
+	// ResolveByRecency fires the newest activations first
+	ResolveByRecency ConflictResolution = "recency"
+	// ResolveBySpecificity fires the activations of the inferences that
+	// test the most facts first
+	ResolveBySpecificity ConflictResolution = "specificity"
+	// ResolveByLEX is the OPS5 LEX strategy: the activations that read the
+	// most recent facts fire first, then the most specific ones
+	ResolveByLEX ConflictResolution = "lex"
+	// ResolveByMEA is the OPS5 MEA strategy: like LEX, but the recency of
+	// the fact read by the first rule of the inference comes first
+	ResolveByMEA ConflictResolution = "mea"
+	// ResolveRandomly fires the activations in a random order, repeatable
+	// with KnowledgeBase.Seed
+	ResolveRandomly ConflictResolution = "random"
+)
+
+// Activation is an inference whose rules hold and that is waiting on the
+// agenda to derive its fact.
+type Activation struct {
+	Inference   string      `json:"inference"`
+	FactID      string      `json:"fact_id"`
+	Value       interface{} `json:"value"`
+	Certainty   float64     `json:"certainty,omitempty"`
+	Salience    int         `json:"salience,omitempty"`
+	Order       int         `json:"order"`
+	Specificity int         `json:"specificity"`
+	// TimeTags tells when the facts the inference read last changed, the
+	// most recent first
+	TimeTags []int `json:"time_tags,omitempty"`
+	// First is the time tag of the fact read by the first rule
+	First int `json:"first,omitempty"`
+	// Created is the time tag of the activation itself
+	Created int `json:"created"`
+
+	index  int
+	random int64
+	result inferred
+}
+
+// Strategy decides which activation of the agenda fires first.
+type Strategy interface {
+	// Compare returns a negative number when a fires before b, a positive
+	// one when b fires before a and zero when either may fire first
+	Compare(a, b *Activation) int
+}
+
+// StrategyFunc adapts a function to the Strategy interface.
+type StrategyFunc func(a, b *Activation) int
+
+func (f StrategyFunc) Compare(a, b *Activation) int {
+	return f(a, b)
+}
+
+// NewStrategy returns the built-in strategy with the name. Every strategy
+// breaks ties by Inference.Order.
+func NewStrategy(name ConflictResolution) (Strategy, error) {
+	var compare func(a, b *Activation) int
+	switch name {
+	case "", ResolveByOrder:
+		compare = func(a, b *Activation) int { return 0 }
+	case ResolveBySalience:
+		compare = func(a, b *Activation) int { return cmp.Compare(b.Salience, a.Salience) }
+	case ResolveByRecency:
+		compare = func(a, b *Activation) int { return cmp.Compare(b.Created, a.Created) }
+	case ResolveBySpecificity:
+		compare = func(a, b *Activation) int { return cmp.Compare(b.Specificity, a.Specificity) }
+	case ResolveByLEX:
+		compare = lex
+	case ResolveByMEA:
+		compare = func(a, b *Activation) int {
+			if c := cmp.Compare(b.First, a.First); c != 0 {
+				return c
+			}
+			return lex(a, b)
+		}
+	case ResolveRandomly:
+		compare = func(a, b *Activation) int { return cmp.Compare(a.random, b.random) }
+	default:
+		return nil, fmt.Errorf("unknown conflict resolution %q", name)
+	}
+	return StrategyFunc(func(a, b *Activation) int {
+		if c := compare(a, b); c != 0 {
+			return c
+		}
+		return cmp.Or(cmp.Compare(a.Order, b.Order), cmp.Compare(a.index, b.index))
+	}), nil
+}
+
+// lex compares the time tags pairwise, the most recent first, then the
+// specificity.
+func lex(a, b *Activation) int {
+	for i := 0; i < len(a.TimeTags) && i < len(b.TimeTags); i++ {
+		if c := cmp.Compare(b.TimeTags[i], a.TimeTags[i]); c != 0 {
+			return c
+		}
+	}
+	if c := cmp.Compare(len(b.TimeTags), len(a.TimeTags)); c != 0 {
+		return c
+	}
+	return cmp.Compare(b.Specificity, a.Specificity)
+}
+
+// SetStrategy makes the knowledge base fire its inferences one activation
+// at a time, in the order of the strategy, instead of in passes.
+func (kb *KnowledgeBase) SetStrategy(strategy Strategy) {
+	kb.strategy = strategy
+}
+
+// usesAgenda reports whether the inferences fire from the agenda, one at a
+// time, instead of in passes by Order.
+func (kb *KnowledgeBase) usesAgenda() bool {
+	return kb.strategy != nil || kb.ConflictResolution != "" || kb.StepThrough
+}
+
+func (kb *KnowledgeBase) resolver() (Strategy, error) {
+	if kb.strategy != nil {
+		return kb.strategy, nil
+	}
+	return NewStrategy(kb.ConflictResolution)
+}
+
+// Agenda returns the activations waiting to fire, in the order they would
+// fire. It only looks: the inferences whose facts changed are evaluated
+// without firing nor corroborating anything, and the time tags and random
+// numbers they take are given back, so the next call runs as if Agenda had
+// not been called.
+func (kb *KnowledgeBase) Agenda() ([]Activation, error) {
+	strategy, err := kb.resolver()
+	if err != nil {
+		return nil, err
+	}
+	kb.syncMatchState()
+	clock, random := kb.clock, kb.random
+	if random != nil {
+		saved := *random
+		defer func() { *kb.random = saved }()
+	}
+	defer func() { kb.clock, kb.random = clock, random }()
+	var agenda []*Activation
+	for i := range kb.agenda {
+		if !kb.dirty[kb.agenda[i].index] {
+			agenda = append(agenda, &kb.agenda[i])
+		}
+	}
+	for i, dirty := range kb.dirty {
+		if !dirty || !kb.Inferences[i].isNeeded(kb.expressions(), kb.Facts) {
+			continue
+		}
+		activations, _, err := kb.activate(i)
+		if err != nil {
+			return nil, err
+		}
+		for k := range activations {
+			agenda = append(agenda, &activations[k])
+		}
+	}
+	slices.SortStableFunc(agenda, strategy.Compare)
+	activations := make([]Activation, len(agenda))
+	for i, a := range agenda {
+		activations[i] = *a
+	}
+	return activations, nil
+}
+
+// Step fires the activation at the top of the agenda and returns it, or nil
+// when the agenda is empty. Together with StepThrough it lets the firing be
+// followed one inference at a time.
+func (kb *KnowledgeBase) Step() (*Activation, error) {
+	strategy, err := kb.resolver()
+	if err != nil {
+		return nil, err
+	}
+	kb.beginChanges()
+	defer kb.publishChanges()
+	kb.syncMatchState()
+	if err := kb.advanceClock(); err != nil {
+		return nil, err
+	}
+	if err := kb.updateBeliefs(); err != nil {
+		return nil, err
+	}
+	if err := kb.refreshAgenda(); err != nil {
+		return nil, err
+	}
+	a := kb.fire(strategy)
+	if a == nil {
+		return nil, nil
+	}
+	if err := kb.updateBeliefs(); err != nil {
+		return a, err
+	}
+	err = kb.refreshAgenda()
+	kb.ResolveContradictions()
+	return a, err
+}
+
+// inferAgenda fires the activations one at a time until the agenda is
+// empty. With StepThrough it only fills the agenda. MaxIterations bounds
+// the firings of every inference.
+func (kb *KnowledgeBase) inferAgenda(limit int) error {
+	strategy, err := kb.resolver()
+	if err != nil {
+		return err
+	}
+	limit *= max(1, len(kb.Inferences))
+	for firings := 0; ; firings++ {
+		if err := kb.updateBeliefs(); err != nil {
+			return err
+		}
+		if err := kb.refreshAgenda(); err != nil {
+			return err
+		}
+		if len(kb.agenda) == 0 || kb.StepThrough {
+			return nil
+		}
+		if firings == limit {
+			var firing []string
+			for _, a := range kb.agenda {
+				firing = append(firing, a.Inference)
+			}
+			return fmt.Errorf("%w after %d firings, still activated: %v", ErrMaxIterations, limit, firing)
+		}
+		kb.fire(strategy)
+	}
+}
+
+// refreshAgenda evaluates the dirty inferences again, replacing their
+// activations. It stops when the call is cancelled or out of budget.
+func (kb *KnowledgeBase) refreshAgenda() error {
+	// corroborating a fact may touch inferences already looked at
+	for slices.Contains(kb.dirty, true) {
+		if err := kb.refreshDirty(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (kb *KnowledgeBase) refreshDirty() error {
+	for i, dirty := range kb.dirty {
+		if !dirty {
+			continue
+		}
+		if err := kb.spend(1); err != nil {
+			return err
+		}
+		kb.dirty[i] = false
+		kb.agenda = slices.DeleteFunc(kb.agenda, func(a Activation) bool { return a.index == i })
+		if !kb.Inferences[i].isNeeded(kb.expressions(), kb.Facts) {
+			kb.corroborate(i)
+			continue
+		}
+		activations, corroborate, err := kb.activate(i)
+		if err != nil {
+			if aborted(err) {
+				kb.dirty[i] = true
+			}
+			return err
+		}
+		for _, result := range corroborate {
+			kb.derive(i, result)
+		}
+		kb.agenda = append(kb.agenda, activations...)
+	}
+	return nil
+}
+
+// activate evaluates the inference at index i and returns its activation
+// when its rules hold and it would change the facts. A pattern inference has
+// an activation per match, the matches that hold the value their fact
+// already has are returned to be corroborated. It fails on evaluation
+// errors in Strict mode.
+func (kb *KnowledgeBase) activate(i int) ([]Activation, []inferred, error) {
+	inf := &kb.Inferences[i]
+	if len(inf.ForEach) > 0 {
+		fire, corroborate, err := kb.matchResults(i)
+		if err != nil {
+			return nil, nil, err
+		}
+		activations := make([]Activation, len(fire))
+		for k, result := range fire {
+			activations[k] = kb.activation(i, result)
+		}
+		return activations, corroborate, nil
+	}
+	result, err := inf.infer(kb.expressions(), kb.Facts)
+	if err != nil {
+		return nil, nil, kb.report(fmt.Sprintf("inferences[%d]", i), inf.name(), err)
+	}
+	if old, ok := kb.Facts[result.id]; ok && old.isBase() && sameFactValue(old.Value, result.value) {
+		return nil, nil, nil
+	}
+	return []Activation{kb.activation(i, result)}, nil, nil
+}
+
+// activation returns the activation of the inference at index i for the
+// result.
+func (kb *KnowledgeBase) activation(i int, result inferred) Activation {
+	inf := &kb.Inferences[i]
+	kb.clock++
+	if kb.random == nil {
+		kb.random = rand.NewPCG(uint64(kb.Seed), 0)
+	}
+	a := Activation{
+		Inference: inf.name(),
+		FactID:    result.id,
+		Value:     result.value,
+		Salience:  inf.Salience,
+		Order:     inf.Order,
+		Created:   kb.clock,
+		index:     i,
+		random:    int64(kb.random.Uint64() >> 1),
+		result:    result,
+	}
+	if result.certainty != 1 {
+		a.Certainty = result.certainty
+	}
+	if node, ok := inferenceInputs(inf); ok {
+		a.Specificity = len(node.inputs)
+	}
+	for _, id := range result.premises {
+		a.TimeTags = append(a.TimeTags, kb.timeTags[id])
+	}
+	slices.SortFunc(a.TimeTags, func(x, y int) int { return cmp.Compare(y, x) })
+	if len(inf.Rules) > 0 {
+		if ids, err := expressionFacts(inf.Rules[0].Expression); err == nil && len(ids) > 0 {
+			a.First = kb.timeTags[ids[0]]
+		}
+	}
+	return a
+}
+
+// fire derives the fact of the first activation of the agenda and removes
+// it, returning nil when the agenda is empty.
+func (kb *KnowledgeBase) fire(strategy Strategy) *Activation {
+	if len(kb.agenda) == 0 {
+		return nil
+	}
+	top := 0
+	for i := range kb.agenda {
+		if strategy.Compare(&kb.agenda[i], &kb.agenda[top]) < 0 {
+			top = i
+		}
+	}
+	a := kb.agenda[top]
+	kb.agenda = slices.Delete(kb.agenda, top, top+1)
+	kb.derive(a.index, a.result)
+	return &a
+}
@@ -0,0 +1,159 @@
+package inference
+
+import (
+	"reflect"
+	"testing"
+)
+
+// names returns the inferences of the activations in firing order
+func names(agenda []Activation) []string {
+	var names []string
+	for _, a := range agenda {
+		names = append(names, a.Inference)
+	}
+	return names
+}
+
+func agendaKnowledgeBase(resolution ConflictResolution) *KnowledgeBase {
+	kb := &KnowledgeBase{
+		ConflictResolution: resolution,
+		StepThrough:        true,
+		Inferences: []Inference{
+			{ID: "old", Rules: rule("a"), FactID: "x", FactValue: 1, Order: 1},
+			{ID: "new", Rules: rule("b"), FactID: "y", FactValue: 2, Order: 2, Salience: 5},
+			{ID: "both", Rules: rule("a && b"), FactID: "z", FactValue: 3, Order: 3},
+		},
+	}
+	kb.Start()
+	kb.AddFact(Fact{ID: "a", Value: true})
+	kb.AddFact(Fact{ID: "b", Value: true})
+	return kb
+}
+
+func TestKnowledgeBase_AgendaStrategies(t *testing.T) {
+	tests := []struct {
+		resolution ConflictResolution
+		want       []string
+	}{
+		{ResolveByOrder, []string{"old", "new", "both"}},
+		{ResolveBySalience, []string{"new", "old", "both"}},
+		{ResolveBySpecificity, []string{"both", "old", "new"}},
+		{ResolveByLEX, []string{"both", "new", "old"}},
+		{ResolveByMEA, []string{"new", "both", "old"}},
+	}
+	for _, tt := range tests {
+		kb := agendaKnowledgeBase(tt.resolution)
+		agenda, err := kb.Agenda()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got := names(agenda); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.resolution, tt.want, got)
+		}
+	}
+}
+
+func TestKnowledgeBase_AgendaRecency(t *testing.T) {
+	kb := agendaKnowledgeBase(ResolveByRecency)
+	agenda, _ := kb.Agenda()
+	// "old" was activated by a, before b activated the others
+	if got := names(agenda); got[len(got)-1] != "old" {
+		t.Errorf("Expected the oldest activation last, got %v", got)
+	}
+}
+
+func TestKnowledgeBase_AgendaRandomSeed(t *testing.T) {
+	order := func(seed int64) []string {
+		kb := agendaKnowledgeBase(ResolveRandomly)
+		kb.Seed = seed
+		kb.Start()
+		kb.AddFact(Fact{ID: "a", Value: true})
+		kb.AddFact(Fact{ID: "b", Value: true})
+		agenda, _ := kb.Agenda()
+		return names(agenda)
+	}
+	if first, second := order(42), order(42); !reflect.DeepEqual(first, second) {
+		t.Errorf("Expected the same seed to give the same order, got %v and %v", first, second)
+	}
+}
+
+func TestKnowledgeBase_Step(t *testing.T) {
+	kb := agendaKnowledgeBase(ResolveBySalience)
+	if len(kb.Facts) != 2 {
+		t.Fatalf("Expected nothing to fire while stepping through, got %v", kb.Facts)
+	}
+	a, err := kb.Step()
+	if err != nil || a == nil || a.Inference != "new" {
+		t.Fatalf("Expected the salient inference to fire first, got %+v, %v", a, err)
+	}
+	if kb.Facts["y"].Value != 2 {
+		t.Errorf("Expected y to be derived, got %v", kb.Facts)
+	}
+	agenda, _ := kb.Agenda()
+	if got := names(agenda); !reflect.DeepEqual(got, []string{"old", "both"}) {
+		t.Errorf("Expected the fired activation to leave the agenda, got %v", got)
+	}
+	for a != nil {
+		a, _ = kb.Step()
+	}
+	if len(kb.Facts) != 5 {
+		t.Errorf("Expected every activation to fire, got %v", kb.Facts)
+	}
+}
+
+func TestKnowledgeBase_AgendaMatchesPasses(t *testing.T) {
+	kb := triageKnowledgeBase()
+	kb.ConflictResolution = ResolveByLEX
+	kb.AddFact(Fact{ID: "temperature", Value: 39})
+	kb.AddFact(Fact{ID: "systolic_bp", Value: 85})
+	if kb.Facts["triage_level"].Value != "red" {
+		t.Errorf("Expected the agenda to reach the same conclusion, got %v", kb.Facts)
+	}
+
+	kb = &KnowledgeBase{ConflictResolution: "fifo"}
+	if _, err := kb.Agenda(); err == nil {
+		t.Errorf("Expected an unknown strategy to fail")
+	}
+	if _, ok := diagnostic(kb.Validate(), "conflict_resolution"); !ok {
+		t.Errorf("Expected an unknown strategy to be reported")
+	}
+}
+
+func TestKnowledgeBase_AgendaOnlyLooks(t *testing.T) {
+	kb := &KnowledgeBase{
+		Inferences: []Inference{
+			{ID: "old", Rules: rule("a"), FactID: "x", FactValue: 1, Order: 1},
+			{ID: "both", Rules: rule("a && b"), FactID: "z", FactValue: 3, Order: 3},
+		},
+	}
+	kb.Start()
+	kb.Facts["a"] = Fact{ID: "a", Value: true}
+	kb.Facts["b"] = Fact{ID: "b", Value: true}
+	first, _ := kb.Agenda()
+	second, _ := kb.Agenda()
+	if !reflect.DeepEqual(first, second) || len(first) != 2 || len(kb.Facts) != 2 {
+		t.Errorf("Expected looking at the agenda to change nothing, got %v then %v", first, second)
+	}
+
+	kb = agendaKnowledgeBase(ResolveRandomly)
+	kb.Seed = 7
+	kb.Start()
+	kb.Facts["a"] = Fact{ID: "a", Value: true}
+	kb.Facts["b"] = Fact{ID: "b", Value: true}
+	first, _ = kb.Agenda()
+	second, _ = kb.Agenda()
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("Expected the same random order, got %v then %v", names(first), names(second))
+	}
+	var fired []string
+	for {
+		a, err := kb.Step()
+		if err != nil || a == nil {
+			break
+		}
+		fired = append(fired, a.Inference)
+	}
+	if !reflect.DeepEqual(fired, names(first)) {
+		t.Errorf("Expected the activations to fire in the order listed, got %v, listed %v", fired, names(first))
+	}
+}
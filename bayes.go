@@ -0,0 +1,367 @@
+package inference
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// BayesianNetwork is a discrete Bayesian network evaluated next to the
+// inferences. The facts with the ID of a node are its evidence; for every
+// other node the knowledge base holds the most probable state as a fact,
+// with its probability as certainty, and the posterior distribution as a
+// map of state to probability, so rules, conclusions and risk expressions
+// can read both:
+//
+//	sepsis == "yes"
+//	sepsis_posterior.yes > 0.7
+//
+// Facts that are not fully certain are soft evidence: the observed state is
+// taken as likely as the certainty of the fact. Posteriors are computed
+// exactly by variable elimination whenever the evidence changes.
+type BayesianNetwork struct {
+	Nodes []BayesNode `json:"nodes"`
+}
+
+// BayesNode is a variable of a BayesianNetwork with its conditional
+// probability table. CPT has a row per combination of the states of the
+// parents, the first parent varying the slowest, and each row has the
+// probability of every state of the node given that combination.
+type BayesNode struct {
+	ID      string      `json:"id"`
+	States  []string    `json:"states"`
+	Parents []string    `json:"parents,omitempty"`
+	CPT     [][]float64 `json:"cpt"`
+	// Posterior is the ID of the fact holding the posterior distribution,
+	// ID + "_posterior" unless set
+	Posterior string `json:"posterior,omitempty"`
+}
+
+// beliefJustification names the network in the justifications of the facts
+// it writes.
+const beliefJustification = "bayesian network"
+
+func (n *BayesNode) posteriorID() string {
+	if n.Posterior != "" {
+		return n.Posterior
+	}
+	return n.ID + "_posterior"
+}
+
+func (bn *BayesianNetwork) node(id string) *BayesNode {
+	for i := range bn.Nodes {
+		if bn.Nodes[i].ID == id {
+			return &bn.Nodes[i]
+		}
+	}
+	return nil
+}
+
+// Posterior returns the probability of every state of the node given the
+// observed states of other nodes.
+func (bn *BayesianNetwork) Posterior(id string, evidence map[string]string) (map[string]float64, error) {
+	likelihoods := make(map[string][]float64, len(evidence))
+	for _, observed := range sortedKeys(evidence) {
+		state := evidence[observed]
+		node := bn.node(observed)
+		if node == nil {
+			return nil, fmt.Errorf("unknown node %s", observed)
+		}
+		likelihood, err := node.likelihood(state, 1)
+		if err != nil {
+			return nil, err
+		}
+		likelihoods[observed] = likelihood
+	}
+	return bn.posterior(id, likelihoods)
+}
+
+// likelihood returns the evidence vector of an observed state, spreading
+// what is not certain evenly over the other states.
+func (n *BayesNode) likelihood(state string, certainty float64) ([]float64, error) {
+	i := slices.Index(n.States, state)
+	if i < 0 {
+		return nil, fmt.Errorf("%q is not a state of %s, expected one of %v", state, n.ID, n.States)
+	}
+	likelihood := make([]float64, len(n.States))
+	for j := range likelihood {
+		if len(n.States) > 1 {
+			likelihood[j] = (1 - certainty) / float64(len(n.States)-1)
+		}
+	}
+	likelihood[i] = certainty
+	return likelihood, nil
+}
+
+// posterior runs variable elimination, summing out every node but the
+// queried one from the product of the CPTs and the evidence.
+func (bn *BayesianNetwork) posterior(id string, likelihoods map[string][]float64) (map[string]float64, error) {
+	query := bn.node(id)
+	if query == nil {
+		return nil, fmt.Errorf("unknown node %s", id)
+	}
+	var factors []factor
+	for i := range bn.Nodes {
+		f, err := bn.cptFactor(&bn.Nodes[i])
+		if err != nil {
+			return nil, err
+		}
+		factors = append(factors, f)
+		if likelihood, ok := likelihoods[bn.Nodes[i].ID]; ok {
+			factors = append(factors, factor{vars: []string{bn.Nodes[i].ID}, card: []int{len(bn.Nodes[i].States)}, values: likelihood})
+		}
+	}
+	for _, node := range bn.eliminationOrder(factors, id) {
+		var joined *factor
+		kept := factors[:0:0]
+		for _, f := range factors {
+			if !slices.Contains(f.vars, node) {
+				kept = append(kept, f)
+				continue
+			}
+			if joined == nil {
+				joined = &f
+			} else {
+				product := joined.multiply(f)
+				joined = &product
+			}
+		}
+		if joined != nil {
+			kept = append(kept, joined.sumOut(node))
+		}
+		factors = kept
+	}
+	result := factor{values: []float64{1}}
+	for _, f := range factors {
+		result = result.multiply(f)
+	}
+
+	total := 0.0
+	for _, p := range result.values {
+		total += p
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("the evidence is impossible in the network")
+	}
+	posterior := make(map[string]float64, len(query.States))
+	for i, state := range query.States {
+		posterior[state] = result.values[i] / total
+	}
+	return posterior, nil
+}
+
+// eliminationOrder picks, greedily, the node whose elimination builds the
+// smallest factor next.
+func (bn *BayesianNetwork) eliminationOrder(factors []factor, query string) []string {
+	neighbours := make(map[string]map[string]bool)
+	for _, node := range bn.Nodes {
+		neighbours[node.ID] = make(map[string]bool)
+	}
+	for _, f := range factors {
+		for _, a := range f.vars {
+			for _, b := range f.vars {
+				if a != b {
+					neighbours[a][b] = true
+				}
+			}
+		}
+	}
+	var order []string
+	remaining := len(bn.Nodes) - 1
+	for ; remaining > 0; remaining-- {
+		best := ""
+		for _, node := range bn.Nodes {
+			if node.ID == query || neighbours[node.ID] == nil {
+				continue
+			}
+			if best == "" || len(neighbours[node.ID]) < len(neighbours[best]) {
+				best = node.ID
+			}
+		}
+		for a := range neighbours[best] {
+			delete(neighbours[a], best)
+			for b := range neighbours[best] {
+				if a != b {
+					neighbours[a][b] = true
+				}
+			}
+		}
+		delete(neighbours, best)
+		order = append(order, best)
+	}
+	return order
+}
+
+// cptFactor returns the CPT of the node as a factor over its parents and
+// itself.
+func (bn *BayesianNetwork) cptFactor(node *BayesNode) (factor, error) {
+	f := factor{vars: append(slices.Clone(node.Parents), node.ID)}
+	rows := 1
+	for _, id := range node.Parents {
+		parent := bn.node(id)
+		if parent == nil {
+			return factor{}, fmt.Errorf("unknown parent %s of %s", id, node.ID)
+		}
+		f.card = append(f.card, len(parent.States))
+		rows *= len(parent.States)
+	}
+	f.card = append(f.card, len(node.States))
+	if len(node.CPT) != rows {
+		return factor{}, fmt.Errorf("CPT of %s has %d rows, expected %d", node.ID, len(node.CPT), rows)
+	}
+	for _, row := range node.CPT {
+		if len(row) != len(node.States) {
+			return factor{}, fmt.Errorf("CPT of %s has a row of %d probabilities, expected %d", node.ID, len(row), len(node.States))
+		}
+		f.values = append(f.values, row...)
+	}
+	return f, nil
+}
+
+// factor is a table over discrete variables, the last one varying the
+// fastest.
+type factor struct {
+	vars   []string
+	card   []int
+	values []float64
+}
+
+// index returns the position in f of the assignment of vars.
+func (f factor) index(vars []string, assignment []int) int {
+	i := 0
+	for k, v := range f.vars {
+		i = i*f.card[k] + assignment[slices.Index(vars, v)]
+	}
+	return i
+}
+
+// nextAssignment advances the assignment like an odometer, the last variable the
+// fastest.
+func nextAssignment(assignment, card []int) {
+	for k := len(assignment) - 1; k >= 0; k-- {
+		assignment[k]++
+		if assignment[k] < card[k] {
+			return
+		}
+		assignment[k] = 0
+	}
+}
+
+func (f factor) multiply(g factor) factor {
+	out := factor{vars: slices.Clone(f.vars), card: slices.Clone(f.card)}
+	for k, v := range g.vars {
+		if !slices.Contains(out.vars, v) {
+			out.vars = append(out.vars, v)
+			out.card = append(out.card, g.card[k])
+		}
+	}
+	size := 1
+	for _, c := range out.card {
+		size *= c
+	}
+	out.values = make([]float64, size)
+	assignment := make([]int, len(out.vars))
+	for i := range out.values {
+		out.values[i] = f.values[f.index(out.vars, assignment)] * g.values[g.index(out.vars, assignment)]
+		nextAssignment(assignment, out.card)
+	}
+	return out
+}
+
+func (f factor) sumOut(v string) factor {
+	k := slices.Index(f.vars, v)
+	out := factor{vars: slices.Delete(slices.Clone(f.vars), k, k+1), card: slices.Delete(slices.Clone(f.card), k, k+1)}
+	size := 1
+	for _, c := range out.card {
+		size *= c
+	}
+	out.values = make([]float64, size)
+	assignment := make([]int, len(f.vars))
+	for _, value := range f.values {
+		out.values[out.index(f.vars, assignment)] += value
+		nextAssignment(assignment, f.card)
+	}
+	return out
+}
+
+// updateBeliefs writes the most probable state and the posterior of the
+// unobserved nodes as facts when the evidence changed, and drops the ones
+// of nodes that became observed.
+func (kb *KnowledgeBase) updateBeliefs() error {
+	bn := kb.BayesianNetwork
+	if bn == nil || len(bn.Nodes) == 0 {
+		return nil
+	}
+	likelihoods := make(map[string][]float64)
+	var premises []string
+	for i := range bn.Nodes {
+		node := &bn.Nodes[i]
+		fact, ok := kb.Facts[node.ID]
+		if !ok || fact.believed() || fact.certainty() <= 0 {
+			continue
+		}
+		likelihood, err := node.likelihood(fmt.Sprint(fact.Value), fact.certainty())
+		if err != nil {
+			return err
+		}
+		likelihoods[node.ID] = likelihood
+		premises = append(premises, node.ID)
+	}
+
+	for i := range bn.Nodes {
+		node := &bn.Nodes[i]
+		if _, observed := likelihoods[node.ID]; observed {
+			if fact, ok := kb.Facts[node.posteriorID()]; ok && fact.believed() {
+				kb.removeFact(node.posteriorID())
+				kb.RemoveDerivedFrom(node.posteriorID())
+			}
+			continue
+		}
+		posterior, err := bn.posterior(node.ID, likelihoods)
+		if err != nil {
+			return err
+		}
+		distribution := make(map[string]interface{}, len(posterior))
+		likeliest := node.States[0]
+		for _, state := range node.States {
+			distribution[state] = posterior[state]
+			if posterior[state] > posterior[likeliest] {
+				likeliest = state
+			}
+		}
+		kb.believe(node.ID, likeliest, posterior[likeliest], premises)
+		kb.believe(node.posteriorID(), distribution, 1, premises)
+	}
+	return nil
+}
+
+// believe writes a fact computed by the network unless it is given or
+// already holds the same value and certainty.
+func (kb *KnowledgeBase) believe(id string, value interface{}, certainty float64, premises []string) {
+	fact := Fact{
+		ID:             id,
+		Value:          value,
+		DerivedFrom:    premises,
+		Justifications: []Justification{{Inference: beliefJustification, Premises: premises, Certainty: recorded(certainty)}},
+	}
+	fact.Certainty = fact.justifiedCertainty()
+	old, ok := kb.Facts[id]
+	if ok && old.isBase() {
+		return
+	}
+	if ok && reflect.DeepEqual(old.Value, fact.Value) && old.certainty() == fact.certainty() && slices.Equal(old.DerivedFrom, premises) {
+		return
+	}
+	if ok && !reflect.DeepEqual(old.Value, fact.Value) {
+		kb.RemoveDerivedFrom(id)
+	}
+	kb.Facts[id] = fact
+	kb.touch(id, -1)
+}
+
+// believed reports whether the fact was written by the Bayesian network.
+func (f *Fact) believed() bool {
+	return slices.ContainsFunc(f.Justifications, func(j Justification) bool {
+		return j.Inference == beliefJustification
+	})
+}
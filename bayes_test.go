@@ -0,0 +1,120 @@
+package inference
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func infectionNetwork() *BayesianNetwork {
+	return &BayesianNetwork{Nodes: []BayesNode{
+		{ID: "infection", States: []string{"yes", "no"}, CPT: [][]float64{{0.1, 0.9}}},
+		{ID: "fever", States: []string{"yes", "no"}, Parents: []string{"infection"}, CPT: [][]float64{{0.8, 0.2}, {0.1, 0.9}}},
+		{ID: "culture", States: []string{"positive", "negative"}, Parents: []string{"infection"}, CPT: [][]float64{{0.9, 0.1}, {0.2, 0.8}}},
+	}}
+}
+
+func TestBayesianNetwork_Posterior(t *testing.T) {
+	bn := infectionNetwork()
+	tests := []struct {
+		query    string
+		evidence map[string]string
+		state    string
+		want     float64
+	}{
+		{"infection", nil, "yes", 0.1},
+		{"fever", nil, "yes", 0.17},
+		{"infection", map[string]string{"fever": "yes"}, "yes", 0.08 / 0.17},
+		{"infection", map[string]string{"fever": "yes", "culture": "positive"}, "yes", 0.8},
+		{"culture", map[string]string{"fever": "yes"}, "positive", 0.08/0.17*0.9 + 0.09/0.17*0.2},
+	}
+	for _, tt := range tests {
+		posterior, err := bn.Posterior(tt.query, tt.evidence)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !almostEqual(posterior[tt.state], tt.want) {
+			t.Errorf("P(%s = %s | %v): expected %v, got %v", tt.query, tt.state, tt.evidence, tt.want, posterior[tt.state])
+		}
+	}
+	if _, err := bn.Posterior("infection", map[string]string{"fever": "maybe"}); err == nil {
+		t.Errorf("Expected an unknown state to fail")
+	}
+}
+
+func TestKnowledgeBase_BayesianNetwork(t *testing.T) {
+	kb := &KnowledgeBase{
+		BayesianNetwork: infectionNetwork(),
+		Inferences: []Inference{
+			{ID: "isolate", Rules: rule("infection_posterior.yes > 0.5"), FactID: "isolate", FactValue: true},
+		},
+		Conclusions: []Conclusion{{Description: "infected", Facts: []Fact{{ID: "infection", Value: "yes"}}}},
+	}
+	kb.Start()
+	kb.Infer()
+	if kb.Facts["infection"].Value != "no" || !almostEqual(kb.Facts["infection"].certainty(), 0.9) {
+		t.Errorf("Expected the prior to be believed, got %+v", kb.Facts["infection"])
+	}
+
+	kb.AddFact(Fact{ID: "fever", Value: "yes"})
+	kb.AddFact(Fact{ID: "culture", Value: "positive"})
+	infection := kb.Facts["infection"]
+	if infection.Value != "yes" || !almostEqual(infection.certainty(), 0.8) {
+		t.Fatalf("Expected infection with probability 0.8, got %+v", infection)
+	}
+	if _, ok := kb.Facts["isolate"]; !ok {
+		t.Errorf("Expected rules to read the posterior")
+	}
+	if c := kb.CertaintyForConclusion(kb.Conclusions[0]); !almostEqual(c, 0.8) {
+		t.Errorf("Expected the conclusion to take the posterior, got %v", c)
+	}
+	if _, ok := kb.Facts["culture_posterior"]; ok {
+		t.Errorf("Expected no posterior for an observed node")
+	}
+
+	kb.RetractFact("culture")
+	if !almostEqual(kb.Facts["infection_posterior"].Value.(map[string]interface{})["yes"].(float64), 0.08/0.17) {
+		t.Errorf("Expected the posterior to follow the retraction, got %v", kb.Facts["infection_posterior"].Value)
+	}
+	if _, ok := kb.Facts["isolate"]; ok {
+		t.Errorf("Expected isolate to be retracted with the evidence")
+	}
+
+	// observing a node replaces its belief
+	kb.AddFact(Fact{ID: "infection", Value: "no"})
+	if _, ok := kb.Facts["infection_posterior"]; ok {
+		t.Errorf("Expected the posterior of an observed node to be dropped")
+	}
+}
+
+func TestKnowledgeBase_BayesianSoftEvidence(t *testing.T) {
+	kb := &KnowledgeBase{BayesianNetwork: infectionNetwork()}
+	kb.Start()
+	// a fever reading that is only 50% reliable tells nothing
+	kb.AddFact(Fact{ID: "fever", Value: "yes", Certainty: CF(0.5)})
+	if !almostEqual(kb.Facts["infection_posterior"].Value.(map[string]interface{})["yes"].(float64), 0.1) {
+		t.Errorf("Expected an uninformative reading to keep the prior, got %v", kb.Facts["infection_posterior"].Value)
+	}
+}
+
+func TestKnowledgeBase_ValidateBayesianNetwork(t *testing.T) {
+	var bn BayesianNetwork
+	data := `{"nodes": [
+		{"id": "a", "states": ["yes", "no"], "parents": ["b"], "cpt": [[0.5, 0.5], [0.3, 0.6]]},
+		{"id": "b", "states": ["yes", "no"], "parents": ["a"], "cpt": [[1, 0], [0, 1]]},
+		{"id": "c", "states": ["only"], "parents": ["d"], "cpt": [[1]]}
+	]}`
+	if err := json.Unmarshal([]byte(data), &bn); err != nil {
+		t.Fatal(err)
+	}
+	diags := (&KnowledgeBase{BayesianNetwork: &bn}).Validate()
+	for _, path := range []string{
+		"bayesian_network.nodes[0].cpt[1]",
+		"bayesian_network.nodes[0].parents",
+		"bayesian_network.nodes[2].states",
+		"bayesian_network.nodes[2].parents[0]",
+	} {
+		if d, ok := diagnostic(diags, path); !ok || d.Severity != SeverityError {
+			t.Errorf("Expected an error at %s, got %v", path, diags)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package inference
+
+import (
+	"testing"
+
+	"github.com/expr-lang/expr"
+)
+
+var triageInput = map[string]Fact{
+	"temperature":       {ID: "temperature", Value: 39.5},
+	"heart_rate":        {ID: "heart_rate", Value: 120},
+	"systolic_bp":       {ID: "systolic_bp", Value: 85},
+	"oxygen_saturation": {ID: "oxygen_saturation", Value: 90},
+}
+
+// BenchmarkTriagePipeline compares compiling the expressions on every
+// evaluation with the expression cache of the rule set.
+func BenchmarkTriagePipeline(b *testing.B) {
+	for _, bench := range []struct {
+		name  string
+		limit int
+	}{{"uncached", -1}, {"cached", 0}} {
+		b.Run(bench.name, func(b *testing.B) {
+			config, err := LoadPipelineConfig("examples/triage/definition.json")
+			if err != nil {
+				b.Fatal(err)
+			}
+			pipeline := NewPipeline(*config)
+			if _, err := pipeline.NewSession(); err != nil {
+				b.Fatal(err)
+			}
+			pipeline.rules.exprs.programs.limit = bench.limit
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				session, _ := pipeline.NewSession()
+				if _, err := pipeline.RunSession(session, triageInput); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkGamificationSales compares compiling the expressions on every
+// evaluation with the expression cache of the rule set.
+func BenchmarkGamificationSales(b *testing.B) {
+	for _, bench := range []struct {
+		name  string
+		limit int
+	}{{"uncached", -1}, {"cached", 0}} {
+		b.Run(bench.name, func(b *testing.B) {
+			config, err := LoadPipelineConfig("examples/gamification/pipeline.json")
+			if err != nil {
+				b.Fatal(err)
+			}
+			rules := NewRuleSet(config.KnowledgeBase)
+			rules.exprs.programs.limit = bench.limit
+			sale := Fact{ID: "sale", Value: map[string]interface{}{"Product": "pizza", "Price": 100}}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				session := rules.NewSession()
+				for j := 0; j < 12; j++ {
+					if err := session.AddFact(sale); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkExpression compares compiling every evaluation, as the engine
+// used to, with the expression cache.
+func BenchmarkExpression(b *testing.B) {
+	const expression = "temperature > 38 && heart_rate > 100"
+
+	b.Run("compile_each_evaluation", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			env := make(map[string]interface{})
+			for k, v := range triageInput {
+				env[k] = v.Value
+			}
+			program, err := expr.Compile(expression, expr.Env(env))
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := expr.Run(program, env); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		exprs := NewExpressionCache(Schema{
+			"temperature": {Type: FactNumber},
+			"heart_rate":  {Type: FactNumber},
+		})
+		for i := 0; i < b.N; i++ {
+			if _, _, err := exprs.Evaluate(expression, triageInput); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Budget bounds the work of a single call on the knowledge base or a
+// pipeline run, where the rules of every stage count. Zero fields are not
+// limited.
+type Budget struct {
+	// MaxEvaluations bounds the rule evaluations
+	MaxEvaluations int `json:"max_evaluations,omitempty"`
+	// MaxDerivedFacts bounds the facts derived
+	MaxDerivedFacts int `json:"max_derived_facts,omitempty"`
+	// MaxDuration bounds the wall time
+	MaxDuration Duration `json:"max_duration,omitempty"`
+}
+
+// ErrBudgetExceeded is matched by every BudgetError.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// BudgetError is returned when a call runs out of one of its budgets. The
+// facts derived until then are kept.
+type BudgetError struct {
+	// Limit names the exhausted budget: max_evaluations,
+	// max_derived_facts or max_duration
+	Limit string
+	// Used is how much of the budget was used: evaluations, facts or
+	// nanoseconds
+	Used int64
+}
+
+func (e *BudgetError) Error() string {
+	if e.Limit == "max_duration" {
+		return fmt.Sprintf("%s: %s after %s", ErrBudgetExceeded, e.Limit, time.Duration(e.Used))
+	}
+	return fmt.Sprintf("%s: %s after %d", ErrBudgetExceeded, e.Limit, e.Used)
+}
+
+func (e *BudgetError) Is(target error) bool {
+	return target == ErrBudgetExceeded
+}
+
+// guard tracks the budget and the context of the call in progress.
+type guard struct {
+	ctx context.Context
+	// nested are the contexts of the calls made while guarded
+	nested      []context.Context
+	budget      Budget
+	start       time.Time
+	evaluations int
+	derived     int
+	// strict fails the call on evaluation errors, see KnowledgeBase.Strict
+	strict bool
+	// observer receives the events of the call, see WithObserver, stage
+	// is the pipeline stage running
+	observer Observer
+	stage    string
+	// err is kept once the call is aborted so the calls it makes fail too
+	err error
+}
+
+// enter starts guarding a call with the context and budget, which may be
+// nil, and returns the function that ends it. Calls made while another one
+// is guarded share its guard and its budget, and stop when either context
+// is done. A new call starts with no diagnostics.
+func (kb *KnowledgeBase) enter(ctx context.Context, budget *Budget) func() {
+	if g := kb.guard; g != nil {
+		if ctx == g.ctx {
+			return func() {}
+		}
+		g.nested = append(g.nested, ctx)
+		return func() { g.nested = g.nested[:len(g.nested)-1] }
+	}
+	kb.diagnostics = nil
+	g := &guard{ctx: ctx, start: time.Now(), observer: observerFrom(ctx)}
+	if budget != nil {
+		g.budget = *budget
+	}
+	kb.guard = g
+	return func() { kb.guard = nil }
+}
+
+// spend accounts for rule evaluations about to be made and fails when the
+// context is done or a budget is exhausted.
+func (kb *KnowledgeBase) spend(evaluations int) error {
+	g := kb.guard
+	if g == nil {
+		return nil
+	}
+	if g.err != nil {
+		return g.err
+	}
+	g.evaluations += evaluations
+	b := g.budget
+	switch {
+	case g.ctx.Err() != nil:
+		g.err = g.ctx.Err()
+	case b.MaxEvaluations > 0 && g.evaluations > b.MaxEvaluations:
+		g.err = &BudgetError{Limit: "max_evaluations", Used: int64(g.evaluations - evaluations)}
+	case b.MaxDerivedFacts > 0 && g.derived > b.MaxDerivedFacts:
+		g.err = &BudgetError{Limit: "max_derived_facts", Used: int64(g.derived)}
+	case b.MaxDuration > 0 && time.Since(g.start) > time.Duration(b.MaxDuration):
+		g.err = &BudgetError{Limit: "max_duration", Used: int64(time.Since(g.start))}
+	}
+	if g.err != nil {
+		return g.err
+	}
+	// a nested call stops without stopping the call that made it
+	for _, ctx := range g.nested {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// spender accounts for evaluations about to be made, like
+// KnowledgeBase.spend, and stops them with its error.
+type spender func(evaluations int) error
+
+// contextSpender stops the evaluations when ctx is done.
+func contextSpender(ctx context.Context) spender {
+	return func(int) error {
+		return ctx.Err()
+	}
+}
+
+// countDerived accounts for a derived fact.
+func (kb *KnowledgeBase) countDerived() {
+	if kb.guard != nil {
+		kb.guard.derived++
+	}
+}
+
+// aborted reports whether the error stops a run: a cancelled context or an
+// exhausted budget.
+func aborted(err error) bool {
+	return errors.Is(err, ErrBudgetExceeded) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
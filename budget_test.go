@@ -0,0 +1,180 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// runaway returns a knowledge base whose inferences keep counting forever
+// once n is known.
+func runaway() *KnowledgeBase {
+	kb := &KnowledgeBase{
+		MaxIterations: 1000,
+		Inferences: []Inference{
+			{ID: "tick", Rules: rule("n >= 0"), FactID: "m", FactValue: "n + 1", IsValeCalculated: true, OverWrite: true},
+			{ID: "tock", Rules: rule("m >= 0"), FactID: "n", FactValue: "m + 1", IsValeCalculated: true, OverWrite: true},
+		},
+	}
+	kb.Start()
+	return kb
+}
+
+func TestKnowledgeBase_MaxEvaluations(t *testing.T) {
+	kb := runaway()
+	kb.Budget = &Budget{MaxEvaluations: 10}
+	err := kb.AddFact(Fact{ID: "n", Value: 0})
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) || !errors.Is(err, ErrBudgetExceeded) || budgetErr.Limit != "max_evaluations" {
+		t.Fatalf("Expected the evaluations budget to run out, got %v", err)
+	}
+	if kb.Facts["n"].Value != 10 {
+		t.Errorf("Expected the facts derived within the budget kept, got %v", kb.Facts["n"].Value)
+	}
+	// every call has its own budget
+	if err := kb.Infer(); !errors.Is(err, ErrBudgetExceeded) || kb.Facts["n"].Value != 20 {
+		t.Errorf("Expected the next call to run 10 more evaluations, got %v, %v", err, kb.Facts["n"].Value)
+	}
+}
+
+func TestKnowledgeBase_MaxDerivedFacts(t *testing.T) {
+	kb := &KnowledgeBase{
+		Budget: &Budget{MaxDerivedFacts: 2},
+		Inferences: []Inference{
+			{ID: "b", Rules: rule("a"), FactID: "b", FactValue: true},
+			{ID: "c", Rules: rule("b"), FactID: "c", FactValue: true},
+			{ID: "d", Rules: rule("c"), FactID: "d", FactValue: true},
+			{ID: "e", Rules: rule("d"), FactID: "e", FactValue: true},
+		},
+	}
+	kb.Start()
+	err := kb.AddFact(Fact{ID: "a", Value: true})
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) || budgetErr.Limit != "max_derived_facts" {
+		t.Fatalf("Expected the derived facts budget to run out, got %v", err)
+	}
+	if _, ok := kb.Facts["e"]; ok {
+		t.Errorf("Expected the chaining to stop, got %v", kb.Facts)
+	}
+	kb.Budget = nil
+	if err := kb.Infer(); err != nil || kb.Facts["e"].Value != true {
+		t.Errorf("Expected the inferences left dirty to run in the next call, got %v, %v", err, kb.Facts)
+	}
+}
+
+func TestKnowledgeBase_InferContext(t *testing.T) {
+	kb := runaway()
+	kb.Facts["n"] = Fact{ID: "n", Value: 0}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := kb.InferContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the cancelled context to stop the inferences, got %v", err)
+	}
+	if kb.Facts["n"].Value != 0 {
+		t.Errorf("Expected no evaluation, got %v", kb.Facts["n"].Value)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	kb.MaxIterations = 1 << 30
+	if err := kb.InferContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to stop the inferences, got %v", err)
+	}
+}
+
+func TestKnowledgeBase_MaxDuration(t *testing.T) {
+	kb := runaway()
+	kb.MaxIterations = 1 << 30
+	kb.Budget = &Budget{MaxDuration: Duration(10 * time.Millisecond)}
+	err := kb.AddFactContext(context.Background(), Fact{ID: "n", Value: 0})
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) || budgetErr.Limit != "max_duration" {
+		t.Errorf("Expected the wall time budget to run out, got %v", err)
+	}
+}
+
+func TestPipeline_RunContextBudget(t *testing.T) {
+	kb := runaway()
+	kb.Conclusions = []Conclusion{{Description: "Counting", Facts: []Fact{{ID: "n", Value: 2}}}}
+	config := PipelineConfig{
+		KnowledgeBase: kb,
+		Budget:        &Budget{MaxEvaluations: 3},
+		RiskAnalyzer:  &RiskAnalyzer{Risks: []Risk{{Description: "never analyzed", Expression: "true", Level: RiskHigh}}},
+	}
+	result, err := NewPipeline(config).RunContext(context.Background(), map[string]Fact{"n": {ID: "n", Value: 0}})
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("Expected the run to stop on its budget, got %v", err)
+	}
+	if result == nil || result.Result != "Counting" {
+		t.Fatalf("Expected a partial result, got %+v", result)
+	}
+	if len(result.Risks) > 0 || !slices.ContainsFunc(result.Reasoning.Signals, func(s string) bool { return s == "Pipeline stopped at facts: "+err.Error() }) {
+		t.Errorf("Expected the stages after the budget ran out skipped, got %v, %v", result.Risks, result.Reasoning.Signals)
+	}
+
+	if diags := (&PipelineConfig{KnowledgeBase: &KnowledgeBase{}, Budget: &Budget{MaxEvaluations: -1}}).Validate(); !diags.HasErrors() {
+		t.Errorf("Expected a negative budget to be reported")
+	}
+}
+
+func TestAnalyzers_Context(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	facts := map[string]Fact{"amount": {ID: "amount", Value: 10}}
+
+	classifier := &IntentClassifier{Rules: []IntentRule{{Expression: "amount > 5", IntentType: IntentDecision, Weight: 1}}}
+	if _, err := classifier.ClassifyContext(ctx, facts); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the classification cancelled, got %v", err)
+	}
+	extractor := &EntityExtractor{Rules: []ExtractionRule{{FactID: "big", Expression: "amount > 5", ConfidenceValue: 1}}}
+	if entities, err := extractor.ExtractContext(ctx, facts); !errors.Is(err, context.Canceled) || len(entities) > 0 {
+		t.Errorf("Expected the extraction cancelled, got %v, %v", entities, err)
+	}
+	set := &ConstraintSet{Constraints: []Constraint{{Description: "small", Type: ConstraintHard, Expression: "amount < 5"}}}
+	if _, err := set.IdentifyContext(ctx, facts); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the identification cancelled, got %v", err)
+	}
+	detector := &DomainDetector{Signals: map[Domain][]string{DomainData: {"amount"}}}
+	if _, err := detector.DetectContext(ctx, facts); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the detection cancelled, got %v", err)
+	}
+	if domain, err := detector.DetectContext(context.Background(), facts); err != nil || domain != DomainData {
+		t.Errorf("Expected the detection to run, got %v, %v", domain, err)
+	}
+}
+
+func TestPipeline_IntentBudget(t *testing.T) {
+	kb := &KnowledgeBase{}
+	kb.Start()
+	rules := make([]IntentRule, 10)
+	for i := range rules {
+		rules[i] = IntentRule{Expression: "true", IntentType: IntentQuery, Weight: 1}
+	}
+	config := PipelineConfig{
+		KnowledgeBase:    kb,
+		Budget:           &Budget{MaxEvaluations: 5},
+		IntentClassifier: &IntentClassifier{Rules: rules},
+	}
+	_, err := NewPipeline(config).RunContext(context.Background(), map[string]Fact{"n": {ID: "n", Value: 0}})
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) || budgetErr.Limit != "max_evaluations" {
+		t.Errorf("Expected the intent rules charged to the budget, got %v", err)
+	}
+}
+
+func TestKnowledgeBase_NestedContext(t *testing.T) {
+	kb := runaway()
+	kb.Facts["n"] = Fact{ID: "n", Value: 0}
+	leave := kb.enter(context.Background(), nil)
+	defer leave()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := kb.InferContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the context of the nested call to stop it, got %v", err)
+	}
+	if err := kb.spend(1); err != nil {
+		t.Errorf("Expected the outer call to go on, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package inference
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Support is the weighted evaluation of the rules of an inference. Every
+// share is a fraction of the total weight of the rules, so they add up to 1.
+// Rules without weights count equally.
+type Support struct {
+	// Certainty is the weight of the rules that hold, each discounted by the
+	// certainty of the facts it reads
+	Certainty float64 `json:"certainty"`
+	// Unknown is the weight of the rules that read facts not known yet
+	Unknown float64 `json:"unknown"`
+	// Failed is the weight of the rules that do not hold or cannot be
+	// evaluated
+	Failed float64 `json:"failed"`
+}
+
+// Possible is the highest certainty the inference can still reach once the
+// unknown facts are known.
+func (s Support) Possible() float64 {
+	return 1 - s.Failed
+}
+
+// support evaluates the rules of the inference. It returns the facts read by
+// the rules that hold and the first reason a rule does not hold, if any.
+func (inf *Inference) support(exprs *ExpressionCache, facts map[string]Fact) (Support, []string, error) {
+	if len(inf.Rules) == 0 {
+		return Support{Certainty: 1}, nil, nil
+	}
+	total := 0.0
+	for _, rule := range inf.Rules {
+		total += rule.Weight
+	}
+	weight := func(rule WeightedRule) float64 {
+		if total == 0 {
+			return 1 / float64(len(inf.Rules))
+		}
+		return rule.Weight / total
+	}
+
+	var support Support
+	var premises []string
+	var failure error
+	for _, rule := range inf.Rules {
+		result, d, err := rule.evaluateIn(exprs, facts)
+		switch {
+		case errors.Is(err, ErrUnknownFact):
+			support.Unknown += weight(rule)
+		case err != nil:
+			support.Failed += weight(rule)
+		case !result:
+			support.Failed += weight(rule)
+			err = fmt.Errorf("rule %s is false", rule.Description)
+		default:
+			support.Certainty += weight(rule) * max(0, minCertainty(facts, d))
+			premises = append(premises, d...)
+		}
+		// an expression that fails explains the most
+		var evalErr *EvaluationError
+		if failure == nil || (errors.As(err, &evalErr) && !errors.As(failure, &evalErr)) {
+			failure = err
+		}
+	}
+	return support, premises, failure
+}
+
+// certainty returns the certainty factor of the fact, between -1 and 1.
+// Facts that do not record a certainty are fully certain.
+func (f Fact) certainty() float64 {
+	if f.Certainty == nil {
+		return 1
+	}
+	return *f.Certainty
+}
+
+// minCertainty returns the certainty of the least certain of the facts.
+func minCertainty(facts map[string]Fact, ids []string) float64 {
+	certainty := 1.0
+	for _, id := range ids {
+		if fact, ok := facts[id]; ok {
+			certainty = min(certainty, fact.certainty())
+		}
+	}
+	return certainty
+}
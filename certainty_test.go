@@ -0,0 +1,111 @@
+package inference
+
+import (
+	"math"
+	"testing"
+)
+
+func sepsisInference() Inference {
+	return Inference{
+		ID: "sepsis",
+		Rules: []WeightedRule{
+			{Rule: Rule{Description: "fever", Expression: "temperature > 38"}, Weight: 0.5},
+			{Rule: Rule{Description: "tachycardia", Expression: "heart_rate > 90"}, Weight: 0.25},
+			{Rule: Rule{Description: "tachypnea", Expression: "respiratory_rate > 20"}, Weight: 0.25},
+		},
+		FactID:    "sepsis_suspected",
+		FactValue: true,
+	}
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestInference_Support(t *testing.T) {
+	inf := sepsisInference()
+	facts := map[string]Fact{
+		"temperature": {ID: "temperature", Value: 39},
+		"heart_rate":  {ID: "heart_rate", Value: 80},
+	}
+
+	support := inf.Support(facts)
+	if !almostEqual(support.Certainty, 0.5) || !almostEqual(support.Failed, 0.25) || !almostEqual(support.Unknown, 0.25) {
+		t.Errorf("Unexpected support %+v", support)
+	}
+	if !almostEqual(support.Possible(), 0.75) {
+		t.Errorf("Expected 0.75 to be possible, got %v", support.Possible())
+	}
+	if !almostEqual(inf.Certainty(facts), 0.5) {
+		t.Errorf("Expected certainty 0.5, got %v", inf.Certainty(facts))
+	}
+
+	pending := inf.PendingRules(facts)
+	if len(pending) != 1 || pending[0].Description != "tachypnea" {
+		t.Errorf("Expected only the unknown rule to be pending, got %v", pending)
+	}
+}
+
+func TestInference_CertaintyWithoutWeights(t *testing.T) {
+	inf := Inference{Rules: []WeightedRule{
+		{Rule: Rule{Expression: "a"}},
+		{Rule: Rule{Expression: "b"}},
+	}}
+	facts := map[string]Fact{"a": {ID: "a", Value: true}, "b": {ID: "b", Value: false}}
+	if !almostEqual(inf.Certainty(facts), 0.5) {
+		t.Errorf("Expected rules without weights to count equally, got %v", inf.Certainty(facts))
+	}
+	if (&Inference{}).Certainty(facts) != 1 {
+		t.Errorf("Expected an inference without rules to be certain")
+	}
+}
+
+func TestPendingRules_FractionalWeights(t *testing.T) {
+	inf := Inference{Rules: []WeightedRule{
+		{Rule: Rule{Description: "heavy", Expression: "a"}, Weight: 0.9},
+		{Rule: Rule{Description: "light", Expression: "b"}, Weight: 0.2},
+	}}
+	pending := inf.PendingRules(map[string]Fact{})
+	if len(pending) != 2 || pending[0].Description != "light" {
+		t.Errorf("Expected pending rules sorted by weight, got %v", pending)
+	}
+}
+
+func TestKnowledgeBase_InferThreshold(t *testing.T) {
+	inf := sepsisInference()
+	inf.Threshold = 0.7
+	kb := &KnowledgeBase{Inferences: []Inference{
+		inf,
+		{ID: "escalate", Rules: rule("sepsis_suspected"), FactID: "escalate", FactValue: true},
+	}}
+	kb.Start()
+
+	kb.AddFact(Fact{ID: "temperature", Value: 39})
+	if _, ok := kb.Facts["sepsis_suspected"]; ok {
+		t.Fatalf("Expected certainty 0.5 not to reach the threshold")
+	}
+
+	// the respiratory rate is still unknown
+	kb.AddFact(Fact{ID: "heart_rate", Value: 100, Certainty: CF(0.8)})
+	sepsis, ok := kb.Facts["sepsis_suspected"]
+	if !ok {
+		t.Fatalf("Expected the threshold to be reached")
+	}
+	if !almostEqual(sepsis.certainty(), 0.7) {
+		t.Errorf("Expected certainty 0.5 + 0.25 * 0.8, got %v", sepsis.certainty())
+	}
+	if !almostEqual(kb.Facts["escalate"].certainty(), 0.7) {
+		t.Errorf("Expected the certainty to propagate, got %v", kb.Facts["escalate"].certainty())
+	}
+
+	kb.AddFact(Fact{ID: "respiratory_rate", Value: 24})
+	if !almostEqual(kb.Facts["sepsis_suspected"].certainty(), 0.95) || !almostEqual(kb.Facts["escalate"].certainty(), 0.95) {
+		t.Errorf("Expected certainty 0.95 once more rules hold, got %v and %v",
+			kb.Facts["sepsis_suspected"].certainty(), kb.Facts["escalate"].certainty())
+	}
+
+	conclusion := Conclusion{Facts: []Fact{{ID: "escalate", Value: true}}}
+	if !almostEqual(conclusion.Certainty(kb.Facts), 0.95) {
+		t.Errorf("Expected the conclusion to take the fact certainty, got %v", conclusion.Certainty(kb.Facts))
+	}
+}
@@ -0,0 +1,67 @@
+package inference
+
+import "math"
+
+// Certainty factors follow MYCIN: a fact carries a CF between -1 and 1,
+// where positive values are evidence for the fact and negative ones evidence
+// against it. An inference concludes its fact with its own CF scaled by the
+// CF of its premises, and the CFs of several inferences concluding the same
+// fact are combined with combineCF.
+
+// cf returns the certainty factor of the inference itself. Inferences that
+// do not set one are fully certain.
+func (inf *Inference) cf() float64 {
+	if inf.CF == 0 {
+		return 1
+	}
+	return inf.CF
+}
+
+// certainty returns the CF the inference gave the fact. Justifications that
+// do not record one are fully certain.
+func (j Justification) certainty() float64 {
+	if j.Certainty == nil {
+		return 1
+	}
+	return *j.Certainty
+}
+
+// CF returns the certainty factor to set on a Fact or a Justification.
+func CF(cf float64) *float64 {
+	return &cf
+}
+
+// recorded returns the certainty factor to record, nil when fully certain.
+func recorded(cf float64) *float64 {
+	if cf == 1 {
+		return nil
+	}
+	return CF(cf)
+}
+
+// combineCF combines two certainty factors for the same fact with the MYCIN
+// parallel combination: evidence in the same direction reinforces, evidence
+// in opposite directions cancels out. Certain evidence for and against the
+// fact leaves it with no evidence either way.
+func combineCF(a, b float64) float64 {
+	switch {
+	case math.Abs(a) == 1 && math.Abs(b) == 1 && a != b:
+		return 0
+	case a >= 0 && b >= 0:
+		return a + b*(1-a)
+	case a < 0 && b < 0:
+		return a + b*(1+a)
+	default:
+		return (a + b) / (1 - math.Min(math.Abs(a), math.Abs(b)))
+	}
+}
+
+// CombineCF combines the certainty factors of independent evidence for the
+// same fact. It returns 0 when there is none.
+func CombineCF(cfs ...float64) float64 {
+	combined := 0.0
+	for _, cf := range cfs {
+		combined = combineCF(combined, cf)
+	}
+	return combined
+}
@@ -0,0 +1,107 @@
+package inference
+
+import "testing"
+
+func TestCombineCF(t *testing.T) {
+	tests := []struct {
+		name string
+		cfs  []float64
+		want float64
+	}{
+		{"none", nil, 0},
+		{"single", []float64{0.6}, 0.6},
+		{"both for", []float64{0.6, 0.5}, 0.8},
+		{"both against", []float64{-0.6, -0.5}, -0.8},
+		{"opposite", []float64{0.8, -0.4}, 2.0 / 3},
+		{"cancel out", []float64{0.5, -0.5}, 0},
+		{"certain", []float64{1, 0.3}, 1},
+		{"certain both ways", []float64{1, -1}, 0},
+	}
+	for _, tt := range tests {
+		if got := CombineCF(tt.cfs...); !almostEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestKnowledgeBase_CertaintyFactors(t *testing.T) {
+	kb := &KnowledgeBase{
+		Inferences: []Inference{
+			{ID: "culture", Rules: rule("gram_negative"), FactID: "bacterial", FactValue: true, CF: 0.7},
+			{ID: "fever", Rules: rule("temperature > 38"), FactID: "bacterial", FactValue: true, CF: 0.4},
+			{ID: "treat", Rules: rule("bacterial"), FactID: "antibiotics", FactValue: true, CF: 0.5},
+		},
+		Conclusions: []Conclusion{{Description: "treat", Facts: []Fact{{ID: "antibiotics", Value: true}}}},
+	}
+	kb.Start()
+
+	// the lab result is 80% reliable
+	kb.AddFact(Fact{ID: "gram_negative", Value: true, Certainty: CF(0.8)})
+	if !almostEqual(kb.Facts["bacterial"].certainty(), 0.56) {
+		t.Errorf("Expected CF 0.7 * 0.8, got %v", kb.Facts["bacterial"].certainty())
+	}
+
+	kb.AddFact(Fact{ID: "temperature", Value: 39})
+	bacterial := kb.Facts["bacterial"]
+	if len(bacterial.Justifications) != 2 || !almostEqual(bacterial.certainty(), 0.56+0.4*(1-0.56)) {
+		t.Errorf("Expected both inferences to combine, got %+v", bacterial)
+	}
+	want := 0.5 * bacterial.certainty()
+	if !almostEqual(kb.Facts["antibiotics"].certainty(), want) {
+		t.Errorf("Expected the combined CF to propagate, got %v", kb.Facts["antibiotics"].certainty())
+	}
+	if got := kb.CertaintyForConclusion(kb.Conclusions[0]); !almostEqual(got, want) {
+		t.Errorf("Expected the conclusion to take the CF of its fact, got %v", got)
+	}
+}
+
+func TestKnowledgeBase_CertaintyCancelsOut(t *testing.T) {
+	kb := &KnowledgeBase{
+		Inferences: []Inference{
+			{ID: "for", Rules: rule("a"), FactID: "c", FactValue: true, CF: 0.5},
+			{ID: "against", Rules: rule("b"), FactID: "c", FactValue: true, CF: -0.5},
+			{ID: "then", Rules: rule("c"), FactID: "d", FactValue: true},
+		},
+	}
+	kb.Start()
+	kb.AddFact(Fact{ID: "b", Value: true})
+	kb.AddFact(Fact{ID: "a", Value: true})
+	c := kb.Facts["c"]
+	if len(c.Justifications) != 2 || c.Certainty == nil || c.certainty() != 0 {
+		t.Errorf("Expected the opposite CFs to leave no evidence, got %+v", c)
+	}
+	if _, ok := kb.Facts["d"]; ok {
+		t.Errorf("Expected a fact without evidence not to support others")
+	}
+
+	kb.AddFact(Fact{ID: "e", Value: true, Certainty: CF(0)})
+	if kb.Facts["e"].certainty() != 0 {
+		t.Errorf("Expected a given CF of 0 kept, got %v", kb.Facts["e"].certainty())
+	}
+}
+
+func TestInference_DisbelievedPremise(t *testing.T) {
+	inf := Inference{Rules: rule("viral"), FactID: "antibiotics", FactValue: false, CF: -0.6}
+	if _, err := inf.infer(defaultExpressions, map[string]Fact{"viral": {ID: "viral", Value: true, Certainty: CF(-0.5)}}); err == nil {
+		t.Errorf("Expected a premise with a negative CF not to fire")
+	}
+	result, err := inf.infer(defaultExpressions, map[string]Fact{"viral": {ID: "viral", Value: true, Certainty: CF(0.5)}})
+	if err != nil || !almostEqual(result.certainty, -0.3) {
+		t.Errorf("Expected CF -0.6 * 0.5, got %v, %v", result.certainty, err)
+	}
+}
+
+func TestConclusion_CertaintyConjunction(t *testing.T) {
+	c := Conclusion{Facts: []Fact{{ID: "a", Value: true}, {ID: "b", Value: true}}}
+	facts := map[string]Fact{
+		"a": {ID: "a", Value: true, Certainty: CF(0.9)},
+		"b": {ID: "b", Value: true, Certainty: CF(0.6)},
+	}
+	if !almostEqual(c.Certainty(facts), 0.6) {
+		t.Errorf("Expected the weakest fact to bound the conclusion, got %v", c.Certainty(facts))
+	}
+	delete(facts, "b")
+	if !almostEqual(c.Certainty(facts), 0.45) {
+		t.Errorf("Expected a partial match to stay partial, got %v", c.Certainty(facts))
+	}
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"crypto/rand"
 	"embed"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"sync"
+	"time"
 
 	inference "github.com/diogenes-moreira/inference-engine"
 )
@@ -19,12 +23,33 @@ var staticFiles embed.FS
 //go:embed examples
 var exampleFiles embed.FS
 
+// The loaded pipeline is shared by every visitor, each one gets its own
+// session identified by a cookie so concurrent users do not see each other's
+// facts.
 var (
-	mu             sync.Mutex
-	currentConfig  *inference.PipelineConfig
+	mu              sync.Mutex
 	currentPipeline *inference.Pipeline
+	sessions        = map[string]*visitor{}
 )
 
+const sessionCookie = "session_id"
+
+// Sessions idle for sessionIdle are dropped, and the least recently used
+// ones once there are maxSessions, so clients without cookies cannot grow
+// the sessions forever.
+const (
+	sessionIdle = 30 * time.Minute
+	maxSessions = 1000
+)
+
+var errNoPipeline = errors.New("no pipeline loaded, call /api/pipeline/load first")
+
+// visitor is the session of a visitor with when it was last used.
+type visitor struct {
+	session  *inference.Session
+	lastSeen time.Time
+}
+
 type ExampleInfo struct {
 	ID          string `json:"id"`
 	Name        string `json:"name"`
@@ -43,6 +68,7 @@ func main() {
 	http.HandleFunc("/api/pipeline/pending", handlePipelinePending)
 	http.HandleFunc("/api/pipeline/reset", handlePipelineReset)
 	http.HandleFunc("/api/pipeline/load", handlePipelineLoad)
+	http.HandleFunc("/api/pipeline/graph", handlePipelineGraph)
 
 	port := "8080"
 	if p := os.Getenv("PORT"); p != "" {
@@ -98,10 +124,19 @@ func handlePipelineLoad(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if config.KnowledgeBase == nil {
+		http.Error(w, "Config has no knowledge base", http.StatusInternalServerError)
+		return
+	}
+
+	if err := config.TypeCheck(); err != nil {
+		http.Error(w, "Invalid config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	mu.Lock()
-	currentConfig = &config
-	currentConfig.KnowledgeBase.Start()
-	currentPipeline = inference.NewPipeline(*currentConfig)
+	currentPipeline = inference.NewPipeline(config)
+	sessions = map[string]*visitor{}
 	mu.Unlock()
 
 	w.Header().Set("Content-Type", "application/json")
@@ -120,55 +155,140 @@ func handlePipelineRun(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mu.Lock()
-	defer mu.Unlock()
-
-	if currentPipeline == nil {
-		http.Error(w, "No pipeline loaded. Call /api/pipeline/load first.", http.StatusBadRequest)
+	pipeline, session, err := currentSession(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	result, err := currentPipeline.Run(inputFacts)
+	// ?trace=true adds the events of the run to the result
+	ctx := r.Context()
+	var recorder *inference.Recorder
+	if r.URL.Query().Get("trace") == "true" {
+		recorder = inference.NewRecorder()
+		ctx = inference.WithObserver(ctx, recorder)
+	}
+	result, err := pipeline.RunSessionContext(ctx, session, inputFacts)
+	var invalid inference.ValidationErrors
+	if errors.As(err, &invalid) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": invalid})
+		return
+	}
 	if err != nil {
 		http.Error(w, "Pipeline error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if recorder != nil {
+		json.NewEncoder(w).Encode(struct {
+			*inference.PipelineResult
+			Trace inference.Trace `json:"trace"`
+		}{result, recorder.Trace()})
+		return
+	}
 	json.NewEncoder(w).Encode(result)
 }
 
 func handlePipelinePending(w http.ResponseWriter, r *http.Request) {
-	mu.Lock()
-	defer mu.Unlock()
-
-	if currentConfig == nil || currentConfig.KnowledgeBase == nil {
-		http.Error(w, "No pipeline loaded", http.StatusBadRequest)
+	_, session, err := currentSession(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	pending := currentConfig.KnowledgeBase.GetPendingInference()
+	pending := session.GetPendingInference()
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(pending)
 }
 
+// handlePipelineGraph returns the dependency graph of the loaded inferences
+// as JSON, or in the Graphviz DOT language with ?format=dot.
+func handlePipelineGraph(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	pipeline := currentPipeline
+	mu.Unlock()
+	if pipeline == nil {
+		http.Error(w, errNoPipeline.Error(), http.StatusBadRequest)
+		return
+	}
+
+	graph := pipeline.Config.KnowledgeBase.DependencyGraph()
+	if r.URL.Query().Get("format") == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		fmt.Fprint(w, graph.DOT())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(graph)
+}
+
 func handlePipelineReset(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	_, session, err := currentSession(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	session.Reset()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "reset"})
+}
+
+// currentSession returns the loaded pipeline and the session of the visitor,
+// starting a new one when the request has no valid session cookie.
+func currentSession(w http.ResponseWriter, r *http.Request) (*inference.Pipeline, *inference.Session, error) {
 	mu.Lock()
 	defer mu.Unlock()
 
-	if currentConfig == nil || currentConfig.KnowledgeBase == nil {
-		http.Error(w, "No pipeline loaded", http.StatusBadRequest)
-		return
+	if currentPipeline == nil {
+		return nil, nil, errNoPipeline
+	}
+	now := time.Now()
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if v, ok := sessions[cookie.Value]; ok && now.Sub(v.lastSeen) < sessionIdle {
+			v.lastSeen = now
+			return currentPipeline, v.session, nil
+		}
 	}
+	pruneSessions(now)
 
-	currentConfig.KnowledgeBase.Start()
-	currentPipeline = inference.NewPipeline(*currentConfig)
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, nil, err
+	}
+	id := hex.EncodeToString(buf)
+	session, err := currentPipeline.NewSession()
+	if err != nil {
+		return nil, nil, err
+	}
+	sessions[id] = &visitor{session: session, lastSeen: now}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: id, Path: "/", HttpOnly: true, MaxAge: int(sessionIdle.Seconds())})
+	return currentPipeline, session, nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "reset"})
+// pruneSessions drops the idle sessions and, when there are still
+// maxSessions, the least recently used one to make room for a new one. It
+// must be called with mu held.
+func pruneSessions(now time.Time) {
+	oldest := ""
+	for id, v := range sessions {
+		if now.Sub(v.lastSeen) >= sessionIdle {
+			delete(sessions, id)
+			continue
+		}
+		if oldest == "" || v.lastSeen.Before(sessions[oldest].lastSeen) {
+			oldest = id
+		}
+	}
+	if len(sessions) >= maxSessions {
+		delete(sessions, oldest)
+	}
 }
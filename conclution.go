@@ -18,13 +18,17 @@ func (c *Conclusion) Assert(facts map[string]Fact) bool {
 	return true
 }
 
-// Certainty returns the certainty of the conclusion given the facts even if
-// some facts are missing.
+// Certainty returns the certainty factor of the conclusion given the facts
+// even if some facts are missing. The facts combine as a conjunction, taking
+// the CF of the least certain one, scaled by the fraction of the facts that
+// are known so a partial match stays partial. A fact with another value
+// makes the conclusion 0.
 func (c *Conclusion) Certainty(facts map[string]Fact) float64 {
 	if len(c.Facts) == 0 {
 		return 0
 	}
-	trueCount := 0
+	certainty := 1.0
+	known := 0
 	for _, fact := range c.Facts {
 		f, ok := facts[fact.ID]
 		if !ok {
@@ -33,7 +37,11 @@ func (c *Conclusion) Certainty(facts map[string]Fact) float64 {
 		if f.Value != fact.Value {
 			return 0
 		}
-		trueCount++
+		certainty = min(certainty, f.certainty())
+		known++
 	}
-	return float64(trueCount) / float64(len(c.Facts))
+	if known == 0 {
+		return 0
+	}
+	return certainty * float64(known) / float64(len(c.Facts))
 }
@@ -9,7 +9,8 @@ const (
 	ConfidenceLow    ConfidenceLevel = "low"
 )
 
-// ComputeConfidence maps a certainty value (0-1) to a ConfidenceLevel.
+// ComputeConfidence maps a certainty factor to a ConfidenceLevel. Negative
+// certainty factors, evidence against the result, are low confidence.
 func ComputeConfidence(certainty float64) ConfidenceLevel {
 	if certainty >= 0.8 {
 		return ConfidenceHigh
@@ -28,3 +28,9 @@ func TestComputeConfidence_Low(t *testing.T) {
 		t.Error("Expected low confidence for 0.49")
 	}
 }
+
+func TestComputeConfidence_NegativeCF(t *testing.T) {
+	if ComputeConfidence(-0.9) != ConfidenceLow {
+		t.Error("Expected low confidence for evidence against the result")
+	}
+}
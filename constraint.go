@@ -1,7 +1,8 @@
 package inference
 
 import (
-	"github.com/expr-lang/expr"
+	"context"
+	"fmt"
 )
 
 // ConstraintType represents whether a constraint is hard (must satisfy) or soft (should satisfy).
@@ -22,15 +23,11 @@ type Constraint struct {
 
 // Satisfied evaluates whether this constraint is met given the current facts.
 func (c *Constraint) Satisfied(facts map[string]Fact) (bool, error) {
-	env := make(map[string]interface{})
-	for k, v := range facts {
-		env[k] = v.Value
-	}
-	program, err := expr.Compile(c.Expression, expr.Env(env))
-	if err != nil {
-		return false, err
-	}
-	output, err := expr.Run(program, env)
+	return c.satisfied(defaultExpressions, facts)
+}
+
+func (c *Constraint) satisfied(exprs *ExpressionCache, facts map[string]Fact) (bool, error) {
+	output, _, err := exprs.Evaluate(c.Expression, facts)
 	if err != nil {
 		return false, err
 	}
@@ -48,11 +45,27 @@ type ConstraintSet struct {
 
 // Identify returns constraints that are relevant (evaluable) given the current facts.
 func (cs *ConstraintSet) Identify(facts map[string]Fact) ([]Constraint, error) {
+	return cs.IdentifyContext(context.Background(), facts)
+}
+
+// IdentifyContext is Identify stopping between the constraints with the
+// error of ctx when ctx is done, returning the constraints identified so far.
+func (cs *ConstraintSet) IdentifyContext(ctx context.Context, facts map[string]Fact) ([]Constraint, error) {
+	return cs.identify(defaultExpressions, facts, ignoreErrors, contextSpender(ctx))
+}
+
+func (cs *ConstraintSet) identify(exprs *ExpressionCache, facts map[string]Fact, report reporter, spend spender) ([]Constraint, error) {
 	var active []Constraint
-	for _, c := range cs.Constraints {
-		_, err := c.Satisfied(facts)
+	for i, c := range cs.Constraints {
+		if err := spend(1); err != nil {
+			return active, err
+		}
+		_, err := c.satisfied(exprs, facts)
 		if err != nil {
-			// Constraint references facts not yet available — skip
+			// Constraint references facts not yet available or fails — skip
+			if err := report(fmt.Sprintf("constraint_set.constraints[%d].expression", i), c.Description, err); err != nil {
+				return nil, err
+			}
 			continue
 		}
 		active = append(active, c)
@@ -20,7 +20,7 @@ func (c *Contradiction) Detect(facts map[string]Fact) bool {
 
 func (c *Contradiction) Resolve(base *KnowledgeBase) {
 	for _, fact := range c.Facts {
-		delete(base.Facts, fact.ID)
+		base.removeFact(fact.ID)
 		base.RemoveDerivedFrom(fact.ID)
 	}
 }
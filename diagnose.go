@@ -0,0 +1,62 @@
+package inference
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// reporter records the error of evaluating the expression at path for the
+// named rule. It returns an error when the evaluation must stop, in strict
+// mode.
+type reporter func(path, rule string, err error) error
+
+// ignoreErrors is the reporter of the analyzers called on their own, which
+// skip the rules that cannot be evaluated.
+func ignoreErrors(path, rule string, err error) error {
+	return nil
+}
+
+// diagnose returns the diagnostic of an evaluation error: pending for a
+// fact not known yet, an error for an EvaluationError. Other errors, like a
+// rule that does not hold, are not diagnosed.
+func diagnose(path, rule string, err error) (Diagnostic, bool) {
+	d := Diagnostic{Path: path, Rule: rule, Message: err.Error()}
+	var evalErr *EvaluationError
+	switch {
+	case errors.As(err, &evalErr):
+		d.Severity = SeverityError
+	case errors.Is(err, ErrUnknownFact):
+		d.Severity = SeverityPending
+	default:
+		return Diagnostic{}, false
+	}
+	return d, true
+}
+
+// Diagnostics returns the diagnostics of the last call adding facts or
+// running the inferences or the RiskAnalyzer: the expressions that failed
+// and the ones waiting for facts not known yet.
+func (kb *KnowledgeBase) Diagnostics() Diagnostics {
+	return slices.Clone(kb.diagnostics)
+}
+
+// report is the reporter of the knowledge base. In Strict mode it stops
+// the call on errors.
+func (kb *KnowledgeBase) report(path, rule string, err error) error {
+	d, ok := diagnose(path, rule, err)
+	if !ok {
+		return nil
+	}
+	if !slices.Contains(kb.diagnostics, d) {
+		kb.diagnostics = append(kb.diagnostics, d)
+	}
+	if d.Severity == SeverityError && kb.strict() {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (kb *KnowledgeBase) strict() bool {
+	return kb.Strict || (kb.guard != nil && kb.guard.strict)
+}
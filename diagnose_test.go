@@ -0,0 +1,84 @@
+package inference
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestKnowledgeBase_Diagnostics(t *testing.T) {
+	kb := &KnowledgeBase{
+		Inferences: []Inference{
+			{ID: "fever", Rules: rule("temperature > 38"), FactID: "fever", FactValue: true},
+			{ID: "broken", Rules: rule(`name > 3`), FactID: "broken", FactValue: true},
+			{ID: "waiting", Rules: rule("pulse > 100"), FactID: "tachycardia", FactValue: true},
+			{ID: "cold", Rules: rule("temperature < 35"), FactID: "hypothermia", FactValue: true},
+		},
+	}
+	kb.Start()
+	kb.Facts["name"] = Fact{ID: "name", Value: "ann"}
+	kb.Facts["temperature"] = Fact{ID: "temperature", Value: 39}
+	if err := kb.Infer(); err != nil {
+		t.Fatal(err)
+	}
+	diags := kb.Diagnostics()
+	find := func(path string) (Diagnostic, bool) {
+		i := slices.IndexFunc(diags, func(d Diagnostic) bool { return d.Path == path })
+		if i < 0 {
+			return Diagnostic{}, false
+		}
+		return diags[i], true
+	}
+	if d, ok := find("inferences[1]"); !ok || d.Severity != SeverityError || d.Rule != "broken" {
+		t.Errorf("Expected the type mismatch reported as an error, got %v", diags)
+	}
+	if d, ok := find("inferences[2]"); !ok || d.Severity != SeverityPending {
+		t.Errorf("Expected the unknown pulse reported as pending, got %v", diags)
+	}
+	if _, ok := find("inferences[3]"); ok {
+		t.Errorf("Expected a rule that does not hold not to be reported, got %v", diags)
+	}
+	if _, ok := kb.Facts["fever"]; !ok {
+		t.Errorf("Expected the other inferences to run")
+	}
+
+	kb.Strict = true
+	err := kb.UpdateFact(Fact{ID: "name", Value: "bob"})
+	var evalErr *EvaluationError
+	if !errors.As(err, &evalErr) || evalErr.Expression != "name > 3" {
+		t.Errorf("Expected strict mode to fail on the error, got %v", err)
+	}
+}
+
+func TestPipeline_Diagnostics(t *testing.T) {
+	config := PipelineConfig{
+		KnowledgeBase:    &KnowledgeBase{},
+		IntentClassifier: &IntentClassifier{Rules: []IntentRule{{Expression: "query.missing.field == 1", IntentType: IntentQuery, Weight: 1}}},
+		RiskAnalyzer:     &RiskAnalyzer{Risks: []Risk{{Description: "late", Expression: "days_late > 3", Level: RiskHigh}}},
+	}
+	config.KnowledgeBase.Start()
+	input := map[string]Fact{"query": {ID: "query", Value: map[string]interface{}{}}}
+	result, err := NewPipeline(config).Run(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Diagnostics{
+		{Severity: SeverityError, Path: "intent_classifier.rules[0].expression", Stage: StageIntent, Rule: "query"},
+		{Severity: SeverityPending, Path: "risk_analyzer.risks[0].expression", Stage: StageRisks, Rule: "late"},
+	}
+	if len(result.Diagnostics) != len(want) {
+		t.Fatalf("Expected %d diagnostics, got %v", len(want), result.Diagnostics)
+	}
+	for i, d := range result.Diagnostics {
+		d.Message = ""
+		if d != want[i] {
+			t.Errorf("Expected %v, got %v", want[i], d)
+		}
+	}
+
+	config.Strict = true
+	config.KnowledgeBase.Start()
+	if _, err := NewPipeline(config).Run(input); err == nil {
+		t.Errorf("Expected a strict run to fail on the intent rule")
+	}
+}
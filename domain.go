@@ -1,6 +1,9 @@
 package inference
 
-import "strings"
+import (
+	"context"
+	"strings"
+)
 
 // Domain represents a business domain category.
 type Domain string
@@ -21,12 +24,27 @@ type DomainDetector struct {
 
 // Detect returns the domain with the most keyword matches in fact IDs and string values.
 func (d *DomainDetector) Detect(facts map[string]Fact) Domain {
+	domain, _ := d.detect(facts, contextSpender(context.Background()))
+	return domain
+}
+
+// DetectContext is Detect stopping between the keywords with the error of
+// ctx when ctx is done.
+func (d *DomainDetector) DetectContext(ctx context.Context, facts map[string]Fact) (Domain, error) {
+	return d.detect(facts, contextSpender(ctx))
+}
+
+// detect matches every keyword, which counts as an evaluation.
+func (d *DomainDetector) detect(facts map[string]Fact, spend spender) (Domain, error) {
 	if len(d.Signals) == 0 {
-		return DomainGeneral
+		return DomainGeneral, nil
 	}
 	scores := make(map[Domain]int)
-	for domain, keywords := range d.Signals {
-		for _, keyword := range keywords {
+	for _, domain := range sortedKeys(d.Signals) {
+		for _, keyword := range d.Signals[domain] {
+			if err := spend(1); err != nil {
+				return DomainGeneral, err
+			}
 			kw := strings.ToLower(keyword)
 			for id, fact := range facts {
 				if strings.Contains(strings.ToLower(id), kw) {
@@ -42,13 +60,14 @@ func (d *DomainDetector) Detect(facts map[string]Fact) Domain {
 	}
 	best := DomainGeneral
 	bestScore := 0
-	for domain, score := range scores {
-		if score > bestScore {
+	// domains are visited in order, so ties go to the first one
+	for _, domain := range sortedKeys(scores) {
+		if score := scores[domain]; score > bestScore {
 			best = domain
 			bestScore = score
 		}
 	}
-	return best
+	return best, nil
 }
 
 // DefaultDomainWeights returns domain-specific scoring weight presets.
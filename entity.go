@@ -1,7 +1,8 @@
 package inference
 
 import (
-	"github.com/expr-lang/expr"
+	"context"
+	"fmt"
 )
 
 // Entity represents an extracted entity from input facts.
@@ -26,24 +27,32 @@ type EntityExtractor struct {
 }
 
 // Extract evaluates extraction rules against facts and returns discovered entities.
+// Rules that cannot be evaluated are skipped.
 func (ee *EntityExtractor) Extract(facts map[string]Fact) ([]Entity, error) {
+	return ee.ExtractContext(context.Background(), facts)
+}
+
+// ExtractContext is Extract stopping between the rules with the error of
+// ctx when ctx is done, returning the entities extracted so far.
+func (ee *EntityExtractor) ExtractContext(ctx context.Context, facts map[string]Fact) ([]Entity, error) {
+	return ee.extract(defaultExpressions, facts, ignoreErrors, contextSpender(ctx))
+}
+
+func (ee *EntityExtractor) extract(exprs *ExpressionCache, facts map[string]Fact, report reporter, spend spender) ([]Entity, error) {
 	if len(ee.Rules) == 0 {
 		return nil, nil
 	}
 
-	env := make(map[string]interface{})
-	for k, v := range facts {
-		env[k] = v.Value
-	}
-
 	var entities []Entity
-	for _, rule := range ee.Rules {
-		program, err := expr.Compile(rule.Expression, expr.Env(env))
-		if err != nil {
-			continue
+	for i, rule := range ee.Rules {
+		if err := spend(1); err != nil {
+			return entities, err
 		}
-		output, err := expr.Run(program, env)
+		output, _, err := exprs.Evaluate(rule.Expression, facts)
 		if err != nil {
+			if err := report(fmt.Sprintf("entity_extractor.rules[%d].expression", i), rule.FactID, err); err != nil {
+				return nil, err
+			}
 			continue
 		}
 		// Skip nil or false results
@@ -0,0 +1,141 @@
+package inference
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// ErrFactNotFound is returned when updating or retracting a fact that is not
+// in the knowledge base.
+var ErrFactNotFound = errors.New("fact not found")
+
+// ChangeSet lists the facts added, changed and removed by a single call on
+// the knowledge base, sorted by ID. Removed facts carry their last value.
+type ChangeSet struct {
+	Added   []Fact `json:"added,omitempty"`
+	Changed []Fact `json:"changed,omitempty"`
+	Removed []Fact `json:"removed,omitempty"`
+}
+
+// Empty reports whether the change set has no changes.
+func (cs ChangeSet) Empty() bool {
+	return len(cs.Added) == 0 && len(cs.Changed) == 0 && len(cs.Removed) == 0
+}
+
+// factChange holds the state of a fact before the current call changed it.
+type factChange struct {
+	before  Fact
+	existed bool
+}
+
+type subscription struct {
+	id       int
+	listener func(ChangeSet)
+}
+
+// Subscribe registers a listener that receives the changes made by every
+// AddFact, UpdateFact, RetractFact, Infer and ResolveContradictions call,
+// including the derived facts. Calls that change nothing are not reported.
+// The returned function removes the listener.
+func (kb *KnowledgeBase) Subscribe(listener func(ChangeSet)) func() {
+	kb.lastSubscription++
+	id := kb.lastSubscription
+	kb.subscriptions = append(kb.subscriptions, subscription{id: id, listener: listener})
+	return func() {
+		kb.subscriptions = slices.DeleteFunc(kb.subscriptions, func(s subscription) bool {
+			return s.id == id
+		})
+	}
+}
+
+// UpdateFact replaces the value of a fact, retracting what was derived from
+// the previous value and running the inferences again.
+func (kb *KnowledgeBase) UpdateFact(fact Fact) error {
+	if _, ok := kb.Facts[fact.ID]; !ok {
+		return fmt.Errorf("%w: %s", ErrFactNotFound, fact.ID)
+	}
+	return kb.AddFact(fact)
+}
+
+// RetractFact removes a fact together with what was derived from it and runs
+// the inferences again. A derived fact is derived again if an inference
+// still supports it.
+func (kb *KnowledgeBase) RetractFact(id string) error {
+	if _, ok := kb.Facts[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrFactNotFound, id)
+	}
+	kb.beginChanges()
+	defer kb.publishChanges()
+	kb.removeFact(id)
+	delete(kb.reports, id)
+	delete(kb.scheduled, id)
+	kb.RemoveDerivedFrom(id)
+	err := kb.Infer()
+	kb.ResolveContradictions()
+	return err
+}
+
+// removeFact deletes a fact and lets the match network know about it.
+func (kb *KnowledgeBase) removeFact(id string) {
+	delete(kb.Facts, id)
+	kb.touch(id, -1)
+}
+
+// recordChange remembers the state a fact had before the current call
+// touched it for the first time.
+func (kb *KnowledgeBase) recordChange(id string) {
+	if kb.changes == nil {
+		kb.changes = make(map[string]factChange)
+	}
+	if _, ok := kb.changes[id]; ok {
+		return
+	}
+	before, existed := kb.seen[id]
+	kb.changes[id] = factChange{before: before, existed: existed}
+}
+
+// beginChanges starts collecting changes; calls nest so only the outermost
+// one publishes them.
+func (kb *KnowledgeBase) beginChanges() {
+	kb.changeDepth++
+}
+
+// publishChanges ends the current call and sends the changes to the
+// subscribers when it was the outermost one.
+func (kb *KnowledgeBase) publishChanges() {
+	kb.changeDepth--
+	if kb.changeDepth > 0 {
+		return
+	}
+	if kb.observed() {
+		kb.assertConclusions()
+	}
+	changes := kb.changes
+	kb.changes = nil
+	var cs ChangeSet
+	for id, change := range changes {
+		after, exists := kb.Facts[id]
+		switch {
+		case !change.existed && exists:
+			cs.Added = append(cs.Added, after)
+		case change.existed && !exists:
+			cs.Removed = append(cs.Removed, change.before)
+		case exists && !reflect.DeepEqual(change.before.Value, after.Value):
+			cs.Changed = append(cs.Changed, after)
+		}
+	}
+	if cs.Empty() {
+		return
+	}
+	for _, facts := range [][]Fact{cs.Added, cs.Changed, cs.Removed} {
+		slices.SortFunc(facts, func(a, b Fact) int {
+			return strings.Compare(a.ID, b.ID)
+		})
+	}
+	for _, s := range slices.Clone(kb.subscriptions) {
+		s.listener(cs)
+	}
+}
@@ -0,0 +1,91 @@
+package inference
+
+import (
+	"errors"
+	"testing"
+)
+
+func feverKnowledgeBase() *KnowledgeBase {
+	kb := &KnowledgeBase{
+		Inferences: []Inference{
+			{
+				Rules:     []WeightedRule{{Rule: Rule{Expression: "temperature > 38"}, Weight: 1}},
+				FactID:    "fever",
+				FactValue: true,
+			},
+		},
+	}
+	kb.Start()
+	return kb
+}
+
+func factIDs(facts []Fact) []string {
+	var ids []string
+	for _, f := range facts {
+		ids = append(ids, f.ID)
+	}
+	return ids
+}
+
+func TestKnowledgeBase_Subscribe(t *testing.T) {
+	kb := feverKnowledgeBase()
+	var changes []ChangeSet
+	unsubscribe := kb.Subscribe(func(cs ChangeSet) {
+		changes = append(changes, cs)
+	})
+
+	kb.AddFact(Fact{ID: "temperature", Value: 39})
+	if len(changes) != 1 {
+		t.Fatalf("Expected 1 change set, got %d", len(changes))
+	}
+	if ids := factIDs(changes[0].Added); len(ids) != 2 || ids[0] != "fever" || ids[1] != "temperature" {
+		t.Errorf("Expected fever and temperature to be added, got %v", ids)
+	}
+
+	if err := kb.UpdateFact(Fact{ID: "temperature", Value: 37}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ids := factIDs(changes[1].Changed); len(ids) != 1 || ids[0] != "temperature" {
+		t.Errorf("Expected temperature to be changed, got %v", ids)
+	}
+	if ids := factIDs(changes[1].Removed); len(ids) != 1 || ids[0] != "fever" {
+		t.Errorf("Expected fever to be removed, got %v", ids)
+	}
+
+	kb.AddFact(Fact{ID: "temperature", Value: 37})
+	if len(changes) != 2 {
+		t.Errorf("Expected no change set when nothing changes, got %v", changes[2:])
+	}
+
+	unsubscribe()
+	kb.AddFact(Fact{ID: "temperature", Value: 40})
+	if len(changes) != 2 {
+		t.Errorf("Expected no change set after unsubscribing")
+	}
+}
+
+func TestKnowledgeBase_RetractFact(t *testing.T) {
+	kb := feverKnowledgeBase()
+	kb.AddFact(Fact{ID: "temperature", Value: 39})
+
+	var removed []string
+	kb.Subscribe(func(cs ChangeSet) {
+		removed = append(removed, factIDs(cs.Removed)...)
+	})
+	if err := kb.RetractFact("temperature"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(kb.Facts) != 0 {
+		t.Errorf("Expected no facts left, got %v", kb.Facts)
+	}
+	if len(removed) != 2 {
+		t.Errorf("Expected fever and temperature to be removed, got %v", removed)
+	}
+
+	if err := kb.RetractFact("temperature"); !errors.Is(err, ErrFactNotFound) {
+		t.Errorf("Expected ErrFactNotFound, got %v", err)
+	}
+	if err := kb.UpdateFact(Fact{ID: "pressure", Value: 80}); !errors.Is(err, ErrFactNotFound) {
+		t.Errorf("Expected ErrFactNotFound, got %v", err)
+	}
+}
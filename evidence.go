@@ -0,0 +1,137 @@
+package inference
+
+import "slices"
+
+// Evidence tells how the reports of several sources for a fact were
+// combined with Dempster's rule. Belief is the evidence that supports the
+// value of the fact and Plausibility the evidence that does not contradict
+// it; the true support lies in between. Conflict is the mass the sources
+// assigned to contradicting values, from 0 when they agree to 1 when they
+// contradict each other completely.
+type Evidence struct {
+	Belief       float64  `json:"belief"`
+	Plausibility float64  `json:"plausibility"`
+	Conflict     float64  `json:"conflict"`
+	Sources      []string `json:"sources"`
+}
+
+// massFunction assigns mass to sets of candidate values, the bits of the
+// key index the values of the frame.
+type massFunction map[uint64]float64
+
+// weigh combines the fact with the reports of the other sources for the
+// same ID when the source of the fact has a reliability in kb.Sources. Each
+// report supports its value with its reliability times its certainty and
+// leaves the rest undecided. The fact takes the value with the highest
+// belief, with the belief as certainty. Dempster's rule is undefined when
+// the sources contradict each other completely: the latest report is kept
+// then, with no belief. A fact from a source without reliability replaces the reports.
+func (kb *KnowledgeBase) weigh(fact Fact) Fact {
+	if _, ok := kb.Sources[fact.Source]; !ok {
+		delete(kb.reports, fact.ID)
+		return fact
+	}
+	if kb.reports == nil {
+		kb.reports = make(map[string]map[string]Fact)
+	}
+	if kb.reports[fact.ID] == nil {
+		kb.reports[fact.ID] = make(map[string]Fact)
+	}
+	reports := kb.reports[fact.ID]
+	reports[fact.Source] = fact
+	sources := sortedKeys(reports)
+	// the frame has the reported values, at most 63 of them, and a last
+	// bit for any other value
+	var frame []interface{}
+	for _, source := range sources {
+		value := reports[source].Value
+		if !slices.ContainsFunc(frame, func(v interface{}) bool { return sameFactValue(v, value) }) && len(frame) < 63 {
+			frame = append(frame, value)
+		}
+	}
+	all := uint64(1)<<(len(frame)+1) - 1
+
+	combined := massFunction{all: 1}
+	agreement := 1.0
+	for _, source := range sources {
+		report := reports[source]
+		i := slices.IndexFunc(frame, func(v interface{}) bool { return sameFactValue(v, report.Value) })
+		if i < 0 {
+			continue
+		}
+		support := kb.Sources[source] * max(0, report.certainty())
+		var conflict float64
+		combined, conflict = combined.combine(massFunction{1 << i: support, all: 1 - support})
+		agreement *= 1 - conflict
+	}
+
+	evidence := &Evidence{Conflict: 1 - agreement, Sources: sources}
+	if agreement == 0 {
+		evidence.Plausibility = 1
+		fact.Certainty = CF(0)
+		fact.Evidence = evidence
+		return fact
+	}
+	best := 0
+	for i := range frame {
+		if combined.belief(1<<i) > combined.belief(1<<best) {
+			best = i
+		}
+	}
+	evidence.Belief = combined.belief(1 << best)
+	evidence.Plausibility = combined.plausibility(1 << best)
+	fact.Value = frame[best]
+	fact.Certainty = recorded(evidence.Belief)
+	fact.Evidence = evidence
+	return fact
+}
+
+// combine applies Dempster's rule of combination and returns the combined
+// masses with the conflict between them. The masses are left as they are
+// when the conflict is total.
+func (m massFunction) combine(other massFunction) (massFunction, float64) {
+	combined := make(massFunction)
+	conflict := 0.0
+	for _, a := range sortedKeys(m) {
+		ma := m[a]
+		for _, b := range sortedKeys(other) {
+			mb := other[b]
+			if a&b == 0 {
+				conflict += ma * mb
+				continue
+			}
+			combined[a&b] += ma * mb
+		}
+	}
+	if conflict >= 1 {
+		return m, 1
+	}
+	for set := range combined {
+		combined[set] /= 1 - conflict
+	}
+	return combined, conflict
+}
+
+// belief sums the mass of the sets included in the set.
+func (m massFunction) belief(set uint64) float64 {
+	belief := 0.0
+	for _, focal := range sortedKeys(m) {
+		mass := m[focal]
+		if focal != 0 && focal&^set == 0 {
+			belief += mass
+		}
+	}
+	return belief
+}
+
+// plausibility sums the mass of the sets that intersect the set.
+func (m massFunction) plausibility(set uint64) float64 {
+	plausibility := 0.0
+	for _, focal := range sortedKeys(m) {
+		mass := m[focal]
+		if focal&set != 0 {
+			plausibility += mass
+		}
+	}
+	return plausibility
+}
@@ -0,0 +1,75 @@
+package inference
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKnowledgeBase_WeighSources(t *testing.T) {
+	kb := &KnowledgeBase{Sources: map[string]float64{"monitor": 0.9, "nurse": 0.6, "patient": 0.5}}
+	kb.Start()
+
+	kb.AddFact(Fact{ID: "fever", Value: true, Source: "monitor"})
+	fever := kb.Facts["fever"]
+	if !almostEqual(fever.certainty(), 0.9) || !almostEqual(fever.Evidence.Plausibility, 1) {
+		t.Fatalf("Expected a single source to be discounted by its reliability, got %+v", fever)
+	}
+
+	kb.AddFact(Fact{ID: "fever", Value: true, Source: "patient"})
+	fever = kb.Facts["fever"]
+	if !almostEqual(fever.certainty(), 0.95) || fever.Evidence.Conflict != 0 {
+		t.Errorf("Expected agreeing sources to reinforce, got %+v", fever.Evidence)
+	}
+
+	// monitor: true 0.9; nurse: false 0.6; patient: true 0.5
+	kb.AddFact(Fact{ID: "fever", Value: false, Source: "nurse"})
+	fever = kb.Facts["fever"]
+	conflict := 0.95 * 0.6
+	if fever.Value != true || !almostEqual(fever.Evidence.Conflict, conflict) {
+		t.Fatalf("Expected the sources to keep fever with conflict %v, got %+v", conflict, fever.Evidence)
+	}
+	if !almostEqual(fever.Evidence.Belief, 0.95*0.4/(1-conflict)) || !almostEqual(fever.Evidence.Plausibility, 1-0.05*0.6/(1-conflict)) {
+		t.Errorf("Unexpected belief interval %+v", fever.Evidence)
+	}
+
+	risks, _ := (&RiskAnalyzer{}).Analyze(kb)
+	if len(risks) != 1 || risks[0].Level != RiskMedium || !strings.Contains(risks[0].Description, "fever") {
+		t.Errorf("Expected the conflict to be a medium risk, got %v", risks)
+	}
+
+	// a source without reliability replaces the reports
+	kb.AddFact(Fact{ID: "fever", Value: false, Source: "lab"})
+	if kb.Facts["fever"].Evidence != nil || kb.Facts["fever"].Value != false {
+		t.Errorf("Expected the lab to overwrite the fact, got %+v", kb.Facts["fever"])
+	}
+}
+
+func TestKnowledgeBase_TotalConflict(t *testing.T) {
+	kb := &KnowledgeBase{Sources: map[string]float64{"a": 1, "b": 1}}
+	kb.Start()
+	kb.AddFact(Fact{ID: "level", Value: "red", Source: "a"})
+	kb.AddFact(Fact{ID: "level", Value: "green", Source: "b"})
+	level := kb.Facts["level"]
+	if level.Value != "green" || level.Evidence.Conflict != 1 || level.certainty() != 0 {
+		t.Errorf("Expected the latest report to be kept on total conflict, got %+v", level)
+	}
+	risks, _ := (&RiskAnalyzer{}).Analyze(kb)
+	if len(risks) != 1 || risks[0].Level != RiskHigh {
+		t.Errorf("Expected a total conflict to be a high risk, got %v", risks)
+	}
+}
+
+func TestKnowledgeBase_UnreliableSource(t *testing.T) {
+	kb := &KnowledgeBase{
+		Sources:    map[string]float64{"rumor": 0},
+		Inferences: []Inference{{ID: "alarm", Rules: rule("outbreak"), FactID: "alarm", FactValue: true}},
+	}
+	kb.Start()
+	kb.AddFact(Fact{ID: "outbreak", Value: true, Source: "rumor"})
+	if c := kb.Facts["outbreak"].certainty(); c != 0 {
+		t.Errorf("Expected a source without reliability to give no belief, got %v", c)
+	}
+	if _, ok := kb.Facts["alarm"]; ok {
+		t.Errorf("Expected a report without belief not to support other facts")
+	}
+}
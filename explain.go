@@ -0,0 +1,350 @@
+package inference
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// ProofTree explains how a fact got its value: the inferences that derived
+// it, the rules they evaluated and, recursively, how the facts those rules
+// read were derived. A fact that was given has no derivations.
+type ProofTree struct {
+	FactID string      `json:"fact_id"`
+	Value  interface{} `json:"value,omitempty"`
+	Known  bool        `json:"known"`
+	// Source tells where a given fact came from, like input or extracted
+	Source string `json:"source,omitempty"`
+	// Certainty is set when the fact is not fully certain
+	Certainty   *float64     `json:"certainty,omitempty"`
+	Derivations []Derivation `json:"derivations,omitempty"`
+}
+
+// Derivation is an inference that supports a fact, or that could have
+// produced a missing one, with its rules evaluated against the current
+// facts.
+type Derivation struct {
+	Inference string      `json:"inference"`
+	Rules     []RuleTrace `json:"rules"`
+	// Premises explains the facts the inference read
+	Premises []*ProofTree `json:"premises,omitempty"`
+}
+
+// RuleTrace is the evaluation of a rule expression: whether it holds and the
+// values of the facts it read. Error explains a rule that could not be
+// evaluated, usually because a fact is missing.
+type RuleTrace struct {
+	Description string                 `json:"description,omitempty"`
+	Expression  string                 `json:"expression"`
+	Holds       bool                   `json:"holds"`
+	Values      map[string]interface{} `json:"values,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+}
+
+// Fact statuses of a ConclusionExplanation.
+const (
+	FactMatched    = "matched"
+	FactMismatched = "mismatched"
+	FactMissing    = "missing"
+)
+
+// ConclusionExplanation explains why a conclusion holds or not, fact by fact.
+type ConclusionExplanation struct {
+	Conclusion string            `json:"conclusion"`
+	Holds      bool              `json:"holds"`
+	Facts      []FactExplanation `json:"facts"`
+}
+
+// FactExplanation compares a fact expected by a conclusion with the one in
+// the knowledge base. Proof explains the actual value; for a missing fact it
+// lists the inferences that could have produced it and why they did not
+// fire, and Missing the base facts that would be needed.
+type FactExplanation struct {
+	FactID   string        `json:"fact_id"`
+	Expected interface{}   `json:"expected"`
+	Actual   interface{}   `json:"actual,omitempty"`
+	Status   string        `json:"status"`
+	Proof    *ProofTree    `json:"proof,omitempty"`
+	Missing  []MissingFact `json:"missing,omitempty"`
+}
+
+// Explain returns the proof tree of a fact. A missing fact is explained by
+// the inferences that could have produced it.
+func (kb *KnowledgeBase) Explain(id string) *ProofTree {
+	return kb.explain(id, make(map[string]bool))
+}
+
+// explain builds the proof tree of a fact, path holds the facts being
+// explained to stop at facts derived from themselves
+func (kb *KnowledgeBase) explain(id string, path map[string]bool) *ProofTree {
+	fact, ok := kb.Facts[id]
+	tree := &ProofTree{FactID: id, Value: fact.Value, Known: ok, Source: fact.Source}
+	if ok {
+		tree.Certainty = recorded(fact.certainty())
+	}
+	if path[id] {
+		return tree
+	}
+	path[id] = true
+	defer delete(path, id)
+
+	if !ok {
+		for _, inf := range kb.producers(id) {
+			tree.Derivations = append(tree.Derivations, kb.derivation(inf, nil, path))
+		}
+		return tree
+	}
+	for _, j := range fact.Justifications {
+		inf := kb.inferenceNamed(j.Inference, id)
+		if inf == nil {
+			tree.Derivations = append(tree.Derivations, Derivation{Inference: j.Inference, Premises: kb.explainAll(j.Premises, path)})
+			continue
+		}
+		tree.Derivations = append(tree.Derivations, kb.derivation(inf, j.Premises, path))
+	}
+	// facts derived before justifications were recorded
+	if len(fact.Justifications) == 0 && len(fact.DerivedFrom) > 0 {
+		tree.Derivations = append(tree.Derivations, Derivation{Premises: kb.explainAll(fact.DerivedFrom, path)})
+	}
+	return tree
+}
+
+func (kb *KnowledgeBase) explainAll(ids []string, path map[string]bool) []*ProofTree {
+	var trees []*ProofTree
+	for _, id := range ids {
+		trees = append(trees, kb.explain(id, path))
+	}
+	return trees
+}
+
+// derivation evaluates the rules of an inference and explains its premises,
+// the facts its expressions read when they are not given.
+func (kb *KnowledgeBase) derivation(inf *Inference, premises []string, path map[string]bool) Derivation {
+	d := Derivation{Inference: inf.name()}
+	var read []string
+	for _, rule := range inf.Rules {
+		trace := RuleTrace{Description: rule.Description, Expression: rule.Expression}
+		ids, _ := expressionFacts(rule.Expression)
+		for _, id := range ids {
+			if fact, ok := kb.Facts[id]; ok {
+				if trace.Values == nil {
+					trace.Values = make(map[string]interface{})
+				}
+				trace.Values[id] = fact.Value
+			}
+		}
+		holds, _, err := rule.evaluateIn(kb.expressions(), kb.Facts)
+		if err != nil {
+			trace.Error = err.Error()
+		}
+		trace.Holds = holds
+		d.Rules = append(d.Rules, trace)
+		read = append(read, ids...)
+	}
+	if premises == nil {
+		premises = unique(read)
+	}
+	d.Premises = kb.explainAll(premises, path)
+	return d
+}
+
+// producers returns the inferences that produce the fact with a static ID.
+func (kb *KnowledgeBase) producers(id string) []*Inference {
+	var producers []*Inference
+	for i := range kb.Inferences {
+		if !kb.Inferences[i].idCalculated() && kb.Inferences[i].FactID == id {
+			producers = append(producers, &kb.Inferences[i])
+		}
+	}
+	return producers
+}
+
+// inferenceNamed finds the inference of a justification by the name it
+// carries. Justifications recorded by other inferences, like those loaded
+// with the facts, are matched by inference name, preferring the one
+// producing the fact when several share it.
+func (kb *KnowledgeBase) inferenceNamed(name, id string) *Inference {
+	if i := slices.Index(justifiers(kb.Inferences), name); i >= 0 {
+		return &kb.Inferences[i]
+	}
+	var found *Inference
+	for i := range kb.Inferences {
+		inf := &kb.Inferences[i]
+		if inf.name() != name {
+			continue
+		}
+		if inf.idCalculated() || inf.FactID == id {
+			return inf
+		}
+		if found == nil {
+			found = inf
+		}
+	}
+	return found
+}
+
+// ExplainConclusion explains why a conclusion holds or, fact by fact, why
+// it does not: which expected fact has another value or is missing.
+func (kb *KnowledgeBase) ExplainConclusion(conclusion Conclusion) ConclusionExplanation {
+	e := ConclusionExplanation{Conclusion: conclusion.Description, Holds: conclusion.Assert(kb.Facts)}
+	for _, expected := range conclusion.Facts {
+		fe := FactExplanation{FactID: expected.ID, Expected: expected.Value}
+		fact, ok := kb.Facts[expected.ID]
+		if ok {
+			fe.Proof = kb.Explain(expected.ID)
+		} else {
+			fe.Proof = kb.explainMissing(expected.ID, expected.Value)
+		}
+		switch {
+		case !ok:
+			fe.Status = FactMissing
+			fe.Missing = kb.ProveValue(expected.ID, expected.Value).Missing
+		case !sameFactValue(fact.Value, expected.Value):
+			fe.Status = FactMismatched
+			fe.Actual = fact.Value
+		default:
+			fe.Status = FactMatched
+			fe.Actual = fact.Value
+		}
+		e.Facts = append(e.Facts, fe)
+	}
+	return e
+}
+
+// explainMissing explains a missing fact by the inferences that could have
+// produced it with the value.
+func (kb *KnowledgeBase) explainMissing(id string, value interface{}) *ProofTree {
+	tree := &ProofTree{FactID: id}
+	path := map[string]bool{id: true}
+	for _, inf := range kb.producers(id) {
+		if inf.IsValeCalculated || sameFactValue(inf.FactValue, value) {
+			tree.Derivations = append(tree.Derivations, kb.derivation(inf, nil, path))
+		}
+	}
+	return tree
+}
+
+// ExplainConclusions explains every conclusion of the knowledge base.
+func (kb *KnowledgeBase) ExplainConclusions() []ConclusionExplanation {
+	var explanations []ConclusionExplanation
+	for _, c := range kb.Conclusions {
+		explanations = append(explanations, kb.ExplainConclusion(c))
+	}
+	return explanations
+}
+
+// String renders the proof tree as indented text.
+func (t *ProofTree) String() string {
+	var b strings.Builder
+	t.write(&b, "")
+	return b.String()
+}
+
+func (t *ProofTree) write(b *strings.Builder, indent string) {
+	switch {
+	case !t.Known:
+		fmt.Fprintf(b, "%s%s is unknown\n", indent, t.FactID)
+	case len(t.Derivations) == 0:
+		fmt.Fprintf(b, "%s%s = %v (%s)\n", indent, t.FactID, t.Value, t.source())
+		return
+	case t.Certainty != nil:
+		fmt.Fprintf(b, "%s%s = %v (certainty %.2f)\n", indent, t.FactID, t.Value, *t.Certainty)
+	default:
+		fmt.Fprintf(b, "%s%s = %v\n", indent, t.FactID, t.Value)
+	}
+	t.writeDerivations(b, indent)
+}
+
+func (t *ProofTree) writeDerivations(b *strings.Builder, indent string) {
+	for _, d := range t.Derivations {
+		if d.Inference != "" {
+			verb := "derived by"
+			if !t.Known {
+				verb = "not derived by"
+			}
+			fmt.Fprintf(b, "%s  %s %q\n", indent, verb, d.Inference)
+		}
+		for _, rule := range d.Rules {
+			fmt.Fprintf(b, "%s    %s\n", indent, rule)
+		}
+		for _, premise := range d.Premises {
+			premise.write(b, indent+"      ")
+		}
+	}
+}
+
+func (t *ProofTree) source() string {
+	if t.Source == "" {
+		return "given"
+	}
+	return t.Source
+}
+
+// String renders the rule trace as text, like "temperature > 38 holds
+// (temperature = 39)".
+func (r RuleTrace) String() string {
+	var b strings.Builder
+	b.WriteString(r.Expression)
+	switch {
+	case r.Error != "":
+		fmt.Fprintf(&b, " failed: %s", r.Error)
+	case r.Holds:
+		b.WriteString(" holds")
+	default:
+		b.WriteString(" does not hold")
+	}
+	var values []string
+	for _, id := range sortedKeys(r.Values) {
+		values = append(values, fmt.Sprintf("%s = %v", id, r.Values[id]))
+	}
+	if len(values) > 0 {
+		fmt.Fprintf(&b, " (%s)", strings.Join(values, ", "))
+	}
+	return b.String()
+}
+
+// String renders the explanation as text: why the conclusion holds, or why
+// not.
+func (e ConclusionExplanation) String() string {
+	var b strings.Builder
+	if e.Holds {
+		fmt.Fprintf(&b, "%q holds\n", e.Conclusion)
+	} else {
+		fmt.Fprintf(&b, "%q does not hold\n", e.Conclusion)
+	}
+	for _, f := range e.Facts {
+		switch f.Status {
+		case FactMatched:
+			fmt.Fprintf(&b, "  %s = %v as expected", f.FactID, f.Actual)
+		case FactMismatched:
+			fmt.Fprintf(&b, "  %s = %v, expected %v", f.FactID, f.Actual, f.Expected)
+		case FactMissing:
+			fmt.Fprintf(&b, "  %s is missing, expected %v", f.FactID, f.Expected)
+		}
+		if f.Proof != nil && f.Proof.Known && len(f.Proof.Derivations) == 0 {
+			fmt.Fprintf(&b, " (%s)", f.Proof.source())
+		}
+		b.WriteString("\n")
+		for _, m := range f.Missing {
+			if m.Question != "" {
+				fmt.Fprintf(&b, "    needs %s: %s\n", m.FactID, m.Question)
+			} else {
+				fmt.Fprintf(&b, "    needs %s\n", m.FactID)
+			}
+		}
+		if f.Proof != nil {
+			f.Proof.writeDerivations(&b, "  ")
+		}
+	}
+	return b.String()
+}
+
+// sameFactValue compares fact values like Conclusion.Assert does, without
+// panicking on values that are not comparable.
+func sameFactValue(a, b interface{}) bool {
+	if a == nil || b == nil || !reflect.TypeOf(a).Comparable() || !reflect.TypeOf(b).Comparable() {
+		return reflect.DeepEqual(a, b)
+	}
+	return a == b
+}
@@ -0,0 +1,98 @@
+package inference
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestKnowledgeBase_Explain(t *testing.T) {
+	kb := triageKnowledgeBase()
+	kb.AddFact(Fact{ID: "temperature", Value: 39, Source: "input"})
+	kb.AddFact(Fact{ID: "systolic_bp", Value: 85, Source: "input"})
+
+	tree := kb.Explain("triage_level")
+	if !tree.Known || tree.Value != "red" || len(tree.Derivations) != 1 {
+		t.Fatalf("Expected red triage derived once, got %+v", tree)
+	}
+	d := tree.Derivations[0]
+	if d.Inference != "Red triage" || len(d.Rules) != 2 || !d.Rules[0].Holds || d.Rules[0].Values["fever"] != true {
+		t.Errorf("Unexpected derivation %+v", d)
+	}
+	if len(d.Premises) != 2 || d.Premises[0].FactID != "fever" {
+		t.Fatalf("Expected fever and hypotension as premises, got %+v", d.Premises)
+	}
+	fever := d.Premises[0]
+	if len(fever.Derivations) != 1 || fever.Derivations[0].Premises[0].Source != "input" {
+		t.Errorf("Expected fever to be derived from the input temperature, got %+v", fever)
+	}
+
+	text := tree.String()
+	for _, want := range []string{
+		"triage_level = red",
+		`derived by "Red triage"`,
+		"fever == true holds (fever = true)",
+		"temperature = 39 (input)",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in\n%s", want, text)
+		}
+	}
+}
+
+func TestKnowledgeBase_ExplainConclusion(t *testing.T) {
+	kb := triageKnowledgeBase()
+	kb.AddFact(Fact{ID: "temperature", Value: 39})
+	red := Conclusion{Description: "critical", Facts: []Fact{{ID: "triage_level", Value: "red"}}}
+	green := Conclusion{Description: "stable", Facts: []Fact{{ID: "fever", Value: false}}}
+
+	e := kb.ExplainConclusion(red)
+	if e.Holds || len(e.Facts) != 1 || e.Facts[0].Status != FactMissing {
+		t.Fatalf("Expected the triage level to be missing, got %+v", e)
+	}
+	missing := e.Facts[0]
+	if len(missing.Missing) != 1 || missing.Missing[0].FactID != "systolic_bp" {
+		t.Errorf("Expected systolic_bp to be needed, got %+v", missing.Missing)
+	}
+	// only the inference producing red is explained
+	if len(missing.Proof.Derivations) != 1 || missing.Proof.Derivations[0].Inference != "Red triage" {
+		t.Fatalf("Expected the red triage to explain why not, got %+v", missing.Proof.Derivations)
+	}
+	if rule := missing.Proof.Derivations[0].Rules[1]; rule.Holds || rule.Error == "" {
+		t.Errorf("Expected the hypotension rule to fail, got %+v", rule)
+	}
+	text := e.String()
+	for _, want := range []string{`"critical" does not hold`, "triage_level is missing, expected red", "needs systolic_bp", `not derived by "Red triage"`} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in\n%s", want, text)
+		}
+	}
+
+	e = kb.ExplainConclusion(green)
+	if e.Holds || e.Facts[0].Status != FactMismatched || e.Facts[0].Actual != true {
+		t.Errorf("Expected fever to mismatch, got %+v", e)
+	}
+}
+
+func TestPipeline_Explanations(t *testing.T) {
+	kb := triageKnowledgeBase()
+	kb.Conclusions = []Conclusion{{Description: "critical", Facts: []Fact{{ID: "triage_level", Value: "red"}}}}
+	pipeline := NewPipeline(PipelineConfig{KnowledgeBase: kb})
+
+	result, err := pipeline.Run(map[string]Fact{
+		"temperature": {ID: "temperature", Value: 39},
+		"systolic_bp": {ID: "systolic_bp", Value: 85},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(result.Explanations) != 1 || !result.Explanations[0].Holds {
+		t.Fatalf("Expected the conclusion to be explained, got %+v", result.Explanations)
+	}
+	if !strings.Contains(result.Reasoning.Explanation, `"critical" holds`) {
+		t.Errorf("Unexpected explanation text:\n%s", result.Reasoning.Explanation)
+	}
+	if _, err := json.Marshal(result); err != nil {
+		t.Errorf("Expected the result to marshal, got %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package inference
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/vm"
+)
+
+// ErrUnknownFact is returned when evaluating an expression that references
+// a fact that is not known.
+var ErrUnknownFact = errors.New("unknown name")
+
+// EvaluationError is an expression that does not compile or fails when run,
+// such as a syntax error, a type mismatch or a nil dereference, as opposed
+// to one waiting for facts not known yet.
+type EvaluationError struct {
+	Expression string
+	Err        error
+}
+
+func (e *EvaluationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *EvaluationError) Unwrap() error {
+	return e.Err
+}
+
+// Expression is a compiled expr-lang expression and the facts it references.
+type Expression struct {
+	program *vm.Program
+	facts   []string
+	// premises are the facts, with the ones tested by known and unknown,
+	// whose change makes the result change
+	premises []string
+	// closedWorld evaluates the facts that are not known as nil
+	closedWorld bool
+	// operators tells whether the expression calls the operators
+	operators bool
+	// now is the clock of the temporal operators
+	now func() time.Time
+}
+
+// Facts returns the IDs of the facts the expression references.
+func (e *Expression) Facts() []string {
+	return e.facts
+}
+
+// Run evaluates the expression. Like a failed compilation used to, it fails
+// when a referenced fact is not known, unless compiled for the closed world
+// where the fact is nil. An expression that fails on nil there, like a
+// comparison, is still waiting for the fact rather than wrong.
+func (e *Expression) Run(facts map[string]Fact) (interface{}, error) {
+	env := make(map[string]interface{}, len(e.facts))
+	if e.operators {
+		env = operators(facts, e.closedWorld)
+		maps.Copy(env, temporal(facts, e.now))
+	}
+	var missing []string
+	for _, id := range e.facts {
+		fact, ok := facts[id]
+		if !ok {
+			if !e.closedWorld {
+				return nil, fmt.Errorf("%w %s", ErrUnknownFact, id)
+			}
+			missing = append(missing, id)
+		}
+		env[id] = fact.Value
+	}
+	output, err := expr.Run(e.program, env)
+	if err != nil && len(missing) > 0 {
+		return nil, fmt.Errorf("%w %s: %v", ErrUnknownFact, strings.Join(missing, ", "), err)
+	}
+	return output, err
+}
+
+type compiled struct {
+	expression *Expression
+	err        error
+}
+
+// ExpressionCache compiles expressions once, type-checked against a schema,
+// and reuses the programs for every evaluation. It is safe for concurrent
+// use.
+type ExpressionCache struct {
+	options []expr.Option
+	// schema also declares the linguistic variables of fuzzy inferences
+	schema Schema
+	// closedWorld takes the facts that are not known as false, see
+	// KnowledgeBase.ClosedWorld
+	closedWorld bool
+	// now is the clock of the temporal operators, see KnowledgeBase.Clock
+	now func() time.Time
+	// observe, when set, receives an event for every evaluation
+	observe func(Event)
+
+	programs *programs
+}
+
+// programs holds the compiled programs, shared by the observed views of a
+// cache, the most recently used first.
+type programs struct {
+	mu       sync.Mutex
+	compiled map[string]*list.Element
+	order    *list.List
+	// limit bounds the programs kept: all of them when 0, none when
+	// negative
+	limit int
+}
+
+type program struct {
+	sExpression string
+	compiled
+}
+
+func newPrograms(limit int) *programs {
+	return &programs{compiled: make(map[string]*list.Element), order: list.New(), limit: limit}
+}
+
+func (p *programs) get(sExpression string) (compiled, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.compiled[sExpression]
+	if !ok {
+		return compiled{}, false
+	}
+	p.order.MoveToFront(e)
+	return e.Value.(*program).compiled, true
+}
+
+// put keeps the program, dropping the least recently used one past the
+// limit.
+func (p *programs) put(sExpression string, result compiled) {
+	if p.limit < 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.compiled[sExpression]; ok {
+		return
+	}
+	p.compiled[sExpression] = p.order.PushFront(&program{sExpression, result})
+	if p.limit > 0 && p.order.Len() > p.limit {
+		oldest := p.order.Remove(p.order.Back()).(*program)
+		delete(p.compiled, oldest.sExpression)
+	}
+}
+
+// NewExpressionCache returns a cache that type-checks against the schema,
+// which may be nil.
+func NewExpressionCache(schema Schema) *ExpressionCache {
+	return &ExpressionCache{
+		// now() reads the clock of the knowledge base instead
+		options:  []expr.Option{expr.Env(schema.env()), expr.AllowUndefinedVariables(), expr.DisableBuiltin("now")},
+		schema:   schema,
+		now:      time.Now,
+		programs: newPrograms(0),
+	}
+}
+
+// newWorldExpressions returns a cache for the closed or the open world.
+func newWorldExpressions(schema Schema, closedWorld bool) *ExpressionCache {
+	c := NewExpressionCache(schema)
+	c.closedWorld = closedWorld
+	return c
+}
+
+// defaultExpressionsLimit bounds the programs of defaultExpressions, which
+// compiles whatever expressions are given to Calculate.
+const defaultExpressionsLimit = 1024
+
+// defaultExpressions is used where no knowledge base schema applies.
+var defaultExpressions = newBoundedExpressions(defaultExpressionsLimit)
+
+// newBoundedExpressions returns a cache without schema that keeps the
+// limit most recently used programs.
+func newBoundedExpressions(limit int) *ExpressionCache {
+	c := NewExpressionCache(nil)
+	c.programs = newPrograms(limit)
+	return c
+}
+
+// Compile returns the compiled expression, compiling it on first use.
+// Compilation errors are cached as well.
+func (c *ExpressionCache) Compile(sExpression string) (*Expression, error) {
+	result, ok := c.programs.get(sExpression)
+	if ok {
+		return result.expression, result.err
+	}
+
+	program, err := expr.Compile(rewriteOperators(sExpression), c.options...)
+	if err == nil {
+		visitor := &NodeVisitor{}
+		node := program.Node()
+		ast.Walk(&node, visitor)
+		result.expression = &Expression{
+			program:     program,
+			facts:       unique(visitor.facts()),
+			premises:    unique(append(visitor.facts(), visitor.tested...)),
+			closedWorld: c.closedWorld,
+			operators: slices.ContainsFunc(visitor.callees, func(name string) bool {
+				return slices.Contains(operatorNames, name) || slices.Contains(temporalOperators, name)
+			}),
+			now: c.now,
+		}
+	}
+	result.err = err
+	c.programs.put(sExpression, result)
+	return result.expression, result.err
+}
+
+// Evaluate compiles the expression if needed and runs it against the facts,
+// returning its output and the facts it references, those tested by known
+// and unknown included. Errors other than ErrUnknownFact are
+// EvaluationErrors.
+func (c *ExpressionCache) Evaluate(sExpression string, facts map[string]Fact) (output interface{}, ids []string, err error) {
+	if c.observe != nil {
+		defer func(start time.Time) {
+			c.observe(c.evaluated(sExpression, facts, output, err, time.Since(start)))
+		}(time.Now())
+	}
+	expression, err := c.Compile(sExpression)
+	if err != nil {
+		return "", nil, &EvaluationError{Expression: sExpression, Err: err}
+	}
+	output, err = expression.Run(facts)
+	if err != nil {
+		if !errors.Is(err, ErrUnknownFact) {
+			err = &EvaluationError{Expression: sExpression, Err: err}
+		}
+		return "", nil, err
+	}
+	return output, expression.premises, nil
+}
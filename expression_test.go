@@ -0,0 +1,81 @@
+package inference
+
+import "testing"
+
+func TestExpressionCache_CompileOnce(t *testing.T) {
+	exprs := NewExpressionCache(nil)
+	first, err := exprs.Compile("age >= 18")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	second, _ := exprs.Compile("age >= 18")
+	if first != second {
+		t.Errorf("Expected the compiled expression to be reused")
+	}
+
+	facts := map[string]Fact{"age": {ID: "age", Value: 20}}
+	output, derived, err := exprs.Evaluate("age >= 18", facts)
+	if err != nil || output != true {
+		t.Errorf("Expected true, got %v (%v)", output, err)
+	}
+	if len(derived) != 1 || derived[0] != "age" {
+		t.Errorf("Expected age to be referenced, got %v", derived)
+	}
+
+	if _, _, err := exprs.Evaluate("age >= 18", map[string]Fact{}); err == nil {
+		t.Errorf("Expected an error for an unknown fact")
+	}
+}
+
+func TestExpressionCache_Schema(t *testing.T) {
+	exprs := NewExpressionCache(Schema{
+		"temperature": {Type: FactNumber},
+		"level":       {Type: FactString},
+		"sale":        {Type: FactObject},
+	})
+
+	if _, err := exprs.Compile(`level > 3`); err == nil {
+		t.Errorf("Expected a type error comparing a string with a number")
+	}
+	// a number may hold an int
+	if output, _, err := exprs.Evaluate("temperature % 2 == 1", map[string]Fact{"temperature": {ID: "temperature", Value: 39}}); err != nil || output != true {
+		t.Errorf("Expected the remainder of a declared number, got %v (%v)", output, err)
+	}
+
+	facts := map[string]Fact{
+		"temperature": {ID: "temperature", Value: 39},
+		"sale":        {ID: "sale", Value: struct{ Product string }{"pizza"}},
+	}
+	output, _, err := exprs.Evaluate("temperature + 1 > 38 && sale.Product == 'pizza'", facts)
+	if err != nil || output != true {
+		t.Errorf("Expected true, got %v (%v)", output, err)
+	}
+}
+
+func TestExpressionCache_Let(t *testing.T) {
+	facts := map[string]Fact{"items": {ID: "items", Value: []interface{}{1, 2}}}
+	output, ids, err := NewExpressionCache(nil).Evaluate("let n = len(items); n > 1", facts)
+	if err != nil || output != true {
+		t.Errorf("Expected true, got %v (%v)", output, err)
+	}
+	if len(ids) != 1 || ids[0] != "items" {
+		t.Errorf("Expected only items to be referenced, got %v", ids)
+	}
+}
+
+func TestExpressionCache_Limit(t *testing.T) {
+	exprs := newBoundedExpressions(2)
+	first, _ := exprs.Compile("a > 1")
+	exprs.Compile("b > 1")
+	exprs.Compile("a > 1")
+	exprs.Compile("c > 1")
+	if n := exprs.programs.order.Len(); n != 2 {
+		t.Errorf("Expected 2 programs kept, got %d", n)
+	}
+	if again, _ := exprs.Compile("a > 1"); again != first {
+		t.Errorf("Expected the recently used program to be kept")
+	}
+	if _, ok := exprs.programs.get("b > 1"); ok {
+		t.Errorf("Expected the least recently used program to be dropped")
+	}
+}
@@ -1,5 +1,7 @@
 package inference
 
+import "time"
+
 type Fact struct {
 	ID           string      `json:"id"`
 	Description  string      `json:"description"`
@@ -7,6 +9,34 @@ type Fact struct {
 	DerivedFrom  []string    `json:"derived_from"`
 	Accumulative bool        `json:"accumulative"`
 	Source       string      `json:"source,omitempty"`
+	// Type optionally tags the fact, so pattern inferences can match it by
+	// type instead of by ID
+	Type string `json:"type,omitempty"`
+	// Justifications records every inference that currently supports a
+	// derived fact, DerivedFrom holds the union of their premises
+	Justifications []Justification `json:"justifications,omitempty"`
+	// Certainty is the certainty factor of the fact, between -1 and 1, where
+	// a negative one is evidence against it; a derived fact combines the
+	// certainty factors of its justifications. Facts without one are fully
+	// certain, see CF to set it.
+	Certainty *float64 `json:"certainty,omitempty"`
+	// Evidence is set when the value combines the reports of several
+	// sources, see KnowledgeBase.Sources
+	Evidence *Evidence `json:"evidence,omitempty"`
+	// ObservedAt is when the fact was observed, the clock of the knowledge
+	// base when it is added without one
+	ObservedAt time.Time `json:"observed_at,omitzero"`
+	// ValidFrom and ValidUntil bound when the fact holds: it is held back
+	// until ValidFrom and expires at ValidUntil, taking what was derived
+	// from it along
+	ValidFrom  time.Time `json:"valid_from,omitzero"`
+	ValidUntil time.Time `json:"valid_until,omitzero"`
+	// TTL expires the fact that long after it was observed, unless
+	// ValidUntil is set
+	TTL Duration `json:"ttl,omitempty"`
+	// History holds the earlier observations of the fact kept for
+	// KnowledgeBase.Retention, see the window operators
+	History []Observation `json:"history,omitempty"`
 }
 
 func (f *Fact) Equal(other *Fact) bool {
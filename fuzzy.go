@@ -0,0 +1,389 @@
+package inference
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// MembershipType is the shape of a membership function.
+type MembershipType string
+
+const (
+	// MembershipTriangular takes the params a, b, c: 0 outside [a, c],
+	// rising to 1 at b
+	MembershipTriangular MembershipType = "triangular"
+	// MembershipTrapezoid takes the params a, b, c, d: 0 outside [a, d],
+	// 1 between b and c
+	MembershipTrapezoid MembershipType = "trapezoid"
+	// MembershipGaussian takes the params mean, sigma
+	MembershipGaussian MembershipType = "gaussian"
+)
+
+// Membership is the membership function of a linguistic term, like fever
+// for the temperature: how much a value belongs to the term, between 0 and
+// 1.
+type Membership struct {
+	Type   MembershipType `json:"type"`
+	Params []float64      `json:"params"`
+}
+
+// membershipParams is the number of params of each membership function.
+var membershipParams = map[MembershipType]int{MembershipTriangular: 3, MembershipTrapezoid: 4, MembershipGaussian: 2}
+
+// Degree returns how much the value belongs to the term, 0 when the params
+// do not fit the membership function.
+func (m Membership) Degree(x float64) float64 {
+	p := m.Params
+	if len(p) != membershipParams[m.Type] {
+		return 0
+	}
+	switch m.Type {
+	case MembershipTriangular:
+		switch {
+		case x < p[0] || x > p[2]:
+			return 0
+		case x < p[1]:
+			return (x - p[0]) / (p[1] - p[0])
+		case x == p[1]:
+			return 1
+		default:
+			return (p[2] - x) / (p[2] - p[1])
+		}
+	case MembershipTrapezoid:
+		switch {
+		case x < p[0] || x > p[3]:
+			return 0
+		case x < p[1]:
+			return (x - p[0]) / (p[1] - p[0])
+		case x <= p[2]:
+			return 1
+		default:
+			return (p[3] - x) / (p[3] - p[2])
+		}
+	case MembershipGaussian:
+		return math.Exp(-(x - p[0]) * (x - p[0]) / (2 * p[1] * p[1]))
+	}
+	return 0
+}
+
+// check reports membership functions with the wrong number of params or
+// params out of order.
+func (m Membership) check() error {
+	n, ok := membershipParams[m.Type]
+	if !ok {
+		return fmt.Errorf("unknown membership function %q", m.Type)
+	}
+	if len(m.Params) != n {
+		return fmt.Errorf("%s membership takes %d params, got %d", m.Type, n, len(m.Params))
+	}
+	if m.Type == MembershipGaussian {
+		if m.Params[1] <= 0 {
+			return fmt.Errorf("gaussian sigma %v is not positive", m.Params[1])
+		}
+		return nil
+	}
+	for i := 1; i < n; i++ {
+		if m.Params[i] < m.Params[i-1] {
+			return fmt.Errorf("%s params %v are not in ascending order", m.Type, m.Params)
+		}
+	}
+	return nil
+}
+
+// FuzzyOperators selects how fuzzy conditions are combined.
+type FuzzyOperators string
+
+// FuzzyMethod selects how the fuzzy rules produce the value of the fact.
+type FuzzyMethod string
+
+// Defuzzification selects how a Mamdani output is turned into a number.
+type Defuzzification string
+
+const (
+	// FuzzyMinMax takes AND as min and OR as max
+	FuzzyMinMax FuzzyOperators = "min_max"
+	// FuzzyProduct takes AND as product and OR as the probabilistic sum
+	FuzzyProduct FuzzyOperators = "product"
+
+	// FuzzyMamdani clips the output terms of the rules by their strength,
+	// aggregates them and defuzzifies the result
+	FuzzyMamdani FuzzyMethod = "mamdani"
+	// FuzzySugeno averages the outputs of the rules, expressions, weighted
+	// by their strength
+	FuzzySugeno FuzzyMethod = "sugeno"
+
+	DefuzzifyCentroid  Defuzzification = "centroid"
+	DefuzzifyMeanOfMax Defuzzification = "mean_of_max"
+)
+
+// defuzzifySteps is the number of intervals the output range is sampled in.
+const defuzzifySteps = 200
+
+// FuzzyInference grades an inference with fuzzy rules over linguistic
+// variables, numeric facts whose Schema declaration has Terms. The range of
+// an output variable is given by its Min and Max.
+//
+// Rules with consequents produce a graded number for the fact, or with Term
+// the output term it belongs to the most, and the fact takes the strength of
+// the strongest rule as certainty. Rules without consequents only grade the
+// FactValue of the inference.
+type FuzzyInference struct {
+	Rules     []FuzzyRule    `json:"rules"`
+	Operators FuzzyOperators `json:"operators,omitempty"`
+	Method    FuzzyMethod    `json:"method,omitempty"`
+	// Defuzzification is centroid unless set
+	Defuzzification Defuzzification `json:"defuzzification,omitempty"`
+	// Output is the linguistic variable of the consequents, the FactID of
+	// the inference unless set
+	Output string `json:"output,omitempty"`
+	// Term makes the fact the output term the value belongs to the most,
+	// with its membership degree as certainty
+	Term bool `json:"term,omitempty"`
+}
+
+// FuzzyRule is a rule like "if temperature is fever and heart_rate is high
+// then urgency is high".
+type FuzzyRule struct {
+	Description string           `json:"description,omitempty"`
+	If          []FuzzyCondition `json:"if"`
+	// Or joins the conditions with OR instead of AND
+	Or bool `json:"or,omitempty"`
+	// Then is the output term with the Mamdani method and an expression
+	// evaluating to a number with the Sugeno method
+	Then string `json:"then,omitempty"`
+	// Weight scales the strength of the rule, zero means 1
+	Weight float64 `json:"weight,omitempty"`
+}
+
+// FuzzyCondition is a condition like "temperature is fever".
+type FuzzyCondition struct {
+	Variable string `json:"variable"`
+	Is       string `json:"is"`
+	Not      bool   `json:"not,omitempty"`
+}
+
+// graded is the value of a fuzzy inference, how strongly it holds and the
+// facts it read.
+type graded struct {
+	value    interface{}
+	degree   float64
+	premises []string
+}
+
+// ErrNoFuzzyRule is returned when no fuzzy rule of an inference fires.
+var ErrNoFuzzyRule = errors.New("no fuzzy rule fires")
+
+func (f *FuzzyInference) and(a, b float64) float64 {
+	if f.Operators == FuzzyProduct {
+		return a * b
+	}
+	return min(a, b)
+}
+
+func (f *FuzzyInference) or(a, b float64) float64 {
+	if f.Operators == FuzzyProduct {
+		return a + b - a*b
+	}
+	return max(a, b)
+}
+
+// consequents reports whether the rules produce a value of their own.
+func (f *FuzzyInference) consequents() bool {
+	for _, rule := range f.Rules {
+		if rule.Then != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// grade evaluates the fuzzy rules of the inference against the facts.
+func (inf *Inference) grade(exprs *ExpressionCache, facts map[string]Fact) (graded, error) {
+	f := inf.Fuzzy
+	output := f.Output
+	if output == "" {
+		output = inf.FactID
+	}
+	strengths := make([]float64, len(f.Rules))
+	var premises []string
+	strongest := 0.0
+	for i, rule := range f.Rules {
+		strength, ids, err := f.strength(exprs.schema, rule, facts)
+		if err != nil {
+			return graded{}, err
+		}
+		strengths[i] = strength
+		premises = append(premises, ids...)
+		strongest = max(strongest, strength)
+	}
+	if strongest == 0 {
+		return graded{}, fmt.Errorf("%w for %s", ErrNoFuzzyRule, inf.name())
+	}
+	if !f.consequents() {
+		return graded{value: inf.FactValue, degree: strongest, premises: unique(premises)}, nil
+	}
+
+	var value float64
+	if f.Method == FuzzySugeno {
+		total, weighted := 0.0, 0.0
+		for i, rule := range f.Rules {
+			if strengths[i] == 0 {
+				continue
+			}
+			out, ids, err := exprs.Evaluate(rule.Then, facts)
+			if err != nil {
+				return graded{}, err
+			}
+			z, ok := toFloat(out)
+			if !ok {
+				return graded{}, fmt.Errorf("fuzzy output %q evaluated to %T, not a number", rule.Then, out)
+			}
+			total += strengths[i]
+			weighted += strengths[i] * z
+			premises = append(premises, ids...)
+		}
+		value = weighted / total
+	} else {
+		var err error
+		if value, err = f.mamdani(exprs.schema, output, strengths); err != nil {
+			return graded{}, err
+		}
+	}
+	if !f.Term {
+		return graded{value: value, degree: strongest, premises: unique(premises)}, nil
+	}
+	spec := exprs.schema[output]
+	term, degree := "", 0.0
+	for _, name := range sortedKeys(spec.Terms) {
+		if d := spec.Terms[name].Degree(value); d > degree {
+			term, degree = name, d
+		}
+	}
+	if term == "" {
+		return graded{}, fmt.Errorf("%v belongs to no term of %s", value, output)
+	}
+	return graded{value: term, degree: degree, premises: unique(premises)}, nil
+}
+
+// strength returns how much the conditions of the rule hold and the facts
+// they read.
+func (f *FuzzyInference) strength(schema Schema, rule FuzzyRule, facts map[string]Fact) (float64, []string, error) {
+	strength := 1.0
+	if rule.Or {
+		strength = 0
+	}
+	var ids []string
+	for _, c := range rule.If {
+		degree, err := schema.degree(c.Variable, c.Is, facts)
+		if err != nil {
+			return 0, nil, err
+		}
+		if c.Not {
+			degree = 1 - degree
+		}
+		if rule.Or {
+			strength = f.or(strength, degree)
+		} else {
+			strength = f.and(strength, degree)
+		}
+		ids = append(ids, c.Variable)
+	}
+	if rule.Weight != 0 {
+		strength *= rule.Weight
+	}
+	return strength, ids, nil
+}
+
+// degree returns how much the fact belongs to the term of its declaration.
+func (s Schema) degree(id, term string, facts map[string]Fact) (float64, error) {
+	membership, ok := s[id].Terms[term]
+	if !ok {
+		return 0, fmt.Errorf("%s has no linguistic term %q", id, term)
+	}
+	if err := membership.check(); err != nil {
+		return 0, fmt.Errorf("term %s of %s: %w", term, id, err)
+	}
+	fact, ok := facts[id]
+	if !ok {
+		return 0, fmt.Errorf("%w %s", ErrUnknownFact, id)
+	}
+	x, ok := toFloat(fact.Value)
+	if !ok {
+		return 0, fmt.Errorf("%s is %T, not a number", id, fact.Value)
+	}
+	return membership.Degree(x), nil
+}
+
+// mamdani clips the output term of every rule by its strength, aggregates
+// them over the range of the output variable and defuzzifies the result.
+func (f *FuzzyInference) mamdani(schema Schema, output string, strengths []float64) (float64, error) {
+	spec, ok := schema[output]
+	if !ok || spec.Min == nil || spec.Max == nil {
+		return 0, fmt.Errorf("output variable %s has no declared range", output)
+	}
+	for _, rule := range f.Rules {
+		membership, ok := spec.Terms[rule.Then]
+		if !ok {
+			return 0, fmt.Errorf("%s has no linguistic term %q", output, rule.Then)
+		}
+		if err := membership.check(); err != nil {
+			return 0, fmt.Errorf("term %s of %s: %w", rule.Then, output, err)
+		}
+	}
+	lo, hi := *spec.Min, *spec.Max
+	xs := make([]float64, defuzzifySteps+1)
+	mus := make([]float64, defuzzifySteps+1)
+	for i := range xs {
+		x := lo + (hi-lo)*float64(i)/defuzzifySteps
+		mu := 0.0
+		for j, rule := range f.Rules {
+			membership := spec.Terms[rule.Then]
+			// implication as the AND operator: clipping or scaling
+			mu = f.or(mu, f.and(strengths[j], membership.Degree(x)))
+		}
+		xs[i], mus[i] = x, mu
+	}
+
+	if f.Defuzzification == DefuzzifyMeanOfMax {
+		top := 0.0
+		for _, mu := range mus {
+			top = max(top, mu)
+		}
+		sum, n := 0.0, 0
+		for i, mu := range mus {
+			if top-mu < 1e-9 {
+				sum += xs[i]
+				n++
+			}
+		}
+		return sum / float64(n), nil
+	}
+	area, moment := 0.0, 0.0
+	for i, mu := range mus {
+		area += mu
+		moment += mu * xs[i]
+	}
+	if area == 0 {
+		return 0, fmt.Errorf("%w for %s", ErrNoFuzzyRule, output)
+	}
+	return moment / area, nil
+}
+
+// inputs returns the facts the fuzzy rules read.
+func (f *FuzzyInference) inputs() ([]string, error) {
+	var inputs []string
+	for _, rule := range f.Rules {
+		for _, c := range rule.If {
+			inputs = append(inputs, c.Variable)
+		}
+		if f.Method == FuzzySugeno && rule.Then != "" {
+			ids, err := expressionFacts(rule.Then)
+			if err != nil {
+				return nil, err
+			}
+			inputs = append(inputs, ids...)
+		}
+	}
+	return inputs, nil
+}
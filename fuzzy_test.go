@@ -0,0 +1,161 @@
+package inference
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMembership_Degree(t *testing.T) {
+	tests := []struct {
+		membership Membership
+		x, want    float64
+	}{
+		{Membership{Type: MembershipTriangular, Params: []float64{0, 5, 10}}, 2.5, 0.5},
+		{Membership{Type: MembershipTriangular, Params: []float64{0, 5, 10}}, 5, 1},
+		{Membership{Type: MembershipTriangular, Params: []float64{0, 5, 10}}, 11, 0},
+		{Membership{Type: MembershipTrapezoid, Params: []float64{37, 38.5, 42, 42}}, 37.9, 0.6},
+		{Membership{Type: MembershipTrapezoid, Params: []float64{37, 38.5, 42, 42}}, 42, 1},
+		{Membership{Type: MembershipGaussian, Params: []float64{0, 1}}, 0, 1},
+		{Membership{Type: MembershipTrapezoid, Params: []float64{37, 38.5}}, 38, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.membership.Degree(tt.x); !almostEqual(got, tt.want) {
+			t.Errorf("%s %v at %v: expected %v, got %v", tt.membership.Type, tt.membership.Params, tt.x, tt.want, got)
+		}
+	}
+	if err := (Membership{Type: MembershipTriangular, Params: []float64{5, 0, 10}}).check(); err == nil {
+		t.Errorf("Expected params out of order to be reported")
+	}
+}
+
+func TestFuzzyInference_MalformedTerm(t *testing.T) {
+	schema := fuzzySchema()
+	schema["temperature"].Terms["fever"] = Membership{Type: MembershipTrapezoid, Params: []float64{37, 38.5}}
+	kb := &KnowledgeBase{
+		Schema: schema,
+		Inferences: []Inference{{
+			ID: "fever", FactID: "fever", FactValue: true,
+			Fuzzy: &FuzzyInference{Rules: []FuzzyRule{{If: []FuzzyCondition{{Variable: "temperature", Is: "fever"}}}}},
+		}},
+	}
+	kb.Start()
+	if err := kb.AddFact(Fact{ID: "temperature", Value: 39}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := kb.Facts["fever"]; ok {
+		t.Errorf("Expected a malformed term not to grade the fact")
+	}
+}
+
+func fuzzySchema() Schema {
+	return Schema{
+		"temperature": {Type: FactNumber, Terms: map[string]Membership{
+			"normal": {Type: MembershipTrapezoid, Params: []float64{30, 30, 37, 38}},
+			"fever":  {Type: MembershipTrapezoid, Params: []float64{37, 38.5, 45, 45}},
+		}},
+		"urgency": {Type: FactNumber, Min: float(0), Max: float(10), Terms: map[string]Membership{
+			"low":  {Type: MembershipTriangular, Params: []float64{0, 0, 5}},
+			"high": {Type: MembershipTriangular, Params: []float64{5, 10, 10}},
+		}},
+	}
+}
+
+func TestFuzzyInference_GradesFactValue(t *testing.T) {
+	kb := &KnowledgeBase{
+		Schema: fuzzySchema(),
+		Inferences: []Inference{{
+			ID: "fever", FactID: "fever", FactValue: true,
+			Fuzzy: &FuzzyInference{Rules: []FuzzyRule{{If: []FuzzyCondition{{Variable: "temperature", Is: "fever"}}}}},
+		}},
+		Conclusions: []Conclusion{{Description: "febrile", Facts: []Fact{{ID: "fever", Value: true}}}},
+	}
+	kb.Start()
+	kb.AddFact(Fact{ID: "temperature", Value: 37.9})
+
+	fever, ok := kb.Facts["fever"]
+	if !ok || !almostEqual(fever.certainty(), 0.6) {
+		t.Fatalf("Expected a fever with certainty 0.6 at 37.9, got %+v", fever)
+	}
+	if c := kb.CertaintyForConclusion(kb.Conclusions[0]); ComputeConfidence(c) != ConfidenceMedium {
+		t.Errorf("Expected a medium confidence conclusion, got %v", c)
+	}
+
+	kb.AddFact(Fact{ID: "temperature", Value: 36.5})
+	if _, ok := kb.Facts["fever"]; ok {
+		t.Errorf("Expected no fever when no fuzzy rule fires")
+	}
+}
+
+func TestFuzzyInference_Mamdani(t *testing.T) {
+	rules := []FuzzyRule{
+		{If: []FuzzyCondition{{Variable: "temperature", Is: "normal"}}, Then: "low"},
+		{If: []FuzzyCondition{{Variable: "temperature", Is: "fever"}}, Then: "high"},
+	}
+	facts := map[string]Fact{"temperature": {ID: "temperature", Value: 37.6}}
+	exprs := NewExpressionCache(fuzzySchema())
+
+	// normal and fever both hold 0.4, so the output is symmetric around 5
+	for _, defuzzification := range []Defuzzification{DefuzzifyCentroid, DefuzzifyMeanOfMax} {
+		inf := Inference{FactID: "urgency", Fuzzy: &FuzzyInference{Rules: rules, Defuzzification: defuzzification}}
+		result, err := inf.infer(exprs, facts)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !almostEqual(result.value.(float64), 5) || !almostEqual(result.certainty, 0.4) {
+			t.Errorf("%s: expected urgency 5 with certainty 0.4, got %v and %v", defuzzification, result.value, result.certainty)
+		}
+	}
+
+	facts["temperature"] = Fact{ID: "temperature", Value: 40}
+	inf := Inference{FactID: "urgency", Fuzzy: &FuzzyInference{Rules: rules, Operators: FuzzyProduct, Term: true}}
+	result, err := inf.infer(exprs, facts)
+	if err != nil || result.value != "high" {
+		t.Errorf("Expected a high urgency term, got %v, %v", result.value, err)
+	}
+}
+
+func TestFuzzyInference_Sugeno(t *testing.T) {
+	inf := Inference{FactID: "dose", Fuzzy: &FuzzyInference{Method: FuzzySugeno, Rules: []FuzzyRule{
+		{If: []FuzzyCondition{{Variable: "temperature", Is: "normal"}}, Then: "0"},
+		{If: []FuzzyCondition{{Variable: "temperature", Is: "fever"}}, Then: "weight * 10"},
+	}}}
+	facts := map[string]Fact{
+		"temperature": {ID: "temperature", Value: 37.6},
+		"weight":      {ID: "weight", Value: 70},
+	}
+	result, err := inf.infer(NewExpressionCache(fuzzySchema()), facts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !almostEqual(result.value.(float64), 350) {
+		t.Errorf("Expected the weighted average 350, got %v", result.value)
+	}
+
+	delete(facts, "temperature")
+	if _, err := inf.infer(NewExpressionCache(fuzzySchema()), facts); !errors.Is(err, ErrUnknownFact) {
+		t.Errorf("Expected the missing variable to be unknown, got %v", err)
+	}
+}
+
+func TestKnowledgeBase_ValidateFuzzy(t *testing.T) {
+	schema := fuzzySchema()
+	schema["weight"] = FactSpec{Type: FactNumber, Terms: map[string]Membership{"heavy": {Type: MembershipGaussian, Params: []float64{90, 0}}}}
+	kb := &KnowledgeBase{
+		Schema: schema,
+		Inferences: []Inference{{FactID: "urgency", Fuzzy: &FuzzyInference{Rules: []FuzzyRule{
+			{If: []FuzzyCondition{{Variable: "temperature", Is: "hot"}}, Then: "high"},
+			{If: []FuzzyCondition{{Variable: "pressure", Is: "low"}}, Then: "extreme"},
+		}}}},
+	}
+	diags := kb.Validate()
+	for _, path := range []string{
+		"schema.weight.terms.heavy",
+		"inferences[0].fuzzy.rules[0].if[0].is",
+		"inferences[0].fuzzy.rules[1].if[0].variable",
+		"inferences[0].fuzzy.rules[1].then",
+	} {
+		if d, ok := diagnostic(diags, path); !ok || d.Severity != SeverityError {
+			t.Errorf("Expected an error at %s, got %v", path, diags)
+		}
+	}
+}
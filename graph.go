@@ -0,0 +1,418 @@
+package inference
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrCycle is returned when inferences cannot be ordered because they depend
+// on each other.
+var ErrCycle = errors.New("inferences form a cycle")
+
+// GraphNodeKind tells facts and inferences apart in a DependencyGraph.
+type GraphNodeKind string
+
+const (
+	GraphFact      GraphNodeKind = "fact"
+	GraphInference GraphNodeKind = "inference"
+)
+
+// GraphNode is a fact or an inference of a DependencyGraph. Facts are
+// identified as fact:<id> and inferences as inference:<index in
+// KnowledgeBase.Inferences>.
+type GraphNode struct {
+	ID    string        `json:"id"`
+	Kind  GraphNodeKind `json:"kind"`
+	Label string        `json:"label"`
+	// Cycle marks the inferences that are part of a cycle, see Cycles
+	Cycle bool `json:"cycle,omitempty"`
+	// Unreachable marks the inferences that can never fire, see Unreachable
+	Unreachable bool `json:"unreachable,omitempty"`
+}
+
+// GraphEdge goes from a fact to the inferences that read it and from an
+// inference to the fact it produces.
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// DependencyGraph is the directed graph of the facts referenced by the rule
+// expressions and calculated values of the inferences to the facts they
+// produce. Inferences with a calculated ID produce facts that cannot be
+// known statically and have no outgoing edge. It marshals to JSON as its
+// nodes and edges; DOT renders it for Graphviz.
+type DependencyGraph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+
+	inferences []Inference
+	// inputs holds the facts read by each inference, nil when they cannot be
+	// known statically
+	inputs    [][]string
+	producers map[string][]int
+	consumers map[string][]int
+	// known holds the initial and declared facts
+	known map[string]bool
+	// asserted holds the facts checked by conclusions and contradictions
+	asserted map[string]bool
+}
+
+// DependencyGraph builds the dependency graph of the inferences. Inference
+// indexes refer to kb.Inferences as it is when the graph is built.
+func (kb *KnowledgeBase) DependencyGraph() *DependencyGraph {
+	g := &DependencyGraph{
+		inferences: kb.Inferences,
+		inputs:     make([][]string, len(kb.Inferences)),
+		producers:  make(map[string][]int),
+		consumers:  make(map[string][]int),
+		known:      make(map[string]bool),
+		asserted:   make(map[string]bool),
+	}
+	for id := range kb.Facts {
+		g.known[id] = true
+	}
+	for id := range kb.Schema {
+		g.known[id] = true
+	}
+	for _, c := range kb.Conclusions {
+		for _, fact := range c.Facts {
+			g.asserted[fact.ID] = true
+		}
+	}
+	for _, c := range kb.Contradictions {
+		for _, fact := range c.Facts {
+			g.asserted[fact.ID] = true
+		}
+	}
+	for i, inf := range kb.Inferences {
+		if !inf.idCalculated() {
+			g.producers[inf.FactID] = append(g.producers[inf.FactID], i)
+		}
+		if node, ok := inferenceInputs(&inf); ok {
+			g.inputs[i] = node.inputs
+			for _, id := range node.inputs {
+				g.consumers[id] = append(g.consumers[id], i)
+			}
+		}
+	}
+	g.build()
+	return g
+}
+
+func inferenceNode(i int) string {
+	return fmt.Sprintf("inference:%d", i)
+}
+
+func factNode(id string) string {
+	return "fact:" + id
+}
+
+// build fills in the exported nodes and edges.
+func (g *DependencyGraph) build() {
+	facts := make(map[string]bool)
+	for id := range g.known {
+		facts[id] = true
+	}
+	for id := range g.asserted {
+		facts[id] = true
+	}
+	for id := range g.producers {
+		facts[id] = true
+	}
+	for id := range g.consumers {
+		facts[id] = true
+	}
+	for _, id := range sortedKeys(facts) {
+		g.Nodes = append(g.Nodes, GraphNode{ID: factNode(id), Kind: GraphFact, Label: id})
+	}
+
+	cyclic := make(map[int]bool)
+	for _, cycle := range g.Cycles() {
+		for _, i := range cycle {
+			cyclic[i] = true
+		}
+	}
+	unreachable := make(map[int]bool)
+	for _, i := range g.Unreachable() {
+		unreachable[i] = true
+	}
+	for i := range g.inferences {
+		g.Nodes = append(g.Nodes, GraphNode{
+			ID:          inferenceNode(i),
+			Kind:        GraphInference,
+			Label:       g.inferences[i].name(),
+			Cycle:       cyclic[i],
+			Unreachable: unreachable[i],
+		})
+		for _, id := range g.inputs[i] {
+			g.Edges = append(g.Edges, GraphEdge{From: factNode(id), To: inferenceNode(i)})
+		}
+		if !g.inferences[i].idCalculated() {
+			g.Edges = append(g.Edges, GraphEdge{From: inferenceNode(i), To: factNode(g.inferences[i].FactID)})
+		}
+	}
+}
+
+// dependents returns the inferences that read the fact produced by inference
+// i, leaving out i itself: an inference is not re-triggered by its own
+// output.
+func (g *DependencyGraph) dependents(i int) []int {
+	if g.inferences[i].idCalculated() {
+		return nil
+	}
+	var dependents []int
+	for _, j := range g.consumers[g.inferences[i].FactID] {
+		if j != i && !slices.Contains(dependents, j) {
+			dependents = append(dependents, j)
+		}
+	}
+	return dependents
+}
+
+// Cycles returns the groups of inferences that feed each other and may keep
+// re-triggering each other, as sorted indexes. An inference that reads its
+// own output, like an accumulator, is not a cycle.
+func (g *DependencyGraph) Cycles() [][]int {
+	// Tarjan's strongly connected components
+	index := make([]int, len(g.inferences))
+	low := make([]int, len(g.inferences))
+	onStack := make([]bool, len(g.inferences))
+	var stack []int
+	var cycles [][]int
+	next := 1
+
+	var visit func(i int)
+	visit = func(i int) {
+		index[i], low[i] = next, next
+		next++
+		stack = append(stack, i)
+		onStack[i] = true
+		for _, j := range g.dependents(i) {
+			if index[j] == 0 {
+				visit(j)
+				low[i] = min(low[i], low[j])
+			} else if onStack[j] {
+				low[i] = min(low[i], index[j])
+			}
+		}
+		if low[i] != index[i] {
+			return
+		}
+		var component []int
+		for {
+			j := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[j] = false
+			component = append(component, j)
+			if j == i {
+				break
+			}
+		}
+		if len(component) > 1 {
+			slices.Sort(component)
+			cycles = append(cycles, component)
+		}
+	}
+	for i := range g.inferences {
+		if index[i] == 0 {
+			visit(i)
+		}
+	}
+	slices.SortFunc(cycles, func(a, b []int) int {
+		return a[0] - b[0]
+	})
+	return cycles
+}
+
+// Unreachable returns the inferences that can never fire because some fact
+// they read is only produced by inferences that cannot fire either. Facts
+// that no inference produces are taken as input facts and always available.
+func (g *DependencyGraph) Unreachable() []int {
+	available := func(id string) bool {
+		return g.known[id] || len(g.producers[id]) == 0
+	}
+	produced := make(map[string]bool)
+	fired := make([]bool, len(g.inferences))
+	for changed := true; changed; {
+		changed = false
+		for i, inf := range g.inferences {
+			if fired[i] {
+				continue
+			}
+			ready := true
+			for _, id := range g.inputs[i] {
+				if !available(id) && !produced[id] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				fired[i] = true
+				changed = true
+				if !inf.idCalculated() {
+					produced[inf.FactID] = true
+				}
+			}
+		}
+	}
+	var unreachable []int
+	for i := range fired {
+		if !fired[i] {
+			unreachable = append(unreachable, i)
+		}
+	}
+	return unreachable
+}
+
+// InputFacts returns the facts the inferences read that no inference
+// produces, which must be provided as input.
+func (g *DependencyGraph) InputFacts() []string {
+	var inputs []string
+	for _, id := range sortedKeys(g.consumers) {
+		if len(g.producers[id]) == 0 {
+			inputs = append(inputs, id)
+		}
+	}
+	return inputs
+}
+
+// UnusedFacts returns the facts that are produced, initial or declared but
+// that no inference reads and no conclusion or contradiction checks.
+func (g *DependencyGraph) UnusedFacts() []string {
+	candidates := make(map[string]bool)
+	for id := range g.known {
+		candidates[id] = true
+	}
+	for id := range g.producers {
+		candidates[id] = true
+	}
+	var unused []string
+	for _, id := range sortedKeys(candidates) {
+		if len(g.consumers[id]) == 0 && !g.asserted[id] {
+			unused = append(unused, id)
+		}
+	}
+	return unused
+}
+
+// TopologicalOrder returns the inference indexes ordered so every inference
+// comes after the ones producing the facts it reads. Inferences producing
+// the same fact keep their relative Order, so later overwrite inferences
+// still win; remaining ties are broken by Order and then by index. It
+// returns ErrCycle when the inferences depend on each other.
+func (g *DependencyGraph) TopologicalOrder() ([]int, error) {
+	n := len(g.inferences)
+	before := func(i, j int) bool {
+		if g.inferences[i].Order != g.inferences[j].Order {
+			return g.inferences[i].Order < g.inferences[j].Order
+		}
+		return i < j
+	}
+	successors := make([][]int, n)
+	incoming := make([]int, n)
+	link := func(i, j int) {
+		if i != j && !slices.Contains(successors[i], j) {
+			successors[i] = append(successors[i], j)
+			incoming[j]++
+		}
+	}
+	for i := range g.inferences {
+		for _, j := range g.dependents(i) {
+			link(i, j)
+		}
+	}
+	for _, producers := range g.producers {
+		for _, i := range producers {
+			for _, j := range producers {
+				if before(i, j) {
+					link(i, j)
+				}
+			}
+		}
+	}
+
+	var ready, order []int
+	for i := range g.inferences {
+		if incoming[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	for len(ready) > 0 {
+		next := 0
+		for k := range ready {
+			if before(ready[k], ready[next]) {
+				next = k
+			}
+		}
+		i := ready[next]
+		ready = slices.Delete(ready, next, next+1)
+		order = append(order, i)
+		for _, j := range successors[i] {
+			incoming[j]--
+			if incoming[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+	if len(order) < n {
+		var names []string
+		for i := range g.inferences {
+			if incoming[i] > 0 {
+				names = append(names, g.inferences[i].name())
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", ErrCycle, strings.Join(names, ", "))
+	}
+	return order, nil
+}
+
+// DOT renders the graph in the Graphviz DOT language. Facts are ellipses,
+// inferences are boxes; inferences in a cycle are red and unreachable ones
+// are dashed.
+func (g *DependencyGraph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph inferences {\n  rankdir=LR;\n")
+	for _, node := range g.Nodes {
+		attributes := []string{"label=" + quoteDOT(node.Label)}
+		if node.Kind == GraphFact {
+			attributes = append(attributes, "shape=ellipse")
+		} else {
+			attributes = append(attributes, "shape=box")
+		}
+		if node.Cycle {
+			attributes = append(attributes, "color=red")
+		}
+		if node.Unreachable {
+			attributes = append(attributes, "style=dashed")
+		}
+		fmt.Fprintf(&b, "  %s [%s];\n", quoteDOT(node.ID), strings.Join(attributes, ", "))
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&b, "  %s -> %s;\n", quoteDOT(edge.From), quoteDOT(edge.To))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func quoteDOT(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// OrderInferences replaces the Order of every inference by its position in
+// the TopologicalOrder and sorts the inferences accordingly.
+func (kb *KnowledgeBase) OrderInferences() error {
+	order, err := kb.DependencyGraph().TopologicalOrder()
+	if err != nil {
+		return err
+	}
+	inferences := make([]Inference, len(order))
+	for position, i := range order {
+		inferences[position] = kb.Inferences[i]
+		inferences[position].Order = position
+	}
+	kb.Inferences = inferences
+	return nil
+}
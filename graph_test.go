@@ -0,0 +1,118 @@
+package inference
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func graphKnowledgeBase() *KnowledgeBase {
+	return &KnowledgeBase{
+		Facts: map[string]Fact{},
+		Inferences: []Inference{
+			{ID: "triage", Rules: rule("fever && hypotension"), FactID: "level", FactValue: "red"},
+			{ID: "fever", Rules: rule("temperature > 38"), FactID: "fever", FactValue: true},
+			{ID: "hypotension", Rules: rule("systolic_bp < 90"), FactID: "hypotension", FactValue: true},
+			{ID: "unused", Rules: rule("temperature < 35"), FactID: "hypothermia", FactValue: true},
+		},
+		Conclusions: []Conclusion{{Description: "urgent", Facts: []Fact{{ID: "level", Value: "red"}}}},
+	}
+}
+
+func TestDependencyGraph_TopologicalOrder(t *testing.T) {
+	kb := graphKnowledgeBase()
+	graph := kb.DependencyGraph()
+
+	order, err := graph.TopologicalOrder()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(order, []int{1, 2, 0, 3}) {
+		t.Errorf("Expected triage after fever and hypotension, got %v", order)
+	}
+	if inputs := graph.InputFacts(); !slices.Equal(inputs, []string{"systolic_bp", "temperature"}) {
+		t.Errorf("Unexpected input facts %v", inputs)
+	}
+	if unused := graph.UnusedFacts(); !slices.Equal(unused, []string{"hypothermia"}) {
+		t.Errorf("Unexpected unused facts %v", unused)
+	}
+	if len(graph.Cycles()) != 0 || len(graph.Unreachable()) != 0 {
+		t.Errorf("Unexpected cycles %v or unreachable inferences %v", graph.Cycles(), graph.Unreachable())
+	}
+
+	if err := kb.OrderInferences(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if kb.Inferences[2].ID != "triage" || kb.Inferences[2].Order != 2 {
+		t.Errorf("Expected triage to be third, got %+v", kb.Inferences[2])
+	}
+	kb.Start()
+	kb.AddFact(Fact{ID: "temperature", Value: 39})
+	kb.AddFact(Fact{ID: "systolic_bp", Value: 80})
+	if kb.Facts["level"].Value != "red" {
+		t.Errorf("Expected red level after reordering, got %v", kb.Facts["level"].Value)
+	}
+}
+
+func TestDependencyGraph_OverwriteKeepsOrder(t *testing.T) {
+	kb := &KnowledgeBase{Inferences: []Inference{
+		{ID: "red", Rules: rule("fever"), FactID: "level", FactValue: "red", OverWrite: true, Order: 1},
+		{ID: "green", Rules: rule("true"), FactID: "level", FactValue: "green"},
+		{ID: "fever", Rules: rule("temperature > 38"), FactID: "fever", FactValue: true, Order: 2},
+	}}
+	order, err := kb.DependencyGraph().TopologicalOrder()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(order, []int{1, 2, 0}) {
+		t.Errorf("Expected green, fever, red, got %v", order)
+	}
+}
+
+func TestDependencyGraph_Cycles(t *testing.T) {
+	kb := &KnowledgeBase{Inferences: []Inference{
+		{ID: "a", Rules: rule("b"), FactID: "a", FactValue: true},
+		{ID: "b", Rules: rule("a"), FactID: "b", FactValue: true},
+		{ID: "counter", Rules: rule("true"), FactID: "count", FactValue: "count + 1", IsValeCalculated: true},
+		{ID: "c", Rules: rule("a"), FactID: "c", FactValue: true},
+	}}
+	graph := kb.DependencyGraph()
+
+	if cycles := graph.Cycles(); len(cycles) != 1 || !slices.Equal(cycles[0], []int{0, 1}) {
+		t.Errorf("Expected a and b to form the only cycle, got %v", cycles)
+	}
+	if unreachable := graph.Unreachable(); !slices.Equal(unreachable, []int{0, 1, 2, 3}) {
+		t.Errorf("Expected every inference to be unreachable, got %v", unreachable)
+	}
+	if _, err := graph.TopologicalOrder(); !errors.Is(err, ErrCycle) {
+		t.Errorf("Expected ErrCycle, got %v", err)
+	}
+	if d, ok := diagnostic(kb.Validate(), "inferences[0]"); !ok || d.Severity != SeverityWarning {
+		t.Errorf("Expected Validate to warn about the cycle, got %v", kb.Validate())
+	}
+}
+
+func TestDependencyGraph_Output(t *testing.T) {
+	graph := graphKnowledgeBase().DependencyGraph()
+
+	dot := graph.DOT()
+	for _, want := range []string{`"fact:fever" -> "inference:0"`, `"inference:1" -> "fact:fever"`, `"inference:0" [label="triage", shape=box]`} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("Expected %s in\n%s", want, dot)
+		}
+	}
+
+	data, err := json.Marshal(graph)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var decoded DependencyGraph
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(decoded.Nodes) != len(graph.Nodes) || len(decoded.Edges) != len(graph.Edges) {
+		t.Errorf("Expected the nodes and edges to round trip, got %s", data)
+	}
+}
@@ -1,13 +1,17 @@
 package inference
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
-	log "github.com/sirupsen/logrus"
 	"slices"
 	"strings"
 )
 
 type Inference struct {
+	// ID optionally identifies the inference in justifications, the
+	// Description is used when it is empty
+	ID               string         `json:"id,omitempty"`
 	Description      string         `json:"description"`
 	Rules            []WeightedRule `json:"rules"`
 	FactID           string         `json:"fact_id"`
@@ -21,51 +25,184 @@ type Inference struct {
 	Probability float64 `json:"probability"`
 	// The order of the inference, this is used to sort the inferences
 	Order int `json:"order"`
+	// Threshold, when set, lets the inference fire as soon as the weighted
+	// certainty of its rules reaches it instead of requiring all of them
+	Threshold float64 `json:"threshold,omitempty"`
+	// CF is the certainty factor of the inference itself, between -1 and 1,
+	// the fact is concluded with it scaled by the certainty of the premises.
+	// A negative CF concludes evidence against the fact. Zero means 1.
+	CF float64 `json:"cf,omitempty"`
+	// Salience ranks the inference on the agenda with the salience conflict
+	// resolution, the highest fires first
+	Salience int `json:"salience,omitempty"`
+	// Fuzzy, when set, grades the fact with fuzzy rules once the rules of
+	// the inference hold
+	Fuzzy *FuzzyInference `json:"fuzzy,omitempty"`
+	// ForEach makes the inference fire once for every combination of the
+	// facts its patterns match, with the matched facts bound to the
+	// variables of the patterns, see Pattern. Every combination counts as
+	// an evaluation of the Budget
+	ForEach []Pattern `json:"for_each,omitempty"`
 }
 
-func (inf *Inference) infer(facts map[string]Fact) (string, interface{}, []string, error) {
-	var derived []string
-	for _, rule := range inf.Rules {
-		result, d, err := rule.evaluate(facts)
-		if err != nil {
-			return "", nil, nil, err
+// name identifies the inference in logs and errors
+func (inf *Inference) name() string {
+	if inf.ID != "" {
+		return inf.ID
+	}
+	if inf.Description != "" {
+		return inf.Description
+	}
+	return inf.FactID
+}
+
+// justifiers returns the names the inferences justify their facts with:
+// their name, followed by their index when other inferences share it.
+func justifiers(inferences []Inference) []string {
+	count := make(map[string]int)
+	for i := range inferences {
+		count[inferences[i].name()]++
+	}
+	names := make([]string, len(inferences))
+	for i := range inferences {
+		names[i] = inferences[i].name()
+		if count[names[i]] > 1 {
+			names[i] = fmt.Sprintf("%s[%d]", names[i], i)
 		}
-		if !result {
-			return "", nil, nil, fmt.Errorf("rule %s is false", rule.Description)
+	}
+	return names
+}
+
+// inferred is a fact produced by an inference with what supports it.
+type inferred struct {
+	id        string
+	value     interface{}
+	premises  []string
+	certainty float64
+}
+
+func (inf *Inference) infer(exprs *ExpressionCache, facts map[string]Fact) (inferred, error) {
+	support, premises, err := inf.support(exprs, facts)
+	if inf.Threshold > 0 {
+		if support.Certainty < inf.Threshold {
+			return inferred{}, fmt.Errorf("certainty %.2f of %s is below the threshold %.2f", support.Certainty, inf.name(), inf.Threshold)
 		}
-		derived = append(derived, d...)
+	} else if err != nil {
+		return inferred{}, err
 	}
-	id, err := inf.getFactID(facts)
+	id, err := inf.getFactID(exprs, facts)
 	if err != nil {
-		return "", nil, nil, err
+		return inferred{}, err
+	}
+	var value interface{}
+	var d []string
+	degree := 1.0
+	if inf.Fuzzy != nil {
+		var g graded
+		g, err = inf.grade(exprs, facts)
+		value, d, degree = g.value, g.premises, g.degree
+	} else {
+		value, d, err = inf.getFactValue(exprs, facts)
 	}
-	value, d, err := inf.getFactValue(facts)
 	if err != nil {
-		return "", nil, nil, err
+		return inferred{}, err
+	}
+	// premises that are evidence against themselves do not support the fact
+	premise := support.Certainty * degree * minCertainty(facts, d)
+	if premise <= 0 {
+		return inferred{}, fmt.Errorf("premises of %s are not believed", inf.name())
+	}
+	return inferred{
+		id:        id,
+		value:     value,
+		premises:  unique(append(premises, d...)),
+		certainty: inf.cf() * premise,
+	}, nil
+}
+
+// idExpressions returns the expressions the fact ID is calculated with:
+// the FactID when IsIDCalculated, the expressions in braces of the template
+// of a pattern inference, none for a fixed ID.
+func (inf *Inference) idExpressions() []string {
+	if inf.IsIDCalculated {
+		return []string{inf.FactID}
+	}
+	if len(inf.ForEach) == 0 {
+		return nil
+	}
+	var expressions []string
+	for rest := inf.FactID; ; {
+		_, after, found := strings.Cut(rest, "{")
+		if !found {
+			return expressions
+		}
+		expression, after, closed := strings.Cut(after, "}")
+		if !closed {
+			return expressions
+		}
+		expressions = append(expressions, expression)
+		rest = after
+	}
+}
+
+// idCalculated reports whether the fact ID is known only once the inference
+// is evaluated.
+func (inf *Inference) idCalculated() bool {
+	return len(inf.idExpressions()) > 0
+}
+
+// expandID replaces every {expression} in the ID template of a pattern
+// inference by its value.
+func (inf *Inference) expandID(exprs *ExpressionCache, facts map[string]Fact) (string, error) {
+	var id strings.Builder
+	for rest := inf.FactID; ; {
+		before, after, found := strings.Cut(rest, "{")
+		id.WriteString(before)
+		if !found {
+			return id.String(), nil
+		}
+		expression, after, closed := strings.Cut(after, "}")
+		if !closed {
+			return "", fmt.Errorf("fact ID template %q has an unclosed {", inf.FactID)
+		}
+		value, _, err := exprs.Evaluate(expression, facts)
+		if err != nil {
+			return "", err
+		}
+		id.WriteString(fmt.Sprint(value))
+		rest = after
 	}
-	derived = append(derived, d...)
-	derived = unique(derived)
-	return id, value, derived, nil
 }
 
-func (inf *Inference) getFactID(facts map[string]Fact) (string, error) {
+func (inf *Inference) getFactID(exprs *ExpressionCache, facts map[string]Fact) (string, error) {
 	if !inf.IsIDCalculated {
+		if len(inf.ForEach) > 0 {
+			return inf.expandID(exprs, facts)
+		}
 		return inf.FactID, nil
 	} else {
-		id, _, err := Calculate(inf.FactID, facts)
+		id, _, err := exprs.Evaluate(inf.FactID, facts)
 		if err != nil {
 			return "", err
 		}
-		return id.(string), nil
+		sID, ok := id.(string)
+		if !ok {
+			return "", fmt.Errorf("calculated fact ID %q evaluated to %T, not a string", inf.FactID, id)
+		}
+		return sID, nil
 	}
 }
 
-func (inf *Inference) getFactValue(facts map[string]Fact) (interface{}, []string, error) {
+func (inf *Inference) getFactValue(exprs *ExpressionCache, facts map[string]Fact) (interface{}, []string, error) {
 	var empty []string
 	if !inf.IsValeCalculated {
 		return inf.FactValue, empty, nil
 	} else {
-		value, derived, err := Calculate(inf.FactValue.(string), facts)
+		sValue, ok := inf.FactValue.(string)
+		if !ok {
+			return "", empty, fmt.Errorf("calculated fact value of %s is %T, not an expression", inf.FactID, inf.FactValue)
+		}
+		value, derived, err := exprs.Evaluate(sValue, facts)
 		if err != nil {
 			return "", empty, err
 		}
@@ -75,13 +212,18 @@ func (inf *Inference) getFactValue(facts map[string]Fact) (interface{}, []string
 }
 
 func (inf *Inference) IsNeeded(facts map[string]Fact) bool {
-	if inf.OverWrite {
+	return inf.isNeeded(defaultExpressions, facts)
+}
+
+func (inf *Inference) isNeeded(exprs *ExpressionCache, facts map[string]Fact) bool {
+	// a pattern inference may fire for any new match
+	if inf.OverWrite || len(inf.ForEach) > 0 {
 		return true
 	}
-	id, err := inf.getFactID(facts)
+	id, err := inf.getFactID(exprs, facts)
 	if err != nil {
-		log.Errorf("Error getting fact id: %s", err)
-		return false
+		// evaluating the inference reports the error
+		return true
 	}
 	for key := range facts {
 		if strings.HasPrefix(key, id) {
@@ -91,31 +233,33 @@ func (inf *Inference) IsNeeded(facts map[string]Fact) bool {
 	return true
 }
 
+// Certainty returns the weighted certainty of the rules of the inference,
+// see Support.
 func (inf *Inference) Certainty(facts map[string]Fact) float64 {
-	total := 0.0
-	accomplished := 0.0
-	for _, rule := range inf.Rules {
-		total += rule.Weight
-		_, _, err := rule.evaluate(facts)
-		if err != nil {
-			continue
-		}
-	}
-	return accomplished / total
+	support, _, _ := inf.support(defaultExpressions, facts)
+	return support.Certainty
 }
 
-// PendingRules returns the rules that have not been achieved yet
-// sorted by weight
+// Support evaluates the rules of the inference against the facts, telling
+// apart the rules that hold, the ones that fail and the ones that read facts
+// not known yet.
+func (inf *Inference) Support(facts map[string]Fact) Support {
+	support, _, _ := inf.support(defaultExpressions, facts)
+	return support
+}
+
+// PendingRules returns the rules that cannot be evaluated yet because they
+// read facts that are not known, sorted by weight
 func (inf *Inference) PendingRules(facts map[string]Fact) []WeightedRule {
 	var pending []WeightedRule
 	for _, rule := range inf.Rules {
 		_, _, err := rule.evaluate(facts)
-		if err != nil {
+		if errors.Is(err, ErrUnknownFact) {
 			pending = append(pending, rule)
 		}
 	}
-	slices.SortFunc(pending, func(a, b WeightedRule) int {
-		return int(a.Weight - b.Weight)
+	slices.SortStableFunc(pending, func(a, b WeightedRule) int {
+		return cmp.Compare(a.Weight, b.Weight)
 	})
 	return pending
 }
@@ -1,7 +1,8 @@
 package inference
 
 import (
-	"github.com/expr-lang/expr"
+	"context"
+	"fmt"
 )
 
 // IntentType represents the type of user intent.
@@ -34,26 +35,34 @@ type IntentClassifier struct {
 }
 
 // Classify evaluates intent rules against facts and returns the best matching intent.
+// Rules that cannot be evaluated are skipped.
 func (ic *IntentClassifier) Classify(facts map[string]Fact) (Intent, error) {
+	return ic.ClassifyContext(context.Background(), facts)
+}
+
+// ClassifyContext is Classify stopping between the rules with the error of
+// ctx when ctx is done, returning the best intent found so far.
+func (ic *IntentClassifier) ClassifyContext(ctx context.Context, facts map[string]Fact) (Intent, error) {
+	return ic.classify(defaultExpressions, facts, ignoreErrors, contextSpender(ctx))
+}
+
+func (ic *IntentClassifier) classify(exprs *ExpressionCache, facts map[string]Fact, report reporter, spend spender) (Intent, error) {
 	if len(ic.Rules) == 0 {
 		return Intent{Type: IntentQuery, Description: "default"}, nil
 	}
 
-	env := make(map[string]interface{})
-	for k, v := range facts {
-		env[k] = v.Value
-	}
-
 	bestWeight := 0.0
 	bestIntent := Intent{Type: IntentQuery, Description: "default"}
 
-	for _, rule := range ic.Rules {
-		program, err := expr.Compile(rule.Expression, expr.Env(env))
-		if err != nil {
-			continue
+	for i, rule := range ic.Rules {
+		if err := spend(1); err != nil {
+			return bestIntent, err
 		}
-		output, err := expr.Run(program, env)
+		output, _, err := exprs.Evaluate(rule.Expression, facts)
 		if err != nil {
+			if err := report(fmt.Sprintf("intent_classifier.rules[%d].expression", i), string(rule.IntentType), err); err != nil {
+				return Intent{}, err
+			}
 			continue
 		}
 		result, ok := output.(bool)
@@ -1,16 +1,108 @@
 package inference
 
 import (
-	log "github.com/sirupsen/logrus"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
 	"slices"
+	"strings"
+	"time"
 )
 
+// DefaultMaxIterations is the number of forward chaining iterations Infer
+// runs before giving up when KnowledgeBase.MaxIterations is not set.
+const DefaultMaxIterations = 100
+
+// ErrMaxIterations is returned by Infer when the inferences keep producing
+// new facts after the maximum number of iterations, which usually means the
+// rule set oscillates.
+var ErrMaxIterations = errors.New("inference did not reach a fixpoint")
+
+// KnowledgeBase holds the rule definitions together with the working memory
+// of a single session. It is not safe for concurrent use: to serve several
+// users compile the definitions once with NewRuleSet and give each user a
+// Session.
 type KnowledgeBase struct {
 	RunningCount   int             `json:"runnings_count"`
 	Facts          map[string]Fact `json:"facts"`
 	Inferences     []Inference     `json:"inferences"`
 	Contradictions []Contradiction `json:"contradictions"`
 	Conclusions    []Conclusion    `json:"conclusions"`
+	// Schema optionally declares the facts: added facts are validated and
+	// expressions are type-checked against it
+	Schema Schema `json:"schema,omitempty"`
+	// MaxIterations bounds the forward chaining done by Infer,
+	// DefaultMaxIterations is used when it is zero
+	MaxIterations int `json:"max_iterations,omitempty"`
+	// BayesianNetwork optionally computes posterior probabilities from the
+	// facts, see BayesianNetwork
+	BayesianNetwork *BayesianNetwork `json:"bayesian_network,omitempty"`
+	// Sources gives the reliability, between 0 and 1, of the sources whose
+	// facts are combined as evidence instead of overwriting each other
+	Sources map[string]float64 `json:"sources,omitempty"`
+	// ConflictResolution makes the inferences fire one activation at a time
+	// from an agenda, in the order of the named strategy, see Agenda
+	ConflictResolution ConflictResolution `json:"conflict_resolution,omitempty"`
+	// Seed seeds the random conflict resolution
+	Seed int64 `json:"seed,omitempty"`
+	// StepThrough fills the agenda without firing it, Step fires one
+	// activation at a time
+	StepThrough bool `json:"step_through,omitempty"`
+	// ClosedWorld takes what is not known as false: rules reading facts
+	// that are not known evaluate them as nil and not_exists holds when no
+	// fact matches, instead of waiting for the facts. Rules that fail on a
+	// nil, like comparisons, still wait for them
+	ClosedWorld bool `json:"closed_world,omitempty"`
+	// Clock tells the time facts are observed, expire and age at, time.Now
+	// when nil
+	Clock func() time.Time `json:"-"`
+	// Retention is how long the earlier observations of a fact are kept in
+	// its History for the window operators, none are kept when zero
+	Retention Duration `json:"retention,omitempty"`
+	// Budget bounds the work of every call adding facts or running the
+	// inferences, which fail with a BudgetError when it runs out
+	Budget *Budget `json:"budget,omitempty"`
+	// Strict fails the calls on the first expression that does not compile
+	// or fails when run, instead of only reporting it in Diagnostics
+	Strict bool `json:"strict,omitempty"`
+	// Observer receives the events of every call, see Event
+	Observer Observer `json:"-"`
+
+	network *matchNetwork
+	exprs   *ExpressionCache
+	// reports holds the last fact reported by every weighed source, by
+	// fact ID
+	reports map[string]map[string]Fact
+	// scheduled holds the facts added before they are valid
+	scheduled map[string]Fact
+	// strategy overrides ConflictResolution, see SetStrategy
+	strategy Strategy
+	// agenda holds the activations waiting to fire
+	agenda []Activation
+	// clock counts the changes, timeTags holds when every fact last changed
+	clock    int
+	timeTags map[string]int
+	random   *rand.PCG
+	// dirty marks the inferences whose inputs changed since they were last
+	// evaluated, indexed like kb.Inferences
+	dirty []bool
+	// seen holds the facts as the match network last saw them
+	seen map[string]Fact
+
+	subscriptions    []subscription
+	lastSubscription int
+	// changes holds the facts touched by the current call, see Subscribe
+	changes     map[string]factChange
+	changeDepth int
+	// guard holds the context and budget of the call in progress
+	guard *guard
+	// diagnostics holds what went wrong evaluating the expressions in the
+	// last call
+	diagnostics Diagnostics
+	// asserted tells which conclusions held when the observers were last
+	// told, indexed like kb.Conclusions
+	asserted []bool
 }
 
 // Start the knowledge base session
@@ -20,71 +112,184 @@ type KnowledgeBase struct {
 func (kb *KnowledgeBase) Start() {
 	kb.RunningCount++
 	kb.Facts = make(map[string]Fact)
+	kb.reports = nil
+	kb.scheduled = nil
+	kb.matchNetwork()
+	kb.resetMatchState()
+}
+
+// AddFact adds a fact to the knowledge base. A fact declared in the Schema
+// is validated first and rejected with ValidationErrors when it does not
+// match its declaration. A fact from one of the Sources is combined with the
+// reports of the other sources. A fact is stamped with the Clock when it
+// has no observation time, and held back until its ValidFrom.
+func (kb *KnowledgeBase) AddFact(fact Fact) error {
+	return kb.AddFactContext(context.Background(), fact)
 }
 
-// AddFact adds a fact to the knowledge base
-func (kb *KnowledgeBase) AddFact(fact Fact) {
-	kb.Facts[fact.ID] = fact
-	kb.RemoveDerivedFrom(fact.ID)
-	kb.Infer()
+// AddFactContext is AddFact stopping the inferences it runs when ctx is
+// done, with the error of ctx. The fact is kept.
+func (kb *KnowledgeBase) AddFactContext(ctx context.Context, fact Fact) error {
+	leave := kb.enter(ctx, kb.Budget)
+	defer leave()
+	if err := kb.Schema.ValidateFact(fact); err != nil {
+		return err
+	}
+	fact = kb.observe(kb.weigh(fact))
+	kb.beginChanges()
+	defer kb.publishChanges()
+	if !kb.schedule(fact) {
+		kb.Facts[fact.ID] = fact
+		kb.touch(fact.ID, -1)
+		kb.RemoveDerivedFrom(fact.ID)
+	}
+	err := kb.Infer()
 	kb.ResolveContradictions()
+	return err
 }
 
-// Infer runs all the inferences in the knowledge base
-func (kb *KnowledgeBase) Infer() {
-	slices.SortFunc(kb.Inferences, func(i, j Inference) int {
-		return i.Order - j.Order
-	})
-	for _, inference := range kb.Inferences {
-		if inference.IsNeeded(kb.Facts) {
-			id, value, derived, err := inference.infer(kb.Facts)
+// Infer chains the inferences in the knowledge base until no new facts are
+// produced. With a ConflictResolution the activations fire one at a time
+// from the agenda instead. Every iteration updates the beliefs of the BayesianNetwork and
+// runs, in Order, the inferences whose input facts changed since they were
+// last evaluated. It first applies the Clock: scheduled facts that became
+// valid are added and expired facts are removed with what was derived from
+// them.
+func (kb *KnowledgeBase) Infer() error {
+	return kb.InferContext(context.Background())
+}
+
+// InferContext is Infer checking ctx and the Budget between the evaluations
+// of the inferences: it stops with the error of ctx when ctx is done, or a
+// BudgetError when the budget runs out, keeping the facts derived so far.
+// Called during another call, like from a custom stage, it shares the
+// budget of that call and stops when either context is done.
+func (kb *KnowledgeBase) InferContext(ctx context.Context) error {
+	leave := kb.enter(ctx, kb.Budget)
+	defer leave()
+	kb.beginChanges()
+	defer kb.publishChanges()
+	kb.syncMatchState()
+	if err := kb.advanceClock(); err != nil {
+		return err
+	}
+	limit := kb.MaxIterations
+	if limit <= 0 {
+		limit = DefaultMaxIterations
+	}
+	if kb.usesAgenda() {
+		return kb.inferAgenda(limit)
+	}
+	// activations left by Step fire in the next pass
+	for _, a := range kb.agenda {
+		kb.dirty[a.index] = true
+	}
+	kb.agenda = nil
+	for iteration := 0; ; iteration++ {
+		if err := kb.updateBeliefs(); err != nil {
+			return err
+		}
+		if !slices.Contains(kb.dirty, true) {
+			return nil
+		}
+		if iteration == limit {
+			var firing []string
+			for i, dirty := range kb.dirty {
+				if dirty {
+					firing = append(firing, kb.Inferences[i].name())
+				}
+			}
+			return fmt.Errorf("%w after %d iterations, still firing: %s",
+				ErrMaxIterations, limit, strings.Join(firing, ", "))
+		}
+		if err := kb.inferPass(); err != nil {
+			return err
+		}
+	}
+}
+
+// inferPass runs the dirty inferences once. It stops before evaluating an
+// inference when the call is cancelled or out of budget, leaving it dirty.
+func (kb *KnowledgeBase) inferPass() error {
+	for i := range kb.Inferences {
+		if !kb.dirty[i] {
+			continue
+		}
+		if err := kb.spend(1); err != nil {
+			return err
+		}
+		kb.dirty[i] = false
+		inference := kb.Inferences[i]
+		if len(inference.ForEach) > 0 {
+			fire, corroborate, err := kb.matchResults(i)
 			if err != nil {
-				log.Infof("Error inferring fact: %s", err)
-				continue
-			} else {
-				inference.CountOfTrue++
-				inference.Probability = (inference.Probability + float64(inference.CountOfTrue)/float64(kb.RunningCount)) / 2
+				if aborted(err) {
+					// stopped while binding, it runs again in the next call
+					kb.dirty[i] = true
+				}
+				return err
 			}
-			var accumulative bool
-			f, ok := kb.Facts[id]
-			if ok {
-				accumulative = f.Accumulative
+			for _, result := range slices.Concat(corroborate, fire) {
+				kb.derive(i, result)
+			}
+			continue
+		}
+		if !inference.isNeeded(kb.expressions(), kb.Facts) {
+			kb.corroborate(i)
+			continue
+		}
+		result, err := inference.infer(kb.expressions(), kb.Facts)
+		if err != nil {
+			if err := kb.report(fmt.Sprintf("inferences[%d]", i), inference.name(), err); err != nil {
+				return err
 			}
-			kb.Facts[id] = Fact{ID: id, Value: value, DerivedFrom: derived, Accumulative: accumulative}
+			continue
+		} else {
+			inference.CountOfTrue++
+			inference.Probability = (inference.Probability + float64(inference.CountOfTrue)/float64(kb.RunningCount)) / 2
 		}
+		kb.derive(i, result)
+	}
+	return nil
+}
+
+// expressions returns the cache used to compile the expressions of the
+// knowledge base, created from the Schema on first use. While observed the
+// evaluations are sent to the observers.
+func (kb *KnowledgeBase) expressions() *ExpressionCache {
+	if kb.exprs == nil || kb.exprs.closedWorld != kb.ClosedWorld {
+		kb.exprs = newWorldExpressions(kb.Schema, kb.ClosedWorld)
+		kb.exprs.now = kb.now
+	}
+	if kb.observed() {
+		return kb.observedExpressions(kb.exprs)
 	}
+	return kb.exprs
 }
 
 // GetPendingInference returns all the inferences that are needed
 func (kb *KnowledgeBase) GetPendingInference() []Inference {
 	var pending []Inference
 	for _, inference := range kb.Inferences {
-		if inference.IsNeeded(kb.Facts) {
+		if inference.isNeeded(kb.expressions(), kb.Facts) {
 			pending = append(pending, inference)
 		}
 	}
-	slices.SortFunc(pending, func(i, j Inference) int {
+	slices.SortStableFunc(pending, func(i, j Inference) int {
 		return int(i.Probability*100 - j.Probability*100)
 	})
 	return pending
 }
 
-// RemoveDerivedFrom removes all the facts that are derived from a given fact
-func (kb *KnowledgeBase) RemoveDerivedFrom(id string) {
-	for key, fact := range kb.Facts {
-		for _, derived := range fact.DerivedFrom {
-			if derived == id && !fact.Accumulative {
-				delete(kb.Facts, key)
-			}
-		}
-	}
-}
-
 // ResolveContradictions resolves all the contradictions in the knowledge base
 func (kb *KnowledgeBase) ResolveContradictions() {
+	kb.beginChanges()
+	defer kb.publishChanges()
 	for _, contradiction := range kb.Contradictions {
 		if contradiction.Detect(kb.Facts) {
+			kb.emit(Event{Type: EventContradictionDetected, Contradiction: contradiction.Description})
 			contradiction.Resolve(kb)
+			kb.emit(Event{Type: EventContradictionResolved, Contradiction: contradiction.Description})
 		}
 	}
 }
@@ -116,11 +321,11 @@ func (kb *KnowledgeBase) CertaintyForConclusion(conclusion Conclusion) float64 {
 	return conclusion.Certainty(kb.Facts)
 }
 
-// GetMissingFactIDs returns fact IDs needed by pending inferences but absent from current facts
+// GetMissingFactIDs returns fact IDs needed by pending inferences but absent from current facts, sorted
 func (kb *KnowledgeBase) GetMissingFactIDs() []string {
 	needed := make(map[string]bool)
 	for _, inf := range kb.Inferences {
-		if !inf.IsNeeded(kb.Facts) {
+		if !inf.isNeeded(kb.expressions(), kb.Facts) {
 			continue
 		}
 		for _, rule := range inf.Rules {
@@ -131,9 +336,5 @@ func (kb *KnowledgeBase) GetMissingFactIDs() []string {
 			}
 		}
 	}
-	var result []string
-	for id := range needed {
-		result = append(result, id)
-	}
-	return result
+	return sortedKeys(needed)
 }
@@ -0,0 +1,112 @@
+package inference
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestKnowledgeBase_InferChainsToFixpoint(t *testing.T) {
+	kb := KnowledgeBase{
+		Inferences: []Inference{
+			{
+				Description: "Vote in the primaries",
+				Rules:       []WeightedRule{{Rule: Rule{Expression: "can_vote == true"}, Weight: 1}},
+				FactID:      "primaries",
+				FactValue:   true,
+				Order:       0,
+			},
+			{
+				Description: "Can vote",
+				Rules:       []WeightedRule{{Rule: Rule{Expression: "age >= 18"}, Weight: 1}},
+				FactID:      "can_vote",
+				FactValue:   true,
+				Order:       5,
+			},
+		},
+	}
+	kb.Start()
+	if err := kb.AddFact(Fact{ID: "age", Value: 20}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if value, ok := kb.Facts["primaries"]; !ok || value.Value != true {
+		t.Errorf("Expected primaries to be inferred in the same call")
+	}
+}
+
+func TestKnowledgeBase_InferOscillation(t *testing.T) {
+	kb := KnowledgeBase{
+		MaxIterations: 10,
+		Inferences: []Inference{
+			{
+				Description:      "Light follows the switch",
+				Rules:            []WeightedRule{{Rule: Rule{Expression: "true"}, Weight: 1}},
+				FactID:           "light",
+				FactValue:        "!switch",
+				IsValeCalculated: true,
+				OverWrite:        true,
+			},
+			{
+				Description:      "Switch follows the light",
+				Rules:            []WeightedRule{{Rule: Rule{Expression: "true"}, Weight: 1}},
+				FactID:           "switch",
+				FactValue:        "light",
+				IsValeCalculated: true,
+				OverWrite:        true,
+			},
+		},
+	}
+	kb.Start()
+	err := kb.AddFact(Fact{ID: "switch", Value: false})
+	if !errors.Is(err, ErrMaxIterations) {
+		t.Fatalf("Expected ErrMaxIterations, got %v", err)
+	}
+	t.Logf("Oscillation error: %s", err)
+}
+
+func TestKnowledgeBase_InferOverwriteByOrder(t *testing.T) {
+	kb := KnowledgeBase{
+		Inferences: []Inference{
+			{
+				Rules:     []WeightedRule{{Rule: Rule{Expression: "fever == true"}, Weight: 1}},
+				FactID:    "triage_level",
+				FactValue: "yellow",
+				OverWrite: true,
+				Order:     1,
+			},
+			{
+				Rules:     []WeightedRule{{Rule: Rule{Expression: "fever == true && hypotension == true"}, Weight: 1}},
+				FactID:    "triage_level",
+				FactValue: "red",
+				OverWrite: true,
+				Order:     2,
+			},
+		},
+	}
+	kb.Start()
+	kb.AddFact(Fact{ID: "fever", Value: true})
+	if err := kb.AddFact(Fact{ID: "hypotension", Value: true}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if kb.Facts["triage_level"].Value != "red" {
+		t.Errorf("Expected red triage, got %v", kb.Facts["triage_level"].Value)
+	}
+}
+
+func TestKnowledgeBase_GetMissingFactIDsSorted(t *testing.T) {
+	kb := &KnowledgeBase{}
+	for _, id := range []string{"pulse", "age", "weight", "blood_pressure", "height"} {
+		kb.Inferences = append(kb.Inferences, Inference{
+			Rules:     []WeightedRule{{Rule: Rule{Expression: id + " > 0", FactTargetID: id}, Weight: 1}},
+			FactID:    "has_" + id,
+			FactValue: true,
+		})
+	}
+	kb.Start()
+	want := []string{"age", "blood_pressure", "height", "pulse", "weight"}
+	for range 10 {
+		if missing := kb.GetMissingFactIDs(); !slices.Equal(missing, want) {
+			t.Fatalf("Expected %v, got %v", want, missing)
+		}
+	}
+}
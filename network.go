@@ -0,0 +1,252 @@
+package inference
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+)
+
+// matchNetwork indexes the inferences of a knowledge base by the facts their
+// expressions reference, so Infer only re-evaluates the inferences whose
+// inputs changed since they were last evaluated instead of rescanning all of
+// them on every AddFact.
+//
+// The network itself is read only once built; the per-session state (which
+// inferences are dirty and the last fact values the network saw) lives on the
+// KnowledgeBase.
+type matchNetwork struct {
+	// inferences is the slice the network was built from, used to detect
+	// when kb.Inferences was replaced or resized and the network is stale.
+	inferences []Inference
+	nodes      []matchNode
+	// byFact maps a fact ID to the nodes whose expressions reference it.
+	byFact map[string][]int
+	// byOutput maps a static FactID to the non overwrite nodes whose
+	// IsNeeded check depends on the presence of facts prefixed by it.
+	byOutput map[string][]int
+	// producers maps a static FactID to the nodes that produce it.
+	producers map[string][]int
+	// dynamic lists the nodes whose inputs cannot be determined statically,
+	// they are re-evaluated whenever any fact changes.
+	dynamic []int
+	// timed lists the nodes whose expressions read the clock, they are
+	// re-evaluated whenever time may have passed.
+	timed []int
+	// justifiers are the names the justifications of the nodes carry.
+	justifiers []string
+}
+
+type matchNode struct {
+	inputs  []string
+	dynamic bool
+}
+
+// newMatchNetwork sorts the inferences by Order and builds the network for
+// them. Node i of the network corresponds to inferences[i].
+func newMatchNetwork(inferences []Inference) *matchNetwork {
+	slices.SortStableFunc(inferences, func(i, j Inference) int {
+		return i.Order - j.Order
+	})
+	net := &matchNetwork{
+		inferences: inferences,
+		nodes:      make([]matchNode, len(inferences)),
+		byFact:     make(map[string][]int),
+		byOutput:   make(map[string][]int),
+		producers:  make(map[string][]int),
+		justifiers: justifiers(inferences),
+	}
+	for i, inf := range inferences {
+		if !inf.idCalculated() {
+			net.producers[inf.FactID] = append(net.producers[inf.FactID], i)
+		}
+		if inferenceTimed(&inf) {
+			net.timed = append(net.timed, i)
+		}
+		node, ok := inferenceInputs(&inf)
+		if !ok {
+			net.nodes[i] = matchNode{dynamic: true}
+			net.dynamic = append(net.dynamic, i)
+			continue
+		}
+		net.nodes[i] = node
+		for _, id := range node.inputs {
+			net.byFact[id] = append(net.byFact[id], i)
+		}
+		if !inf.OverWrite {
+			net.byOutput[inf.FactID] = append(net.byOutput[inf.FactID], i)
+		}
+	}
+	return net
+}
+
+// inferenceInputs collects the facts referenced by the rules and the
+// calculated value of an inference. It reports false when they cannot be
+// known before evaluation: calculated IDs, patterns, expressions that do not
+// parse and expressions that read facts by prefix.
+func inferenceInputs(inf *Inference) (matchNode, bool) {
+	if inf.IsIDCalculated || len(inf.ForEach) > 0 {
+		return matchNode{}, false
+	}
+	var inputs []string
+	for _, rule := range inf.Rules {
+		if usesPrefixes(rule.Expression) {
+			return matchNode{}, false
+		}
+		ids, err := expressionFacts(rule.Expression)
+		if err != nil {
+			return matchNode{}, false
+		}
+		inputs = append(inputs, ids...)
+	}
+	if inf.IsValeCalculated {
+		sValue, ok := inf.FactValue.(string)
+		if !ok {
+			return matchNode{}, false
+		}
+		if usesPrefixes(sValue) {
+			return matchNode{}, false
+		}
+		ids, err := expressionFacts(sValue)
+		if err != nil {
+			return matchNode{}, false
+		}
+		inputs = append(inputs, ids...)
+	}
+	if inf.Fuzzy != nil {
+		ids, err := inf.Fuzzy.inputs()
+		if err != nil {
+			return matchNode{}, false
+		}
+		inputs = append(inputs, ids...)
+	}
+	return matchNode{inputs: unique(inputs)}, true
+}
+
+// inferenceTimed reports whether the expressions of the inference read the
+// clock.
+func inferenceTimed(inf *Inference) bool {
+	expressions := []string{}
+	for _, rule := range inf.Rules {
+		expressions = append(expressions, rule.Expression)
+	}
+	if sValue, ok := inf.FactValue.(string); ok && inf.IsValeCalculated {
+		expressions = append(expressions, sValue)
+	}
+	expressions = append(expressions, inf.idExpressions()...)
+	return slices.ContainsFunc(expressions, func(s string) bool {
+		return calls(s, temporalOperators...)
+	})
+}
+
+// builtFor reports whether the network was built from the given slice.
+func (net *matchNetwork) builtFor(inferences []Inference) bool {
+	if len(net.inferences) != len(inferences) {
+		return false
+	}
+	return len(inferences) == 0 || &net.inferences[0] == &inferences[0]
+}
+
+// affected calls fn for every node that must be re-evaluated when the fact
+// with the given ID is added, changed or removed.
+func (net *matchNetwork) affected(id string, fn func(node int)) {
+	for _, node := range net.byFact[id] {
+		fn(node)
+	}
+	// IsNeeded looks for any fact prefixed by the FactID of the inference
+	for i := 0; i <= len(id); i++ {
+		for _, node := range net.byOutput[id[:i]] {
+			fn(node)
+		}
+	}
+	for _, node := range net.dynamic {
+		fn(node)
+	}
+}
+
+// matchNetwork returns the network for the current inferences, rebuilding
+// it and marking every inference dirty when the inferences changed.
+func (kb *KnowledgeBase) matchNetwork() *matchNetwork {
+	if kb.network == nil || !kb.network.builtFor(kb.Inferences) {
+		kb.network = newMatchNetwork(kb.Inferences)
+		kb.resetMatchState()
+	}
+	return kb.network
+}
+
+// readNetwork returns the network for the current inferences without
+// touching the knowledge base: when its own network is stale, a network
+// over a sorted copy of the inferences.
+func (kb *KnowledgeBase) readNetwork() *matchNetwork {
+	if kb.network != nil && kb.network.builtFor(kb.Inferences) {
+		return kb.network
+	}
+	return newMatchNetwork(slices.Clone(kb.Inferences))
+}
+
+// resetMatchState marks every inference dirty so the next Infer evaluates
+// all of them against the current facts.
+func (kb *KnowledgeBase) resetMatchState() {
+	kb.dirty = make([]bool, len(kb.Inferences))
+	for i := range kb.dirty {
+		kb.dirty[i] = true
+	}
+	kb.seen = maps.Clone(kb.Facts)
+	if kb.seen == nil {
+		kb.seen = make(map[string]Fact)
+	}
+	kb.agenda = nil
+	kb.asserted = nil
+	kb.timeTags = make(map[string]int, len(kb.Facts))
+	for _, id := range sortedKeys(kb.Facts) {
+		kb.clock++
+		kb.timeTags[id] = kb.clock
+	}
+}
+
+// touch records that the fact with the given ID was added, changed or
+// removed and marks the inferences depending on it as dirty. The source is
+// the index of the inference that changed the fact, or -1; an inference is
+// not re-triggered by its own output.
+func (kb *KnowledgeBase) touch(id string, source int) {
+	net := kb.matchNetwork()
+	kb.recordChange(id)
+	kb.clock++
+	kb.timeTags[id] = kb.clock
+	if kb.observed() {
+		before, existed := kb.seen[id]
+		kb.factChanged(id, before, existed, source)
+	}
+	if fact, ok := kb.Facts[id]; ok {
+		kb.seen[id] = fact
+	} else {
+		delete(kb.seen, id)
+	}
+	net.affected(id, func(node int) {
+		if node != source {
+			kb.dirty[node] = true
+		}
+	})
+}
+
+// syncMatchState compares the facts with the ones the network saw last time
+// and touches the differences, so changes made directly on kb.Facts are
+// picked up as well.
+func (kb *KnowledgeBase) syncMatchState() {
+	kb.matchNetwork()
+	var changed []string
+	for id, fact := range kb.Facts {
+		old, ok := kb.seen[id]
+		if !ok || !reflect.DeepEqual(old.Value, fact.Value) {
+			changed = append(changed, id)
+		}
+	}
+	for id := range kb.seen {
+		if _, ok := kb.Facts[id]; !ok {
+			changed = append(changed, id)
+		}
+	}
+	slices.Sort(changed)
+	for _, id := range changed {
+		kb.touch(id, -1)
+	}
+}
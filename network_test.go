@@ -0,0 +1,91 @@
+package inference
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMatchNetwork_Inputs(t *testing.T) {
+	net := newMatchNetwork([]Inference{
+		{
+			Rules:            []WeightedRule{{Rule: Rule{Expression: "age >= 18 && country == 'AR'"}, Weight: 1}},
+			FactID:           "can_vote",
+			FactValue:        "age < 70",
+			IsValeCalculated: true,
+		},
+		{
+			Rules:          []WeightedRule{{Rule: Rule{Expression: "sale.Product == 'pizza'"}, Weight: 1}},
+			FactID:         "'sale_' + sale.Product",
+			IsIDCalculated: true,
+		},
+	})
+
+	if !slices.Equal(net.nodes[0].inputs, []string{"age", "country"}) {
+		t.Errorf("Expected inputs age and country, got %v", net.nodes[0].inputs)
+	}
+	if !net.nodes[1].dynamic {
+		t.Errorf("Expected calculated ID inference to be dynamic")
+	}
+
+	var affected []int
+	net.affected("country", func(node int) { affected = append(affected, node) })
+	if !slices.Equal(affected, []int{0, 1}) {
+		t.Errorf("Expected country to affect both inferences, got %v", affected)
+	}
+}
+
+func TestKnowledgeBase_InferOnlyDirty(t *testing.T) {
+	kb := KnowledgeBase{
+		Inferences: []Inference{
+			{
+				Rules:     []WeightedRule{{Rule: Rule{Expression: "age >= 18"}, Weight: 1}},
+				FactID:    "adult",
+				FactValue: true,
+			},
+			{
+				Rules:     []WeightedRule{{Rule: Rule{Expression: "temperature > 38"}, Weight: 1}},
+				FactID:    "fever",
+				FactValue: true,
+			},
+		},
+	}
+	kb.Start()
+	kb.AddFact(Fact{ID: "age", Value: 20})
+	if _, ok := kb.Facts["adult"]; !ok {
+		t.Fatalf("Expected adult to be inferred")
+	}
+
+	kb.Facts["temperature"] = Fact{ID: "temperature", Value: 39}
+	kb.syncMatchState()
+	if kb.dirty[0] {
+		t.Errorf("Expected adult inference to stay clean")
+	}
+	if !kb.dirty[1] {
+		t.Errorf("Expected fever inference to be dirty")
+	}
+	kb.Infer()
+	if _, ok := kb.Facts["fever"]; !ok {
+		t.Errorf("Expected fever to be inferred")
+	}
+}
+
+func TestKnowledgeBase_InferAfterDirectChange(t *testing.T) {
+	kb := KnowledgeBase{
+		Inferences: []Inference{
+			{
+				Rules:            []WeightedRule{{Rule: Rule{Expression: "true"}, Weight: 1}},
+				FactID:           "double",
+				FactValue:        "value * 2",
+				IsValeCalculated: true,
+				OverWrite:        true,
+			},
+		},
+	}
+	kb.Start()
+	kb.AddFact(Fact{ID: "value", Value: 2})
+	kb.Facts["value"] = Fact{ID: "value", Value: 5}
+	kb.Infer()
+	if kb.Facts["double"].Value != 10 {
+		t.Errorf("Expected double to be 10, got %v", kb.Facts["double"].Value)
+	}
+}
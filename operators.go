@@ -0,0 +1,182 @@
+package inference
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/expr-lang/expr/file"
+	"github.com/expr-lang/expr/parser/lexer"
+)
+
+// Every expression the engine evaluates can test what is known besides
+// what is true:
+//
+//	known(allergy)                   the fact allergy is known
+//	unknown(allergy)                 the fact allergy is not known
+//	exists("allergy_")               a fact whose ID starts with allergy_ is known
+//	not_exists("allergy_")           no fact whose ID starts with allergy_ is known
+//	count("allergy_")                how many facts start with allergy_
+//	exists("allergy_", # == "mild")  the value of one of them is "mild"
+//
+// known, unknown and count only look at what is known and hold in both
+// worlds. exists and not_exists decide in the closed world, where what is
+// not known is false, and are pending in the open world, the default, until
+// a matching fact is known: like a rule reading an unknown fact they make
+// the inference ask for the missing facts instead of failing. The facts
+// tested with known and unknown are premises, what was derived from them is
+// retracted when they change.
+
+// operatorNames are the functions the engine adds to the environment of the
+// expressions, with the ones the rewritten expressions call.
+var operatorNames = []string{"known", "unknown", "exists", "not_exists", "_count", "_exists", "_prefixed"}
+
+// namedOperators take the fact they read by name, as their first argument.
+var namedOperators = []string{"known", "unknown", "age", "within", "window_count", "window_sum"}
+
+// rewriteOperators turns the operators that expr-lang cannot compile as
+// plain functions into calls it can: known(x) and the other named operators
+// take the name of the fact instead of its value, count over a prefix does
+// not take a predicate and exists with a predicate becomes the builtin any
+// over the matching facts.
+// Expressions that do not lex are left for the parser to report.
+func rewriteOperators(sExpression string) string {
+	tokens, err := lexer.Lex(file.NewSource(sExpression))
+	if err != nil {
+		return sExpression
+	}
+	type edit struct {
+		from, to int
+		text     string
+	}
+	var edits []edit
+	is := func(i int, kind lexer.Kind, values ...string) bool {
+		return i < len(tokens) && tokens[i].Kind == kind && (len(values) == 0 || slices.Contains(values, tokens[i].Value))
+	}
+	for i, token := range tokens {
+		if token.Kind != lexer.Identifier || !is(i+1, lexer.Bracket, "(") || (i > 0 && is(i-1, lexer.Operator, ".", "?.")) {
+			continue
+		}
+		switch {
+		case slices.Contains(namedOperators, token.Value) && is(i+2, lexer.Identifier) && (is(i+3, lexer.Bracket, ")") || is(i+3, lexer.Operator, ",")):
+			arg := tokens[i+2]
+			edits = append(edits, edit{arg.From, arg.To, strconv.Quote(arg.Value)})
+		case token.Value == "count" && is(i+2, lexer.String) && is(i+3, lexer.Bracket, ")"):
+			edits = append(edits, edit{token.From, token.To, "_count"})
+		case token.Value == "exists" && is(i+2, lexer.String) && is(i+3, lexer.Operator, ","):
+			end := closingBracket(tokens, i+1)
+			if end < 0 {
+				continue
+			}
+			prefix := strconv.Quote(tokens[i+2].Value)
+			edits = append(edits,
+				edit{token.From, token.To, "_exists"},
+				edit{tokens[i+3].To, tokens[i+3].To, " any(_prefixed(" + prefix + "),"},
+				edit{tokens[end].From, tokens[end].From, ")"})
+		}
+	}
+	if len(edits) == 0 {
+		return sExpression
+	}
+	slices.SortStableFunc(edits, func(a, b edit) int { return b.from - a.from })
+	source := []rune(sExpression)
+	for _, e := range edits {
+		source = slices.Concat(source[:e.from], []rune(e.text), source[e.to:])
+	}
+	return string(source)
+}
+
+// closingBracket returns the index of the token closing the bracket opened
+// at open, or -1.
+func closingBracket(tokens []lexer.Token, open int) int {
+	depth := 0
+	for i := open; i < len(tokens); i++ {
+		if tokens[i].Kind != lexer.Bracket {
+			continue
+		}
+		switch tokens[i].Value {
+		case "(", "[", "{":
+			depth++
+		default:
+			depth--
+		}
+		if depth == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// operators returns the functions of the operators evaluated against the
+// facts.
+func operators(facts map[string]Fact, closedWorld bool) map[string]interface{} {
+	prefixed := func(prefix string) []string {
+		var ids []string
+		for id := range facts {
+			if strings.HasPrefix(id, prefix) {
+				ids = append(ids, id)
+			}
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	// exists is pending in the open world when nothing was found
+	exists := func(prefix string, found bool) (bool, error) {
+		if !found && !closedWorld {
+			return false, fmt.Errorf("%w %s*", ErrUnknownFact, prefix)
+		}
+		return found, nil
+	}
+	return map[string]interface{}{
+		"known": func(id string) bool {
+			_, ok := facts[id]
+			return ok
+		},
+		"unknown": func(id string) bool {
+			_, ok := facts[id]
+			return !ok
+		},
+		"exists": func(prefix string) (bool, error) {
+			return exists(prefix, len(prefixed(prefix)) > 0)
+		},
+		"not_exists": func(prefix string) (bool, error) {
+			found, err := exists(prefix, len(prefixed(prefix)) > 0)
+			return !found, err
+		},
+		"_count": func(prefix string) int {
+			return len(prefixed(prefix))
+		},
+		"_exists": exists,
+		"_prefixed": func(prefix string) []interface{} {
+			ids := prefixed(prefix)
+			values := make([]interface{}, len(ids))
+			for i, id := range ids {
+				values[i] = facts[id].Value
+			}
+			return values
+		},
+	}
+}
+
+// usesPrefixes reports whether the expression reads facts by prefix, so
+// the facts it depends on cannot be known before evaluation.
+func usesPrefixes(sExpression string) bool {
+	return calls(sExpression, "exists", "not_exists", "_count", "_exists")
+}
+
+// calls reports whether the rewritten expression calls any of the
+// functions.
+func calls(sExpression string, names ...string) bool {
+	tokens, err := lexer.Lex(file.NewSource(rewriteOperators(sExpression)))
+	if err != nil {
+		return false
+	}
+	for i, token := range tokens {
+		if token.Kind == lexer.Identifier && slices.Contains(names, token.Value) &&
+			i+1 < len(tokens) && tokens[i+1].Kind == lexer.Bracket && tokens[i+1].Value == "(" {
+			return true
+		}
+	}
+	return false
+}
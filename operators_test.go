@@ -0,0 +1,150 @@
+package inference
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestRewriteOperators(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       string
+	}{
+		{`known(allergy)`, `known("allergy")`},
+		{`unknown(allergy) && age > 3`, `unknown("allergy") && age > 3`},
+		{`count("allergy_") > 1`, `_count("allergy_") > 1`},
+		{`count(items, # > 1)`, `count(items, # > 1)`},
+		{`exists("allergy_", # == "mild")`, `_exists("allergy_", any(_prefixed("allergy_"), # == "mild"))`},
+		{`exists("a_", # in [1, 2]) || exists("b_")`, `_exists("a_", any(_prefixed("a_"), # in [1, 2])) || exists("b_")`},
+		{`patient.known(x)`, `patient.known(x)`},
+		{`window_sum(sale, "1h", "price") > age(x)`, `window_sum("sale", "1h", "price") > age("x")`},
+	}
+	for _, tt := range tests {
+		if got := rewriteOperators(tt.expression); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.expression, tt.want, got)
+		}
+	}
+}
+
+func TestOperators(t *testing.T) {
+	facts := map[string]Fact{
+		"age":             {ID: "age", Value: 30},
+		"allergy_peanut":  {ID: "allergy_peanut", Value: "severe"},
+		"allergy_penicil": {ID: "allergy_penicil", Value: "mild"},
+	}
+	open := newWorldExpressions(nil, false)
+	closed := newWorldExpressions(nil, true)
+	tests := []struct {
+		expression  string
+		open        interface{}
+		closed      interface{}
+		openPending bool
+	}{
+		{`known(age) && unknown(weight)`, true, true, false},
+		{`count("allergy_") == 2 && count("smoker") == 0`, true, true, false},
+		{`exists("allergy_") && not_exists("surgery_")`, nil, true, true},
+		{`not_exists("allergy_")`, false, false, false},
+		{`exists("allergy_", # == "mild")`, true, true, false},
+		{`exists("allergy_", # == "none")`, nil, false, true},
+		{`weight == nil`, nil, true, true},
+	}
+	for _, tt := range tests {
+		got, _, err := open.Evaluate(tt.expression, facts)
+		if tt.openPending {
+			if !errors.Is(err, ErrUnknownFact) {
+				t.Errorf("%s: expected pending in the open world, got %v, %v", tt.expression, got, err)
+			}
+		} else if err != nil || got != tt.open {
+			t.Errorf("%s: expected %v in the open world, got %v, %v", tt.expression, tt.open, got, err)
+		}
+		if got, _, err := closed.Evaluate(tt.expression, facts); err != nil || got != tt.closed {
+			t.Errorf("%s: expected %v in the closed world, got %v, %v", tt.expression, tt.closed, got, err)
+		}
+	}
+}
+
+func TestExpressionFacts_Operators(t *testing.T) {
+	ids, err := expressionFacts(`known(allergy) && len(items) > 0 && exists("x_", # > age)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(ids)
+	if !slices.Equal(ids, []string{"age", "allergy", "items"}) {
+		t.Errorf("Expected the facts without the called functions, got %v", ids)
+	}
+}
+
+func TestKnowledgeBase_NegationAsFailure(t *testing.T) {
+	newKB := func(closedWorld bool) *KnowledgeBase {
+		kb := &KnowledgeBase{
+			ClosedWorld: closedWorld,
+			Inferences: []Inference{
+				{ID: "safe", Rules: rule(`infection && not_exists("allergy_")`), FactID: "penicillin", FactValue: true},
+				{ID: "several", Rules: rule(`count("allergy_") > 1`), FactID: "allergist", FactValue: true},
+			},
+		}
+		kb.Start()
+		return kb
+	}
+
+	kb := newKB(false)
+	kb.AddFact(Fact{ID: "infection", Value: true})
+	if _, ok := kb.Facts["penicillin"]; ok {
+		t.Errorf("Expected the open world to wait for the allergies")
+	}
+	if pending := kb.GetPendingInference(); !slices.ContainsFunc(pending, func(inf Inference) bool { return inf.ID == "safe" }) {
+		t.Errorf("Expected the inference to stay pending, got %v", pending)
+	}
+
+	kb = newKB(true)
+	kb.AddFact(Fact{ID: "infection", Value: true})
+	if !kb.Facts["penicillin"].Value.(bool) {
+		t.Errorf("Expected the closed world to conclude there are no allergies")
+	}
+
+	kb = newKB(true)
+	kb.AddFact(Fact{ID: "allergy_peanut", Value: true})
+	kb.AddFact(Fact{ID: "infection", Value: true})
+	if _, ok := kb.Facts["penicillin"]; ok {
+		t.Errorf("Expected an allergy to block the inference")
+	}
+	kb.AddFact(Fact{ID: "allergy_penicillin", Value: true})
+	if _, ok := kb.Facts["allergist"]; !ok {
+		t.Errorf("Expected a new prefixed fact to re-evaluate the count")
+	}
+}
+
+func TestKnowledgeBase_RetractUnknown(t *testing.T) {
+	kb := &KnowledgeBase{
+		Inferences: []Inference{{ID: "safe", Rules: rule(`infection && unknown(allergy)`), FactID: "penicillin", FactValue: true}},
+	}
+	kb.Start()
+	kb.AddFact(Fact{ID: "infection", Value: true})
+	if _, ok := kb.Facts["penicillin"]; !ok {
+		t.Fatalf("Expected no known allergy to allow penicillin")
+	}
+	kb.AddFact(Fact{ID: "allergy", Value: "penicillin"})
+	if _, ok := kb.Facts["penicillin"]; ok {
+		t.Errorf("Expected penicillin retracted once the allergy is known")
+	}
+}
+
+func TestKnowledgeBase_ClosedWorldComparison(t *testing.T) {
+	kb := &KnowledgeBase{
+		ClosedWorld: true,
+		Strict:      true,
+		Inferences:  []Inference{{ID: "adult", Rules: rule("age > 17"), FactID: "adult", FactValue: true}},
+	}
+	kb.Start()
+	if err := kb.AddFact(Fact{ID: "name", Value: "ann"}); err != nil {
+		t.Fatalf("Expected a comparison on an unknown fact not to be an error, got %v", err)
+	}
+	if d := kb.Diagnostics(); len(d) != 1 || d[0].Severity != SeverityPending {
+		t.Errorf("Expected the comparison to wait for age, got %v", d)
+	}
+	kb.AddFact(Fact{ID: "age", Value: 30})
+	if _, ok := kb.Facts["adult"]; !ok {
+		t.Errorf("Expected the comparison to hold once age is known")
+	}
+}
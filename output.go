@@ -5,6 +5,8 @@ type Reasoning struct {
 	Signals     []string `json:"signals"`
 	Assumptions []string `json:"assumptions"`
 	Tradeoffs   []string `json:"tradeoffs"`
+	// Explanation renders the Explanations of the result as readable text
+	Explanation string `json:"explanation,omitempty"`
 }
 
 // FollowUp captures what's still needed after pipeline execution.
@@ -25,4 +27,9 @@ type PipelineResult struct {
 	Constraints []Constraint   `json:"constraints"`
 	Risks      []Risk          `json:"risks"`
 	Solutions  []RankedSolution `json:"solutions,omitempty"`
+	// Explanations tells, for every conclusion, why it holds or why not
+	Explanations []ConclusionExplanation `json:"explanations,omitempty"`
+	// Diagnostics lists the expressions that failed or waited for facts
+	// not known yet, with the stage that evaluated them
+	Diagnostics Diagnostics `json:"diagnostics,omitempty"`
 }
@@ -0,0 +1,175 @@
+package inference
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Pattern binds a variable to every fact whose ID matches a glob, where *
+// stands for any run of characters, or whose Type is the given tag. The
+// variable holds a map with the id of the fact, its value and the key, the
+// parts of the ID matched by the wildcards joined by "_":
+//
+//	{"var": "order", "id": "order_*"}
+//
+// binds order to {"id": "order_17", "key": "17", "value": ...} so an
+// inference can read order.value.total. The fact ID of a pattern inference
+// is a template unless IsIDCalculated is set: every {expression} in it is
+// replaced by its value, so review_{order.key} derives review_17.
+type Pattern struct {
+	Var  string `json:"var"`
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type,omitempty"`
+}
+
+// matches returns the IDs of the facts the pattern matches, sorted, with
+// their keys.
+func (p Pattern) matches(facts map[string]Fact) ([]string, map[string]string) {
+	var ids []string
+	keys := make(map[string]string)
+	for id, fact := range facts {
+		if p.Type != "" && fact.Type != p.Type {
+			continue
+		}
+		if p.ID == "" {
+			ids = append(ids, id)
+			keys[id] = id
+			continue
+		}
+		if parts, ok := glob(p.ID, id); ok {
+			ids = append(ids, id)
+			keys[id] = strings.Join(parts, "_")
+		}
+	}
+	slices.Sort(ids)
+	return ids, keys
+}
+
+// glob matches the ID against the pattern and returns the parts matched by
+// the wildcards.
+func glob(pattern, id string) ([]string, bool) {
+	literal, rest, wildcard := strings.Cut(pattern, "*")
+	if !strings.HasPrefix(id, literal) {
+		return nil, false
+	}
+	id = id[len(literal):]
+	if !wildcard {
+		return nil, id == ""
+	}
+	// the shortest run that lets the rest of the pattern match
+	for i := 0; i <= len(id); i++ {
+		if parts, ok := glob(rest, id[i:]); ok {
+			return append([]string{id[:i]}, parts...), true
+		}
+	}
+	return nil, false
+}
+
+// bindings returns the combinations of the facts matched by the patterns of
+// the inference, in order of their IDs, as the ID of the fact bound to every
+// variable. A fact is not bound to two variables of the same combination.
+// Every combination is evaluated once, so each is spent as an evaluation
+// while they are built.
+func (inf *Inference) bindings(facts map[string]Fact, spend spender) ([]map[string]string, map[string]string, error) {
+	bindings := []map[string]string{{}}
+	keys := make(map[string]string)
+	for _, p := range inf.ForEach {
+		ids, matchedKeys := p.matches(facts)
+		maps.Copy(keys, matchedKeys)
+		var next []map[string]string
+		for _, b := range bindings {
+			for _, id := range ids {
+				if bindsFact(b, id) {
+					continue
+				}
+				if err := spend(1); err != nil {
+					return nil, nil, err
+				}
+				bound := maps.Clone(b)
+				bound[p.Var] = id
+				next = append(next, bound)
+			}
+		}
+		bindings = next
+	}
+	return bindings, keys, nil
+}
+
+// inferEach evaluates a pattern inference once per binding and returns the
+// facts of the bindings whose rules hold. The premises name the matched
+// facts instead of the variables. The evaluation errors are reported under
+// path, it fails when report or spend stops it.
+func (inf *Inference) inferEach(exprs *ExpressionCache, facts map[string]Fact, path string, report reporter, spend spender) ([]inferred, error) {
+	bindings, keys, err := inf.bindings(facts, spend)
+	if err != nil || len(bindings) == 0 {
+		return nil, err
+	}
+	bound := maps.Clone(facts)
+	var results []inferred
+	for _, b := range bindings {
+		for variable, id := range b {
+			bound[variable] = Fact{ID: variable, Value: map[string]interface{}{
+				"id":    id,
+				"key":   keys[id],
+				"value": facts[id].Value,
+			}, Certainty: facts[id].Certainty}
+		}
+		result, err := inf.infer(exprs, bound)
+		if err != nil {
+			if err := report(path, inf.name(), err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for k, premise := range result.premises {
+			if id, ok := b[premise]; ok {
+				result.premises[k] = id
+			}
+		}
+		for _, variable := range sortedKeys(b) {
+			result.premises = append(result.premises, b[variable])
+		}
+		result.premises = unique(result.premises)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// matchResults evaluates the pattern inference at index i and splits its
+// results into the ones that change the facts and the ones that hold the
+// value their fact already has. Unlike other inferences, a pattern inference
+// is needed for every fact it would derive that is not known.
+func (kb *KnowledgeBase) matchResults(i int) (fire, corroborate []inferred, err error) {
+	inf := &kb.Inferences[i]
+	results, err := inf.inferEach(kb.expressions(), kb.Facts, fmt.Sprintf("inferences[%d]", i), kb.report, kb.spend)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, result := range results {
+		old, ok := kb.Facts[result.id]
+		switch {
+		case !ok:
+			fire = append(fire, result)
+		case reflect.DeepEqual(old.Value, result.value):
+			if !old.isBase() {
+				corroborate = append(corroborate, result)
+			}
+		case inf.OverWrite:
+			fire = append(fire, result)
+		}
+	}
+	return fire, corroborate, nil
+}
+
+// bindsFact reports whether a variable of the binding is bound to the fact.
+func bindsFact(binding map[string]string, id string) bool {
+	for _, bound := range binding {
+		if bound == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package inference
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestGlob(t *testing.T) {
+	tests := []struct {
+		pattern, id string
+		parts       []string
+		ok          bool
+	}{
+		{"order_*", "order_17", []string{"17"}, true},
+		{"order_*", "order_", []string{""}, true},
+		{"order_*", "orders", nil, false},
+		{"*_line_*", "order_17_line_2", []string{"order_17", "2"}, true},
+		{"total", "total", nil, true},
+		{"total", "totals", nil, false},
+	}
+	for _, tt := range tests {
+		parts, ok := glob(tt.pattern, tt.id)
+		if ok != tt.ok || !slices.Equal(parts, tt.parts) {
+			t.Errorf("%s on %s: expected %v %v, got %v %v", tt.pattern, tt.id, tt.parts, tt.ok, parts, ok)
+		}
+	}
+}
+
+func reviewKB() *KnowledgeBase {
+	kb := &KnowledgeBase{
+		Inferences: []Inference{{
+			ID:               "review",
+			ForEach:          []Pattern{{Var: "order", ID: "order_*"}},
+			Rules:            rule("order.value.total > 1000"),
+			FactID:           "review_{order.key}",
+			FactValue:        "order.value.customer",
+			IsValeCalculated: true,
+		}},
+	}
+	kb.Start()
+	return kb
+}
+
+func TestKnowledgeBase_PatternInference(t *testing.T) {
+	kb := reviewKB()
+	kb.AddFact(Fact{ID: "order_1", Value: map[string]interface{}{"total": 1500, "customer": "ann"}})
+	kb.AddFact(Fact{ID: "order_2", Value: map[string]interface{}{"total": 200, "customer": "bob"}})
+	kb.AddFact(Fact{ID: "order_3", Value: map[string]interface{}{"total": 3000, "customer": "cid"}})
+
+	if kb.Facts["review_1"].Value != "ann" || kb.Facts["review_3"].Value != "cid" {
+		t.Errorf("Expected a review per large order, got %v and %v", kb.Facts["review_1"], kb.Facts["review_3"])
+	}
+	if _, ok := kb.Facts["review_2"]; ok {
+		t.Errorf("Expected no review of the small order")
+	}
+	if premises := kb.Facts["review_3"].DerivedFrom; !slices.Equal(premises, []string{"order_3"}) {
+		t.Errorf("Expected the review to be derived from its order, got %v", premises)
+	}
+
+	kb.AddFact(Fact{ID: "order_3", Value: map[string]interface{}{"total": 30, "customer": "cid"}})
+	if _, ok := kb.Facts["review_3"]; ok {
+		t.Errorf("Expected the review to be retracted with its order")
+	}
+	if _, ok := kb.Facts["review_1"]; !ok {
+		t.Errorf("Expected the other reviews to stay")
+	}
+}
+
+func TestKnowledgeBase_PatternJoin(t *testing.T) {
+	kb := &KnowledgeBase{
+		ConflictResolution: ResolveByOrder,
+		Inferences: []Inference{{
+			ID: "vip order",
+			ForEach: []Pattern{
+				{Var: "order", Type: "order"},
+				{Var: "customer", Type: "customer"},
+			},
+			Rules:          rule(`order.value.customer == customer.key && customer.value == "vip"`),
+			FactID:         `"priority_" + order.key`,
+			IsIDCalculated: true,
+			FactValue:      true,
+		}},
+	}
+	kb.Start()
+	kb.AddFact(Fact{ID: "ann", Type: "customer", Value: "vip"})
+	kb.AddFact(Fact{ID: "bob", Type: "customer", Value: "regular"})
+	kb.AddFact(Fact{ID: "o1", Type: "order", Value: map[string]interface{}{"customer": "ann"}})
+	kb.AddFact(Fact{ID: "o2", Type: "order", Value: map[string]interface{}{"customer": "bob"}})
+
+	if _, ok := kb.Facts["priority_o1"]; !ok {
+		t.Errorf("Expected the order of the vip to be a priority")
+	}
+	if _, ok := kb.Facts["priority_o2"]; ok {
+		t.Errorf("Expected the regular order not to be a priority")
+	}
+	if premises := kb.Facts["priority_o1"].DerivedFrom; !slices.Equal(premises, []string{"o1", "ann"}) {
+		t.Errorf("Expected the matched facts as premises, got %v", premises)
+	}
+}
+
+func TestKnowledgeBase_PatternIDTemplate(t *testing.T) {
+	kb := &KnowledgeBase{
+		Inferences: []Inference{
+			{ID: "line", ForEach: []Pattern{{Var: "l", ID: "order_*_line_*"}}, Rules: rule("l.value > 0"), FactID: "{l.key}_{l.value * 2}", FactValue: true},
+			{ID: "any", ForEach: []Pattern{{Var: "l", ID: "order_*_line_*"}}, Rules: rule("l.value > 5"), FactID: "large_order", FactValue: true},
+		},
+	}
+	kb.Start()
+	kb.AddFact(Fact{ID: "order_7_line_2", Value: 3})
+	if _, ok := kb.Facts["7_2_6"]; !ok {
+		t.Errorf("Expected the template expanded, got %v", sortedKeys(kb.Facts))
+	}
+	if _, ok := kb.Facts["large_order"]; ok {
+		t.Errorf("Expected no large order")
+	}
+	kb.AddFact(Fact{ID: "order_8_line_1", Value: 6})
+	if _, ok := kb.Facts["large_order"]; !ok {
+		t.Errorf("Expected an ID without braces kept as it is, got %v", sortedKeys(kb.Facts))
+	}
+}
+
+func TestKnowledgeBase_PatternBudget(t *testing.T) {
+	kb := &KnowledgeBase{
+		Budget: &Budget{MaxEvaluations: 50},
+		Inferences: []Inference{{
+			ID:        "pairs",
+			ForEach:   []Pattern{{Var: "a", Type: "n"}, {Var: "b", Type: "n"}, {Var: "c", Type: "n"}},
+			Rules:     rule("a.value < b.value && b.value < c.value"),
+			FactID:    "{a.key}_{b.key}_{c.key}",
+			FactValue: true,
+		}},
+	}
+	kb.Start()
+	for i := range 5 {
+		kb.Facts[fmt.Sprint("n", i)] = Fact{ID: fmt.Sprint("n", i), Type: "n", Value: i}
+	}
+	err := kb.Infer()
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) || budgetErr.Limit != "max_evaluations" {
+		t.Errorf("Expected the combinations charged to the budget, got %v", err)
+	}
+
+	kb.Budget = nil
+	if err := kb.Infer(); err != nil || kb.Facts["n0_n1_n2"].Value != true {
+		t.Errorf("Expected the combinations evaluated without a budget, got %v, %v", err, sortedKeys(kb.Facts))
+	}
+}
+
+func TestValidate_Patterns(t *testing.T) {
+	kb := &KnowledgeBase{Inferences: []Inference{{
+		FactID:  "x_{a.key",
+		ForEach: []Pattern{{Var: "a", ID: "a_*"}, {Var: "a", Type: "t"}, {ID: "b_*"}, {Var: "c"}},
+	}}}
+	var paths []string
+	for _, d := range kb.Validate() {
+		if d.Severity == SeverityError {
+			paths = append(paths, d.Path)
+		}
+	}
+	want := []string{"inferences[0].fact_id", "inferences[0].for_each[1].var", "inferences[0].for_each[2].var", "inferences[0].for_each[3]"}
+	if !slices.Equal(paths, want) {
+		t.Errorf("Expected %v, got %v", want, paths)
+	}
+}
+
+func TestKnowledgeBase_PatternDiagnostics(t *testing.T) {
+	kb := &KnowledgeBase{
+		Inferences: []Inference{{
+			ID:        "review",
+			ForEach:   []Pattern{{Var: "order", ID: "order_*"}},
+			Rules:     rule(`order.value.total > "x"`),
+			FactID:    "review_{order.key}",
+			FactValue: true,
+		}},
+	}
+	kb.Start()
+	if err := kb.AddFact(Fact{ID: "order_1", Value: map[string]interface{}{"total": 1500}}); err != nil {
+		t.Fatal(err)
+	}
+	diags := kb.Diagnostics()
+	if len(diags) != 1 || diags[0].Path != "inferences[0]" || diags[0].Severity != SeverityError || diags[0].Rule != "review" {
+		t.Errorf("Expected the type mismatch of the pattern inference reported, got %v", diags)
+	}
+
+	kb.Strict = true
+	err := kb.AddFact(Fact{ID: "order_2", Value: map[string]interface{}{"total": 10}})
+	var evalErr *EvaluationError
+	if !errors.As(err, &evalErr) || evalErr.Expression != `order.value.total > "x"` {
+		t.Errorf("Expected strict mode to fail on the pattern inference, got %v", err)
+	}
+}
@@ -1,8 +1,11 @@
 package inference
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 )
 
 // PipelineConfig holds all components needed for the 6-step pipeline.
@@ -15,10 +18,26 @@ type PipelineConfig struct {
 	DomainDetector   *DomainDetector   `json:"domain_detector,omitempty"`
 	ScoringWeights   *SolutionScore    `json:"scoring_weights,omitempty"`
 	DomainWeights    map[Domain]SolutionScore `json:"domain_weights,omitempty"`
+	// Schema declares the input facts, on top of the knowledge base schema
+	Schema Schema `json:"schema,omitempty"`
+	// Stages lists the stages of the pipeline in order, DefaultStages
+	// when empty, see RegisterStage
+	Stages []StageConfig `json:"stages,omitempty"`
+	// Budget bounds the work of a whole run, the budget of the knowledge
+	// base is used when nil
+	Budget *Budget `json:"budget,omitempty"`
+	// Strict fails the run on the first expression that does not compile or
+	// fails when run, see KnowledgeBase.Strict
+	Strict bool `json:"strict,omitempty"`
 }
 
 // PipelineState tracks intermediate results through pipeline steps.
 type PipelineState struct {
+	// Input holds the input facts of the run
+	Input map[string]Fact
+	// KnowledgeBase is the working memory of the run
+	KnowledgeBase *KnowledgeBase
+
 	Domain      Domain
 	Intent      Intent
 	Entities    []Entity
@@ -30,8 +49,22 @@ type PipelineState struct {
 }
 
 // Pipeline orchestrates the 6-step deterministic pipeline.
+//
+// Run works on Config.KnowledgeBase in place and is not safe for concurrent
+// use. To serve concurrent users give each one a Session from NewSession and
+// call RunSession: the pipeline configuration is only read, so any number of
+// sessions can run at the same time.
+//
+// A stage that fails aborts the run with its error. That includes adding a
+// fact or running the inferences, when Infer does not reach a fixpoint or
+// fails in Strict mode.
 type Pipeline struct {
 	Config PipelineConfig
+	// Stages, when set, run instead of the ones built from Config.Stages
+	Stages []Stage
+
+	rulesOnce sync.Once
+	rules     *RuleSet
 }
 
 // NewPipeline creates a pipeline from a config.
@@ -39,116 +72,130 @@ func NewPipeline(config PipelineConfig) *Pipeline {
 	return &Pipeline{Config: config}
 }
 
-// Run executes the full 6-step pipeline on input facts.
+// Run executes the full 6-step pipeline on input facts. Input facts that do
+// not match the schema are rejected with ValidationErrors before any step
+// runs.
 func (p *Pipeline) Run(inputFacts map[string]Fact) (*PipelineResult, error) {
+	return p.RunContext(context.Background(), inputFacts)
+}
+
+// RunContext is Run stopping when ctx is done or the Budget runs out,
+// between stages and between the evaluations of the inferences. It then
+// returns the error of ctx or a BudgetError together with the result built
+// from what the stages that ran found.
+func (p *Pipeline) RunContext(ctx context.Context, inputFacts map[string]Fact) (*PipelineResult, error) {
 	if p.Config.KnowledgeBase == nil {
 		return nil, fmt.Errorf("knowledge base is required")
 	}
+	return p.run(ctx, p.Config.KnowledgeBase, inputFacts)
+}
 
-	state := &PipelineState{}
-	kb := p.Config.KnowledgeBase
-
-	// Step 1: Domain detection
-	state.Signals = append(state.Signals, "Pipeline started with "+fmt.Sprintf("%d", len(inputFacts))+" input facts")
-	if p.Config.DomainDetector != nil {
-		state.Domain = p.Config.DomainDetector.Detect(inputFacts)
-	} else {
-		state.Domain = DomainGeneral
+// NewSession starts a session over the rules of Config.KnowledgeBase, which
+// are compiled the first time a session is requested.
+func (p *Pipeline) NewSession() (*Session, error) {
+	if p.Config.KnowledgeBase == nil {
+		return nil, fmt.Errorf("knowledge base is required")
 	}
-	state.Signals = append(state.Signals, "Detected domain: "+string(state.Domain))
+	p.rulesOnce.Do(func() {
+		p.rules = NewRuleSet(p.Config.KnowledgeBase)
+	})
+	return p.rules.NewSession(), nil
+}
 
-	// Select scoring weights based on domain
-	weights := SolutionScore{BusinessImpact: 0.25, ImplementationComplexity: 0.25, RiskLevel: 0.25, TimeToValue: 0.25}
-	if p.Config.ScoringWeights != nil {
-		weights = *p.Config.ScoringWeights
-	} else if p.Config.DomainWeights != nil {
-		if dw, ok := p.Config.DomainWeights[state.Domain]; ok {
-			weights = dw
-		}
-	}
+// RunSession executes the full 6-step pipeline on input facts using the
+// working memory of the session.
+func (p *Pipeline) RunSession(session *Session, inputFacts map[string]Fact) (*PipelineResult, error) {
+	return p.RunSessionContext(context.Background(), session, inputFacts)
+}
 
-	// Step 2: Intent classification
-	if p.Config.IntentClassifier != nil {
-		intent, err := p.Config.IntentClassifier.Classify(inputFacts)
-		if err != nil {
-			return nil, fmt.Errorf("intent classification failed: %w", err)
+// RunSessionContext is RunSession stopping like RunContext.
+func (p *Pipeline) RunSessionContext(ctx context.Context, session *Session, inputFacts map[string]Fact) (*PipelineResult, error) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return p.run(ctx, session.kb, inputFacts)
+}
+
+func (p *Pipeline) run(ctx context.Context, kb *KnowledgeBase, inputFacts map[string]Fact) (*PipelineResult, error) {
+	if err := p.Config.validate(kb, inputFacts); err != nil {
+		return nil, err
+	}
+	stages := p.Stages
+	if stages == nil {
+		var err error
+		if stages, err = p.Config.BuildStages(); err != nil {
+			return nil, err
 		}
-		state.Intent = intent
-	} else {
-		state.Intent = Intent{Type: IntentQuery, Description: "default"}
 	}
-	state.Signals = append(state.Signals, "Classified intent: "+string(state.Intent.Type))
-
-	// Step 3: Entity extraction — extract entities and add as facts to KB
-	if p.Config.EntityExtractor != nil {
-		entities, err := p.Config.EntityExtractor.Extract(inputFacts)
-		if err != nil {
-			return nil, fmt.Errorf("entity extraction failed: %w", err)
+	state := &PipelineState{
+		Domain:        DomainGeneral,
+		Intent:        Intent{Type: IntentQuery, Description: "default"},
+		Input:         inputFacts,
+		KnowledgeBase: kb,
+	}
+	state.Signals = append(state.Signals, "Pipeline started with "+fmt.Sprintf("%d", len(inputFacts))+" input facts")
+	budget := p.Config.Budget
+	if budget == nil {
+		budget = kb.Budget
+	}
+	leave := kb.enter(ctx, budget)
+	defer leave()
+	if p.Config.Strict {
+		kb.guard.strict = true
+	}
+	for _, stage := range stages {
+		reported := len(kb.diagnostics)
+		err := kb.spend(0)
+		if err == nil {
+			err = p.runStage(ctx, stage, state)
 		}
-		state.Entities = entities
-		for _, entity := range entities {
-			kb.AddFact(Fact{
-				ID:     entity.FactID,
-				Value:  entity.Value,
-				Source: "extracted",
-			})
+		for i := reported; i < len(kb.diagnostics); i++ {
+			d := &kb.diagnostics[i]
+			d.Stage = stage.Name()
+			if strings.HasPrefix(d.Path, "inferences[") {
+				d.Path = "knowledge_base." + d.Path
+			}
 		}
-		if len(entities) > 0 {
-			state.Signals = append(state.Signals, fmt.Sprintf("Extracted %d entities", len(entities)))
+		if aborted(err) {
+			state.Signals = append(state.Signals, "Pipeline stopped at "+stage.Name()+": "+err.Error())
+			return p.buildResult(state, kb, p.Config.weights(state.Domain)), err
 		}
-	}
-
-	// Add input facts to KB
-	for _, fact := range inputFacts {
-		if fact.Source == "" {
-			fact.Source = "input"
+		if err != nil {
+			return nil, err
 		}
-		kb.AddFact(fact)
 	}
 
-	// Step 4: Constraint identification
-	if p.Config.ConstraintSet != nil {
-		constraints, err := p.Config.ConstraintSet.Identify(kb.Facts)
-		if err != nil {
-			return nil, fmt.Errorf("constraint identification failed: %w", err)
-		}
-		state.Constraints = constraints
+	// Build structured output
+	return p.buildResult(state, kb, p.Config.weights(state.Domain)), nil
+}
 
-		// Check constraint satisfaction
-		for _, c := range constraints {
-			satisfied, err := c.Satisfied(kb.Facts)
-			if err != nil {
-				continue
-			}
-			if !satisfied && c.Type == ConstraintHard {
-				state.Tradeoffs = append(state.Tradeoffs, "Hard constraint not met: "+c.Description)
-			} else if !satisfied && c.Type == ConstraintSoft {
-				state.Assumptions = append(state.Assumptions, "Soft constraint relaxed: "+c.Description)
-			}
-		}
+// runStage runs the stage, telling the observers when it starts and
+// finishes.
+func (p *Pipeline) runStage(ctx context.Context, stage Stage, state *PipelineState) error {
+	kb := state.KnowledgeBase
+	kb.guard.stage = stage.Name()
+	defer func() { kb.guard.stage = "" }()
+	kb.emit(Event{Type: EventStageStarted})
+	start := time.Now()
+	err := stage.Run(ctx, state)
+	finished := Event{Type: EventStageFinished, Duration: Duration(time.Since(start))}
+	if err != nil {
+		finished.Error = err.Error()
 	}
+	kb.emit(finished)
+	return err
+}
 
-	// Step 5: Knowledge application — inference + contradiction resolution
-	kb.Infer()
-	kb.ResolveContradictions()
-	state.Signals = append(state.Signals, fmt.Sprintf("Knowledge base has %d facts after inference", len(kb.Facts)))
-
-	// Step 6: Risk analysis
-	if p.Config.RiskAnalyzer != nil {
-		risks, err := p.Config.RiskAnalyzer.Analyze(kb)
-		if err != nil {
-			return nil, fmt.Errorf("risk analysis failed: %w", err)
-		}
-		state.Risks = risks
-		for _, r := range risks {
-			if r.Level == RiskHigh {
-				state.Tradeoffs = append(state.Tradeoffs, "High risk: "+r.Description)
-			}
+// weights returns the scoring weights of the domain.
+func (c *PipelineConfig) weights(domain Domain) SolutionScore {
+	weights := SolutionScore{BusinessImpact: 0.25, ImplementationComplexity: 0.25, RiskLevel: 0.25, TimeToValue: 0.25}
+	if c.ScoringWeights != nil {
+		weights = *c.ScoringWeights
+	} else if c.DomainWeights != nil {
+		if dw, ok := c.DomainWeights[domain]; ok {
+			weights = dw
 		}
 	}
-
-	// Build structured output
-	return p.buildResult(state, kb, weights), nil
+	return weights
 }
 
 func (p *Pipeline) buildResult(state *PipelineState, kb *KnowledgeBase, weights SolutionScore) *PipelineResult {
@@ -205,25 +252,40 @@ func (p *Pipeline) buildResult(state *PipelineState, kb *KnowledgeBase, weights
 		maxCertainty = 0.3
 	}
 
-	// Missing data
-	missingData := kb.GetMissingFactIDs()
-	pending := kb.GetPendingInference()
+	// Missing data: prove backwards the conclusions that do not hold yet
+	var missingData []string
 	var nextActions []string
-	for _, inf := range pending {
-		rules := inf.PendingRules(kb.Facts)
-		for _, r := range rules {
-			if r.Question != "" {
-				nextActions = append(nextActions, r.Question)
+	asked := make(map[string]bool)
+	for _, c := range kb.GetFalseConclusions() {
+		for _, f := range c.Facts {
+			proof := kb.ProveValue(f.ID, f.Value)
+			for _, m := range proof.Missing {
+				if asked[m.FactID] {
+					continue
+				}
+				asked[m.FactID] = true
+				missingData = append(missingData, m.FactID)
+				if m.Question != "" {
+					nextActions = append(nextActions, m.Question)
+				}
 			}
 		}
 	}
 
+	// Explanations: why every conclusion holds or not
+	explanations := kb.ExplainConclusions()
+	var explanation strings.Builder
+	for _, e := range explanations {
+		explanation.WriteString(e.String())
+	}
+
 	return &PipelineResult{
 		Result: result,
 		Reasoning: Reasoning{
 			Signals:     state.Signals,
 			Assumptions: state.Assumptions,
 			Tradeoffs:   state.Tradeoffs,
+			Explanation: explanation.String(),
 		},
 		Confidence:  ComputeConfidence(maxCertainty),
 		FollowUp: FollowUp{
@@ -236,5 +298,7 @@ func (p *Pipeline) buildResult(state *PipelineState, kb *KnowledgeBase, weights
 		Constraints: state.Constraints,
 		Risks:       state.Risks,
 		Solutions:   solutions,
+		Explanations: explanations,
+		Diagnostics:  kb.Diagnostics(),
 	}
 }
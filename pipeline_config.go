@@ -2,10 +2,13 @@ package inference
 
 import (
 	"encoding/json"
+	"fmt"
+	"maps"
 	"os"
 )
 
-// LoadPipelineConfig loads a PipelineConfig from a JSON file.
+// LoadPipelineConfig loads a PipelineConfig from a JSON file. It fails when
+// Validate reports errors; warnings are left to the caller.
 func LoadPipelineConfig(filename string) (*PipelineConfig, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
@@ -15,6 +18,9 @@ func LoadPipelineConfig(filename string) (*PipelineConfig, error) {
 	if err := json.Unmarshal(data, &config); err != nil {
 		return nil, err
 	}
+	if err := config.TypeCheck(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
 	return &config, nil
 }
 
@@ -26,3 +32,51 @@ func SavePipelineConfig(config *PipelineConfig, filename string) error {
 	}
 	return os.WriteFile(filename, data, 0644)
 }
+
+// schema returns the facts declared by the knowledge base, overridden by the
+// ones declared by the config.
+func (c *PipelineConfig) schema() Schema {
+	var kbSchema Schema
+	if c.KnowledgeBase != nil {
+		kbSchema = c.KnowledgeBase.Schema
+	}
+	if len(c.Schema) == 0 {
+		return kbSchema
+	}
+	schema := maps.Clone(kbSchema)
+	if schema == nil {
+		schema = make(Schema, len(c.Schema))
+	}
+	maps.Copy(schema, c.Schema)
+	return schema
+}
+
+// TypeCheck compiles every expression of the pipeline against the schema.
+// It returns the errors reported by Validate joined in a single error.
+func (c *PipelineConfig) TypeCheck() error {
+	return c.Validate().Err()
+}
+
+// validate checks the input facts against the schema and that the required
+// facts are either given or already known.
+func (c *PipelineConfig) validate(kb *KnowledgeBase, inputFacts map[string]Fact) error {
+	schema := c.schema()
+	if len(schema) == 0 {
+		return nil
+	}
+	var errs ValidationErrors
+	for _, id := range sortedKeys(inputFacts) {
+		if err := schema.ValidateFact(inputFacts[id]); err != nil {
+			errs = append(errs, err.(ValidationErrors)...)
+		}
+	}
+	errs = append(errs, schema.missing(func(id string) bool {
+		_, input := inputFacts[id]
+		_, known := kb.Facts[id]
+		return input || known
+	})...)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -116,3 +116,24 @@ func TestPipeline_MinimalConfig(t *testing.T) {
 		t.Errorf("Expected low confidence with no conclusions, got %s", result.Confidence)
 	}
 }
+
+func TestPipeline_FollowUpFromProof(t *testing.T) {
+	kb := triageKnowledgeBase()
+	kb.Conclusions = []Conclusion{
+		{Description: "Critical patient", Facts: []Fact{{ID: "triage_level", Value: "red"}}},
+	}
+	pipeline := NewPipeline(PipelineConfig{KnowledgeBase: kb})
+
+	result, err := pipeline.Run(map[string]Fact{
+		"temperature": {ID: "temperature", Value: 39},
+	})
+	if err != nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+	if len(result.FollowUp.MissingData) != 1 || result.FollowUp.MissingData[0] != "systolic_bp" {
+		t.Errorf("Expected systolic_bp to be missing, got %v", result.FollowUp.MissingData)
+	}
+	if len(result.FollowUp.NextActions) != 1 {
+		t.Errorf("Expected one question, got %v", result.FollowUp.NextActions)
+	}
+}
@@ -0,0 +1,198 @@
+package inference
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+)
+
+// Proof is the outcome of proving a goal fact by backward chaining.
+type Proof struct {
+	Goal   string      `json:"goal"`
+	Proved bool        `json:"proved"`
+	Value  interface{} `json:"value,omitempty"`
+	// Missing holds the smallest set of base facts that must be provided to
+	// prove the goal. It is empty when the goal was proved or when it cannot
+	// be proved whatever facts are added.
+	Missing []MissingFact `json:"missing,omitempty"`
+}
+
+// MissingFact is a base fact needed to prove a goal, with the question of
+// the rule that needs it.
+type MissingFact struct {
+	FactID   string `json:"fact_id"`
+	Question string `json:"question,omitempty"`
+}
+
+// Prove tries to derive the goal fact by walking back from the inferences
+// that produce it through the facts their expressions reference. The
+// knowledge base is not modified.
+func (kb *KnowledgeBase) Prove(goal string) Proof {
+	p := kb.newProver()
+	fact, missing, ok := p.prove(goal)
+	if ok {
+		return Proof{Goal: goal, Proved: true, Value: fact.Value}
+	}
+	return Proof{Goal: goal, Missing: missing}
+}
+
+// ProveValue tries to derive the goal fact with the given value. Unlike
+// Prove, a goal that currently holds another value is not considered proved:
+// the overwrite inferences that could still produce the value are walked.
+func (kb *KnowledgeBase) ProveValue(goal string, value interface{}) Proof {
+	if fact, ok := kb.Facts[goal]; ok && reflect.DeepEqual(fact.Value, value) {
+		return Proof{Goal: goal, Proved: true, Value: fact.Value}
+	}
+	p := kb.newProver()
+	fact, missing, ok := p.produce(goal, func(inf *Inference) bool {
+		if _, known := kb.Facts[goal]; known && !inf.OverWrite {
+			return false
+		}
+		return inf.IsValeCalculated || reflect.DeepEqual(inf.FactValue, value)
+	})
+	if ok && reflect.DeepEqual(fact.Value, value) {
+		return Proof{Goal: goal, Proved: true, Value: fact.Value}
+	}
+	return Proof{Goal: goal, Missing: missing}
+}
+
+type prover struct {
+	kb *KnowledgeBase
+	// network indexes the inferences of the knowledge base, sorted by Order
+	network *matchNetwork
+	// facts holds the known facts plus the ones proved so far
+	facts map[string]Fact
+	// proving holds the goals being proved, to break cycles
+	proving map[string]bool
+}
+
+func (kb *KnowledgeBase) newProver() *prover {
+	return &prover{kb: kb, network: kb.readNetwork(), facts: maps.Clone(kb.Facts), proving: make(map[string]bool)}
+}
+
+// prove returns the goal when it is known or can be derived, otherwise the
+// missing base facts; a base fact that is not known is missing itself
+func (p *prover) prove(goal string) (Fact, []MissingFact, bool) {
+	if fact, ok := p.facts[goal]; ok {
+		return fact, nil, true
+	}
+	if len(p.network.producers[goal]) == 0 {
+		return Fact{}, []MissingFact{{FactID: goal}}, false
+	}
+	return p.produce(goal, func(*Inference) bool { return true })
+}
+
+// produce walks the inferences producing the goal that pass the filter. The
+// value follows forward chaining: the first inference in Order unless a
+// later overwrite inference also holds. When none holds, the missing facts
+// of the alternative closest to hold are returned.
+func (p *prover) produce(goal string, filter func(*Inference) bool) (Fact, []MissingFact, bool) {
+	if p.proving[goal] {
+		return Fact{}, nil, false
+	}
+	p.proving[goal] = true
+	defer delete(p.proving, goal)
+
+	var proved *Fact
+	var best []MissingFact
+	for _, i := range p.network.producers[goal] {
+		inf := p.network.inferences[i]
+		if !filter(&inf) {
+			continue
+		}
+		missing, refuted := p.satisfy(&inf)
+		if refuted {
+			continue
+		}
+		if len(missing) > 0 {
+			if best == nil || len(missing) < len(best) {
+				best = missing
+			}
+			continue
+		}
+		result, err := inf.infer(p.kb.expressions(), p.facts)
+		if err != nil {
+			continue
+		}
+		if proved == nil || inf.OverWrite {
+			proved = &Fact{ID: result.id, Value: result.value, DerivedFrom: result.premises, Certainty: recorded(result.certainty)}
+		}
+	}
+	if proved != nil {
+		p.facts[goal] = *proved
+		return *proved, nil, true
+	}
+	return Fact{}, best, false
+}
+
+// satisfy proves the facts referenced by the rules and calculated value of
+// an inference. It reports the missing base facts, or refuted when a rule is
+// false or one of its facts cannot be proved.
+func (p *prover) satisfy(inf *Inference) ([]MissingFact, bool) {
+	var missing []MissingFact
+	for _, rule := range inf.Rules {
+		absent, refuted := p.require(rule.Expression, rule.Rule)
+		if refuted {
+			return nil, true
+		}
+		if len(absent) > 0 {
+			missing = append(missing, absent...)
+			continue
+		}
+		result, _, err := rule.evaluateIn(p.kb.expressions(), p.facts)
+		if err != nil || !result {
+			return nil, true
+		}
+	}
+	if sValue, ok := inf.FactValue.(string); ok && inf.IsValeCalculated {
+		absent, refuted := p.require(sValue, Rule{})
+		if refuted {
+			return nil, true
+		}
+		missing = append(missing, absent...)
+	}
+	return uniqueMissing(missing), false
+}
+
+// require proves every fact an expression needs, filling in the question
+// of the rule for the base facts it targets. Local variables are not facts
+// and facts tested by unknown are not needed.
+func (p *prover) require(expression string, rule Rule) ([]MissingFact, bool) {
+	visitor, err := visitExpression(expression)
+	if err != nil {
+		return nil, true
+	}
+	read := visitor.facts()
+	ids := slices.DeleteFunc(unique(append(read, visitor.tested...)), func(id string) bool {
+		return slices.Contains(visitor.absent, id) && !slices.Contains(read, id)
+	})
+	var missing []MissingFact
+	for _, id := range ids {
+		_, absent, ok := p.prove(id)
+		if ok {
+			continue
+		}
+		if len(absent) == 0 {
+			return nil, true
+		}
+		for _, m := range absent {
+			if m.Question == "" && m.FactID == id && (rule.FactTargetID == "" || rule.FactTargetID == id) {
+				m.Question = rule.Question
+			}
+			missing = append(missing, m)
+		}
+	}
+	return missing, false
+}
+
+func uniqueMissing(missing []MissingFact) []MissingFact {
+	seen := make(map[string]bool)
+	var list []MissingFact
+	for _, m := range missing {
+		if !seen[m.FactID] {
+			seen[m.FactID] = true
+			list = append(list, m)
+		}
+	}
+	return list
+}
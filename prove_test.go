@@ -0,0 +1,121 @@
+package inference
+
+import "testing"
+
+func triageKnowledgeBase() *KnowledgeBase {
+	kb := &KnowledgeBase{
+		Inferences: []Inference{
+			{
+				Description: "Detect fever",
+				Rules: []WeightedRule{{Rule: Rule{
+					FactTargetID: "temperature",
+					Question:     "What is the patient's temperature?",
+					Expression:   "temperature > 38",
+				}, Weight: 1}},
+				FactID:    "fever",
+				FactValue: true,
+			},
+			{
+				Description: "Detect hypotension",
+				Rules: []WeightedRule{{Rule: Rule{
+					FactTargetID: "systolic_bp",
+					Question:     "What is the patient's systolic blood pressure?",
+					Expression:   "systolic_bp < 90",
+				}, Weight: 1}},
+				FactID:    "hypotension",
+				FactValue: true,
+			},
+			{
+				Description: "Red triage",
+				Rules: []WeightedRule{
+					{Rule: Rule{FactTargetID: "fever", Expression: "fever == true"}, Weight: 0.5},
+					{Rule: Rule{FactTargetID: "hypotension", Expression: "hypotension == true"}, Weight: 0.5},
+				},
+				FactID:    "triage_level",
+				FactValue: "red",
+				OverWrite: true,
+				Order:     1,
+			},
+			{
+				Description: "Green triage",
+				Rules: []WeightedRule{{Rule: Rule{
+					FactTargetID: "temperature",
+					Question:     "What is the patient's temperature?",
+					Expression:   "temperature <= 38",
+				}, Weight: 1}},
+				FactID:    "triage_level",
+				FactValue: "green",
+			},
+		},
+	}
+	kb.Start()
+	return kb
+}
+
+func TestKnowledgeBase_ProveMissing(t *testing.T) {
+	kb := triageKnowledgeBase()
+
+	proof := kb.Prove("triage_level")
+	if proof.Proved {
+		t.Fatalf("Expected triage level not to be proved")
+	}
+	if len(proof.Missing) != 1 || proof.Missing[0].FactID != "temperature" {
+		t.Fatalf("Expected only temperature to be missing, got %v", proof.Missing)
+	}
+	if proof.Missing[0].Question != "What is the patient's temperature?" {
+		t.Errorf("Expected the rule question, got %q", proof.Missing[0].Question)
+	}
+}
+
+func TestKnowledgeBase_ProveValue(t *testing.T) {
+	kb := triageKnowledgeBase()
+	kb.AddFact(Fact{ID: "temperature", Value: 39})
+
+	proof := kb.ProveValue("triage_level", "red")
+	if proof.Proved {
+		t.Fatalf("Expected red triage not to be proved")
+	}
+	if len(proof.Missing) != 1 || proof.Missing[0].FactID != "systolic_bp" {
+		t.Fatalf("Expected only systolic_bp to be missing, got %v", proof.Missing)
+	}
+
+	kb.AddFact(Fact{ID: "systolic_bp", Value: 80})
+	proof = kb.Prove("triage_level")
+	if !proof.Proved || proof.Value != "red" {
+		t.Errorf("Expected red triage to be proved, got %+v", proof)
+	}
+}
+
+func TestKnowledgeBase_ProveRefuted(t *testing.T) {
+	kb := triageKnowledgeBase()
+	kb.AddFact(Fact{ID: "temperature", Value: 37})
+
+	proof := kb.ProveValue("triage_level", "red")
+	if proof.Proved || len(proof.Missing) != 0 {
+		t.Errorf("Expected red triage to be refuted, got %+v", proof)
+	}
+	if _, ok := kb.Facts["fever"]; ok {
+		t.Errorf("Expected Prove not to modify the facts")
+	}
+}
+
+func TestKnowledgeBase_ProveNeedsOnlyFacts(t *testing.T) {
+	kb := &KnowledgeBase{
+		Inferences: []Inference{
+			{ID: "several", Rules: rule("let n = len(items); n > 1"), FactID: "bulk", FactValue: true},
+			{ID: "safe", Rules: rule("infection && unknown(allergy)"), FactID: "penicillin", FactValue: true},
+			{ID: "first", Order: -1, Rules: rule("bulk"), FactID: "discount", FactValue: true},
+		},
+	}
+	proof := kb.Prove("bulk")
+	if len(proof.Missing) != 1 || proof.Missing[0].FactID != "items" {
+		t.Errorf("Expected only items to be missing, got %v", proof.Missing)
+	}
+	proof = kb.Prove("penicillin")
+	if len(proof.Missing) != 1 || proof.Missing[0].FactID != "infection" {
+		t.Errorf("Expected only infection to be missing, got %v", proof.Missing)
+	}
+	if kb.network != nil || kb.Inferences[0].ID != "several" {
+		t.Errorf("Expected the knowledge base not to be modified")
+	}
+}
@@ -0,0 +1,173 @@
+package inference
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"time"
+)
+
+// Recording captures a pipeline run so it can be replayed: the config it ran
+// with, what went in and what came out. It is meant to be kept as JSON.
+type Recording struct {
+	// ConfigHash identifies the config, see PipelineConfig.Hash
+	ConfigHash string          `json:"config_hash"`
+	Input      map[string]Fact `json:"input"`
+	// Clock is the time of the run, which stands still while it runs
+	Clock  time.Time       `json:"clock"`
+	Result *PipelineResult `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Divergence is a difference between a recorded and a replayed run, at the
+// JSON path of the result where they differ, like reasoning.signals[2].
+type Divergence struct {
+	Path     string      `json:"path"`
+	Recorded interface{} `json:"recorded"`
+	Replayed interface{} `json:"replayed"`
+}
+
+func (d Divergence) String() string {
+	return fmt.Sprintf("%s: recorded %v, replayed %v", d.Path, d.Recorded, d.Replayed)
+}
+
+// Hash returns the SHA-256 of the config as JSON, the knowledge base facts
+// included, leaving out how many times the knowledge base was started and
+// when its facts were observed, which the clock decides.
+func (c *PipelineConfig) Hash() (string, error) {
+	config := *c
+	if c.KnowledgeBase != nil {
+		kb := *c.KnowledgeBase
+		kb.RunningCount = 0
+		kb.Facts = make(map[string]Fact, len(c.KnowledgeBase.Facts))
+		for id, fact := range c.KnowledgeBase.Facts {
+			fact.ObservedAt = time.Time{}
+			fact.History = nil
+			kb.Facts[id] = fact
+		}
+		config.KnowledgeBase = &kb
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Record runs the pipeline on the input facts like RunContext, but on a new
+// session of Config.KnowledgeBase and with its clock standing still, and
+// returns the recording of the run together with the error of the run.
+func (p *Pipeline) Record(ctx context.Context, inputFacts map[string]Fact) (*Recording, error) {
+	if p.Config.KnowledgeBase == nil {
+		return nil, fmt.Errorf("knowledge base is required")
+	}
+	hash, err := p.Config.Hash()
+	if err != nil {
+		return nil, err
+	}
+	recording := &Recording{
+		ConfigHash: hash,
+		Input:      maps.Clone(inputFacts),
+		Clock:      p.Config.KnowledgeBase.now().Round(0),
+	}
+	recording.Result, err = p.runAt(ctx, recording.Clock, inputFacts)
+	if err != nil {
+		recording.Error = err.Error()
+	}
+	return recording, err
+}
+
+// Replay runs a recording again, at its clock, and returns the result with
+// where it departs from the recorded one. A config that changed since the
+// recording diverges at config_hash and a different outcome at error.
+func (p *Pipeline) Replay(ctx context.Context, recording *Recording) (*PipelineResult, []Divergence, error) {
+	if p.Config.KnowledgeBase == nil {
+		return nil, nil, fmt.Errorf("knowledge base is required")
+	}
+	hash, err := p.Config.Hash()
+	if err != nil {
+		return nil, nil, err
+	}
+	var divergences []Divergence
+	if hash != recording.ConfigHash {
+		divergences = append(divergences, Divergence{Path: "config_hash", Recorded: recording.ConfigHash, Replayed: hash})
+	}
+	result, runErr := p.runAt(ctx, recording.Clock, recording.Input)
+	replayedErr := ""
+	if runErr != nil {
+		replayedErr = runErr.Error()
+	}
+	if replayedErr != recording.Error {
+		divergences = append(divergences, Divergence{Path: "error", Recorded: recording.Error, Replayed: replayedErr})
+	}
+	recorded, err := asJSON(recording.Result)
+	if err != nil {
+		return result, divergences, err
+	}
+	replayed, err := asJSON(result)
+	if err != nil {
+		return result, divergences, err
+	}
+	return result, diverge("", recorded, replayed, divergences), nil
+}
+
+// runAt runs the pipeline on a new session of Config.KnowledgeBase whose
+// clock stands still at now.
+func (p *Pipeline) runAt(ctx context.Context, now time.Time, inputFacts map[string]Fact) (*PipelineResult, error) {
+	definitions := *p.Config.KnowledgeBase
+	definitions.Clock = func() time.Time { return now }
+	session := NewRuleSet(&definitions).NewSession()
+	return p.run(ctx, session.kb, inputFacts)
+}
+
+// asJSON returns the value as decoded from its JSON, so values recorded and
+// replayed compare the same.
+func asJSON(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	err = json.Unmarshal(data, &decoded)
+	return decoded, err
+}
+
+// diverge appends the differences between the decoded JSON values at path,
+// descending into the objects and the arrays of the same length.
+func diverge(path string, recorded, replayed interface{}, divergences []Divergence) []Divergence {
+	switch r := recorded.(type) {
+	case map[string]interface{}:
+		if p, ok := replayed.(map[string]interface{}); ok {
+			keys := unique(append(sortedKeys(r), sortedKeys(p)...))
+			slices.Sort(keys)
+			for _, key := range keys {
+				child := key
+				if path != "" {
+					child = path + "." + key
+				}
+				divergences = diverge(child, r[key], p[key], divergences)
+			}
+			return divergences
+		}
+	case []interface{}:
+		if p, ok := replayed.([]interface{}); ok && len(r) == len(p) {
+			for i := range r {
+				divergences = diverge(fmt.Sprintf("%s[%d]", path, i), r[i], p[i], divergences)
+			}
+			return divergences
+		}
+	}
+	if !reflect.DeepEqual(recorded, replayed) {
+		if path == "" {
+			path = "result"
+		}
+		divergences = append(divergences, Divergence{Path: path, Recorded: recorded, Replayed: replayed})
+	}
+	return divergences
+}
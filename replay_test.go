@@ -0,0 +1,99 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func replayConfig(clock *fakeClock) PipelineConfig {
+	kb := &KnowledgeBase{
+		Clock: clock.Now,
+		Inferences: []Inference{
+			{ID: "late", Rules: rule(`age(order) > duration("48h")`), FactID: "late", FactValue: true},
+			{ID: "refund", Rules: rule("late && total > 100"), FactID: "refund", FactValue: true},
+		},
+		Conclusions: []Conclusion{{Description: "Refund the order", Facts: []Fact{{ID: "refund", Value: true}}}},
+	}
+	kb.Start()
+	return PipelineConfig{
+		KnowledgeBase:  kb,
+		DomainDetector: &DomainDetector{Signals: map[Domain][]string{DomainFinance: {"total"}, DomainEcommerce: {"order"}}},
+	}
+}
+
+func TestPipeline_RecordReplay(t *testing.T) {
+	clock := newFakeClock()
+	input := map[string]Fact{
+		"order": {ID: "order", Value: "A-1", ObservedAt: clock.now.Add(-72 * time.Hour)},
+		"total": {ID: "total", Value: 150},
+	}
+	recording, err := NewPipeline(replayConfig(clock)).Record(context.Background(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recording.Result.Result != "Refund the order" || !recording.Clock.Equal(clock.now) {
+		t.Fatalf("Expected the refund at the clock of the run, got %q at %v", recording.Result.Result, recording.Clock)
+	}
+	data, err := json.Marshal(recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded Recording
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	// the replay runs at the recorded clock, not at the current time
+	clock.advance(-48 * time.Hour)
+	_, divergences, err := NewPipeline(replayConfig(clock)).Replay(context.Background(), &loaded)
+	if err != nil || len(divergences) > 0 {
+		t.Fatalf("Expected the replay to match the recording, got %v, %v", divergences, err)
+	}
+
+	changed := replayConfig(clock)
+	changed.KnowledgeBase.Inferences[1].Rules = rule("late && total > 200")
+	_, divergences, err = NewPipeline(changed).Replay(context.Background(), &loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := map[string]bool{}
+	for _, d := range divergences {
+		paths[d.Path] = true
+	}
+	if !paths["config_hash"] || !paths["result"] {
+		t.Errorf("Expected the changed config and result reported, got %v", divergences)
+	}
+}
+
+func TestPipelineConfig_HashObservedFacts(t *testing.T) {
+	hash := func(clock *fakeClock) string {
+		config := replayConfig(clock)
+		config.KnowledgeBase.AddFact(Fact{ID: "total", Value: 150})
+		h, err := config.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	clock := newFakeClock()
+	before := hash(clock)
+	clock.advance(time.Hour)
+	if after := hash(clock); after != before {
+		t.Errorf("Expected the hash not to depend on when the facts were observed")
+	}
+}
+
+func TestDomainDetector_Ties(t *testing.T) {
+	detector := &DomainDetector{Signals: map[Domain][]string{
+		DomainFinance:   {"pay"},
+		DomainEcommerce: {"pay"},
+		DomainData:      {"pay"},
+	}}
+	for i := 0; i < 20; i++ {
+		if domain := detector.Detect(map[string]Fact{"payment": {ID: "payment"}}); domain != DomainData {
+			t.Fatalf("Expected ties to go to the first domain in order, got %s", domain)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package inference
 
 import (
-	"github.com/expr-lang/expr"
+	"context"
+	"fmt"
+	"strings"
 )
 
 // RiskLevel represents the severity of a risk.
@@ -21,28 +23,43 @@ type Risk struct {
 	Mitigation  string    `json:"mitigation"`
 }
 
-// RiskAnalyzer evaluates risk expressions and checks for contradictions and low-certainty conclusions.
+// DefaultConflictThreshold is the conflict between sources above which
+// RiskAnalyzer reports a fact when ConflictThreshold is not set.
+const DefaultConflictThreshold = 0.3
+
+// RiskAnalyzer evaluates risk expressions and checks for contradictions, low-certainty conclusions
+// and facts whose sources conflict.
 type RiskAnalyzer struct {
 	Risks []Risk `json:"risks"`
+	// ConflictThreshold is the conflict between the sources of a fact
+	// above which it is a risk, DefaultConflictThreshold when zero
+	ConflictThreshold float64 `json:"conflict_threshold,omitempty"`
 }
 
 // Analyze evaluates risk expressions against the KB state and returns triggered risks.
+// Expressions that cannot be evaluated are reported in the Diagnostics of kb,
+// and fail the analysis when kb is Strict.
 func (ra *RiskAnalyzer) Analyze(kb *KnowledgeBase) ([]Risk, error) {
-	var triggered []Risk
+	return ra.AnalyzeContext(context.Background(), kb)
+}
 
-	env := make(map[string]interface{})
-	for k, v := range kb.Facts {
-		env[k] = v.Value
-	}
+// AnalyzeContext is Analyze stopping between the risk expressions when ctx
+// is done or the budget of kb runs out, which count as evaluations.
+func (ra *RiskAnalyzer) AnalyzeContext(ctx context.Context, kb *KnowledgeBase) ([]Risk, error) {
+	leave := kb.enter(ctx, kb.Budget)
+	defer leave()
+	var triggered []Risk
 
 	// Evaluate explicit risk rules
-	for _, risk := range ra.Risks {
-		program, err := expr.Compile(risk.Expression, expr.Env(env))
-		if err != nil {
-			continue
+	for i, risk := range ra.Risks {
+		if err := kb.spend(1); err != nil {
+			return triggered, err
 		}
-		output, err := expr.Run(program, env)
+		output, _, err := kb.expressions().Evaluate(risk.Expression, kb.Facts)
 		if err != nil {
+			if err := kb.report(fmt.Sprintf("risk_analyzer.risks[%d].expression", i), risk.Description, err); err != nil {
+				return triggered, err
+			}
 			continue
 		}
 		result, ok := output.(bool)
@@ -65,7 +82,7 @@ func (ra *RiskAnalyzer) Analyze(kb *KnowledgeBase) ([]Risk, error) {
 
 	// Check for low-certainty conclusions as medium-risk signals
 	for _, conclusion := range kb.Conclusions {
-		certainty := conclusion.Certainty(kb.Facts)
+		certainty := kb.CertaintyForConclusion(conclusion)
 		if certainty > 0 && certainty < 0.5 {
 			triggered = append(triggered, Risk{
 				Description: "Low certainty conclusion: " + conclusion.Description,
@@ -75,5 +92,27 @@ func (ra *RiskAnalyzer) Analyze(kb *KnowledgeBase) ([]Risk, error) {
 		}
 	}
 
+	// Check for facts whose sources disagree: medium-risk, high-risk when
+	// they contradict each other completely
+	threshold := ra.ConflictThreshold
+	if threshold <= 0 {
+		threshold = DefaultConflictThreshold
+	}
+	for _, id := range sortedKeys(kb.Facts) {
+		evidence := kb.Facts[id].Evidence
+		if evidence == nil || evidence.Conflict <= threshold {
+			continue
+		}
+		level := RiskMedium
+		if evidence.Conflict >= 1 {
+			level = RiskHigh
+		}
+		triggered = append(triggered, Risk{
+			Description: fmt.Sprintf("Conflicting evidence for %s from %s (conflict %.2f)", id, strings.Join(evidence.Sources, ", "), evidence.Conflict),
+			Level:       level,
+			Mitigation:  "Verify the sources",
+		})
+	}
+
 	return triggered, nil
 }
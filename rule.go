@@ -14,13 +14,17 @@ type Rule struct {
 }
 
 func (rule *Rule) evaluate(facts map[string]Fact) (bool, []string, error) {
-	output, derived, err := Calculate(rule.Expression, facts)
+	return rule.evaluateIn(defaultExpressions, facts)
+}
+
+func (rule *Rule) evaluateIn(exprs *ExpressionCache, facts map[string]Fact) (bool, []string, error) {
+	output, derived, err := exprs.Evaluate(rule.Expression, facts)
 	if err != nil {
 		return false, nil, err
 	}
 	result, ok := output.(bool)
 	if !ok {
-		return false, nil, fmt.Errorf("expression did not evaluate to a boolean")
+		return false, nil, &EvaluationError{Expression: rule.Expression, Err: fmt.Errorf("expression did not evaluate to a boolean")}
 	}
 	return result, derived, nil
 }
@@ -0,0 +1,324 @@
+package inference
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/expr-lang/expr/ast"
+)
+
+// FactType is the declared type of a fact value.
+type FactType string
+
+const (
+	FactNumber FactType = "number"
+	FactString FactType = "string"
+	FactBool   FactType = "bool"
+	FactObject FactType = "object"
+	FactList   FactType = "list"
+	FactAny    FactType = "any"
+)
+
+// FactSpec declares a fact: the type of its value and the values it accepts.
+type FactSpec struct {
+	Type        FactType `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Unit        string   `json:"unit,omitempty"`
+	// Min and Max bound number values
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+	// Enum lists the accepted values, any value is accepted when empty
+	Enum     []interface{} `json:"enum,omitempty"`
+	Required bool          `json:"required,omitempty"`
+	// Fields declares the fields of an object fact
+	Fields map[string]FactSpec `json:"fields,omitempty"`
+	// Terms makes a number fact a linguistic variable, see FuzzyInference
+	Terms map[string]Membership `json:"terms,omitempty"`
+}
+
+// Schema declares the facts of a knowledge base by ID. Input facts are
+// validated against it and expressions are type-checked against it instead
+// of against the values present when they are evaluated; facts that are not
+// declared can hold any value.
+type Schema map[string]FactSpec
+
+// Reasons of a ValidationError.
+const (
+	ReasonType     = "type"
+	ReasonRange    = "range"
+	ReasonEnum     = "enum"
+	ReasonRequired = "required"
+)
+
+// ValidationError describes a fact that does not match its declaration.
+type ValidationError struct {
+	FactID string `json:"fact_id"`
+	// Field is the path of the offending field inside an object fact
+	Field   string `json:"field,omitempty"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	return e.Message
+}
+
+// ValidationErrors collects the validation errors of one or more facts.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ValidateFact checks a fact against its declaration. It returns
+// ValidationErrors, or nil when the fact is valid or not declared.
+func (s Schema) ValidateFact(fact Fact) error {
+	spec, ok := s[fact.ID]
+	if !ok {
+		return nil
+	}
+	if errs := spec.check(fact.ID, "", fact.Value); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Validate checks the facts against their declarations and that every
+// required fact is present. It returns ValidationErrors or nil.
+func (s Schema) Validate(facts map[string]Fact) error {
+	var errs ValidationErrors
+	for _, id := range sortedKeys(facts) {
+		if spec, ok := s[id]; ok {
+			errs = append(errs, spec.check(id, "", facts[id].Value)...)
+		}
+	}
+	errs = append(errs, s.missing(func(id string) bool {
+		_, ok := facts[id]
+		return ok
+	})...)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// missing reports the required facts that are not known.
+func (s Schema) missing(known func(id string) bool) ValidationErrors {
+	var errs ValidationErrors
+	for _, id := range sortedKeys(s) {
+		if s[id].Required && !known(id) {
+			errs = append(errs, ValidationError{
+				FactID:  id,
+				Reason:  ReasonRequired,
+				Message: fmt.Sprintf("%s: required fact is missing", id),
+			})
+		}
+	}
+	return errs
+}
+
+func (spec FactSpec) check(id, field string, value interface{}) ValidationErrors {
+	name := id
+	if field != "" {
+		name = id + "." + field
+	}
+	fail := func(reason, format string, args ...interface{}) ValidationErrors {
+		return ValidationErrors{{
+			FactID:  id,
+			Field:   field,
+			Reason:  reason,
+			Message: name + ": " + fmt.Sprintf(format, args...),
+		}}
+	}
+
+	if !spec.Type.accepts(value) {
+		return fail(ReasonType, "expected %s, got %T", spec.Type, value)
+	}
+	if len(spec.Enum) > 0 && !slices.ContainsFunc(spec.Enum, func(v interface{}) bool {
+		return sameValue(v, value)
+	}) {
+		return fail(ReasonEnum, "%v is not one of %v", value, spec.Enum)
+	}
+	if number, ok := toFloat(value); ok {
+		if spec.Min != nil && number < *spec.Min {
+			return fail(ReasonRange, "%v%s is below the minimum %v%s", value, spec.unit(), *spec.Min, spec.unit())
+		}
+		if spec.Max != nil && number > *spec.Max {
+			return fail(ReasonRange, "%v%s is above the maximum %v%s", value, spec.unit(), *spec.Max, spec.unit())
+		}
+	}
+
+	var errs ValidationErrors
+	for _, name := range sortedKeys(spec.Fields) {
+		fieldSpec := spec.Fields[name]
+		path := name
+		if field != "" {
+			path = field + "." + name
+		}
+		fieldValue, ok := fieldOf(value, name)
+		if !ok {
+			if fieldSpec.Required {
+				errs = append(errs, ValidationError{
+					FactID:  id,
+					Field:   path,
+					Reason:  ReasonRequired,
+					Message: fmt.Sprintf("%s.%s: required field is missing", id, path),
+				})
+			}
+			continue
+		}
+		errs = append(errs, fieldSpec.check(id, path, fieldValue)...)
+	}
+	return errs
+}
+
+func (spec FactSpec) unit() string {
+	if spec.Unit == "" {
+		return ""
+	}
+	return " " + spec.Unit
+}
+
+// accepts reports whether a value is of the type.
+func (t FactType) accepts(value interface{}) bool {
+	if t == "" || t == FactAny {
+		return true
+	}
+	if value == nil {
+		return false
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	switch t {
+	case FactNumber:
+		_, ok := toFloat(value)
+		return ok
+	case FactString:
+		return v.Kind() == reflect.String
+	case FactBool:
+		return v.Kind() == reflect.Bool
+	case FactObject:
+		return v.Kind() == reflect.Map || v.Kind() == reflect.Struct
+	case FactList:
+		return v.Kind() == reflect.Slice || v.Kind() == reflect.Array
+	}
+	return false
+}
+
+// toFloat converts any Go number to float64.
+func toFloat(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// sameValue compares two values, numbers by value whatever their Go type so
+// JSON numbers match Go integers.
+func sameValue(a, b interface{}) bool {
+	fa, aok := toFloat(a)
+	fb, bok := toFloat(b)
+	if aok && bok {
+		return fa == fb
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// fieldOf returns a field of a map or struct value.
+func fieldOf(value interface{}, name string) (interface{}, bool) {
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		field := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+		if !field.IsValid() {
+			return nil, false
+		}
+		return field.Interface(), true
+	case reflect.Struct:
+		field := v.FieldByName(name)
+		if !field.IsValid() || !field.CanInterface() {
+			return nil, false
+		}
+		return field.Interface(), true
+	}
+	return nil, false
+}
+
+// env returns a value of every declared type, used by expr to type-check.
+func (s Schema) env() map[string]interface{} {
+	env := make(map[string]interface{}, len(s))
+	for id, spec := range s {
+		switch spec.Type {
+		case FactNumber:
+			// left undefined, so untyped: a number holds an int or a float64
+		case FactString:
+			env[id] = ""
+		case FactBool:
+			env[id] = false
+		case FactObject:
+			env[id] = map[string]interface{}{}
+		case FactList:
+			env[id] = []interface{}{}
+		default:
+			env[id] = nil
+		}
+	}
+	return env
+}
+
+// fieldVisitor reports member accesses like sale.Product on declared object
+// facts that do not declare the field.
+type fieldVisitor struct {
+	schema Schema
+	errs   []error
+}
+
+func (v *fieldVisitor) Visit(node *ast.Node) {
+	member, ok := (*node).(*ast.MemberNode)
+	if !ok {
+		return
+	}
+	identifier, ok := member.Node.(*ast.IdentifierNode)
+	if !ok {
+		return
+	}
+	property, ok := member.Property.(*ast.StringNode)
+	if !ok {
+		return
+	}
+	spec, ok := v.schema[identifier.Value]
+	if !ok || spec.Type != FactObject || len(spec.Fields) == 0 {
+		return
+	}
+	if _, ok := spec.Fields[property.Value]; !ok {
+		v.errs = append(v.errs, fmt.Errorf("%s has no field %s", identifier.Value, property.Value))
+	}
+}
+
+// TypeCheck compiles the rule expressions and calculated values of the
+// inferences against the schema, so mistakes are found when the knowledge
+// base is loaded rather than when the rules are evaluated. It returns the
+// errors reported by Validate joined in a single error.
+func (kb *KnowledgeBase) TypeCheck() error {
+	return kb.Validate().Err()
+}
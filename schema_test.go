@@ -0,0 +1,139 @@
+package inference
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+var triageSchema = Schema{
+	"temperature": {Type: FactNumber, Unit: "°C", Min: float(25), Max: float(45), Required: true},
+	"level":       {Type: FactString, Enum: []interface{}{"red", "yellow", "green"}},
+	"sale": {Type: FactObject, Fields: map[string]FactSpec{
+		"Product": {Type: FactString, Required: true},
+		"Price":   {Type: FactNumber, Min: float(0)},
+	}},
+}
+
+func TestSchema_ValidateFact(t *testing.T) {
+	tests := []struct {
+		fact   Fact
+		reason string
+		field  string
+	}{
+		{Fact{ID: "temperature", Value: 39}, "", ""},
+		{Fact{ID: "temperature", Value: 39.5}, "", ""},
+		{Fact{ID: "temperature", Value: "hot"}, ReasonType, ""},
+		{Fact{ID: "temperature", Value: 50}, ReasonRange, ""},
+		{Fact{ID: "level", Value: "red"}, "", ""},
+		{Fact{ID: "level", Value: "blue"}, ReasonEnum, ""},
+		{Fact{ID: "sale", Value: struct {
+			Product string
+			Price   float64
+		}{"pizza", 100}}, "", ""},
+		{Fact{ID: "sale", Value: map[string]interface{}{"Price": 10.0}}, ReasonRequired, "Product"},
+		{Fact{ID: "sale", Value: map[string]interface{}{"Product": "pizza", "Price": -1}}, ReasonRange, "Price"},
+		{Fact{ID: "undeclared", Value: "anything"}, "", ""},
+	}
+	for _, tt := range tests {
+		err := triageSchema.ValidateFact(tt.fact)
+		if tt.reason == "" {
+			if err != nil {
+				t.Errorf("%s = %v: unexpected error: %v", tt.fact.ID, tt.fact.Value, err)
+			}
+			continue
+		}
+		var errs ValidationErrors
+		if !errors.As(err, &errs) || len(errs) != 1 {
+			t.Errorf("%s = %v: expected one validation error, got %v", tt.fact.ID, tt.fact.Value, err)
+			continue
+		}
+		if errs[0].Reason != tt.reason || errs[0].Field != tt.field || errs[0].FactID != tt.fact.ID {
+			t.Errorf("%s = %v: unexpected error %+v", tt.fact.ID, tt.fact.Value, errs[0])
+		}
+	}
+}
+
+func TestSchema_ValidateRequired(t *testing.T) {
+	err := triageSchema.Validate(map[string]Fact{"level": {ID: "level", Value: "red"}})
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].FactID != "temperature" || errs[0].Reason != ReasonRequired {
+		t.Errorf("Expected temperature to be reported missing, got %v", err)
+	}
+}
+
+func TestKnowledgeBase_AddFactValidates(t *testing.T) {
+	kb := &KnowledgeBase{Schema: triageSchema}
+	kb.Start()
+
+	err := kb.AddFact(Fact{ID: "temperature", Value: "hot"})
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected validation errors, got %v", err)
+	}
+	if _, ok := kb.Facts["temperature"]; ok {
+		t.Errorf("Expected the invalid fact to be rejected")
+	}
+	if err := kb.AddFact(Fact{ID: "temperature", Value: 39}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestKnowledgeBase_TypeCheck(t *testing.T) {
+	kb := &KnowledgeBase{
+		Schema: triageSchema,
+		Inferences: []Inference{
+			{ID: "fever", Rules: []WeightedRule{{Rule: Rule{Expression: "temperature > 38"}, Weight: 1}}, FactID: "fever", FactValue: true},
+			{ID: "pizza", Rules: []WeightedRule{{Rule: Rule{Expression: "sale.Product == 'pizza'"}, Weight: 1}}, FactID: "pizza", FactValue: true},
+			{ID: "even", Rules: []WeightedRule{{Rule: Rule{Expression: "temperature % 2 == 0"}, Weight: 1}}, FactID: "even", FactValue: true},
+		},
+	}
+	if err := kb.TypeCheck(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	kb.Inferences = append(kb.Inferences,
+		Inference{ID: "bad type", Rules: []WeightedRule{{Rule: Rule{Expression: "level > 3"}, Weight: 1}}, FactID: "x", FactValue: true},
+		Inference{ID: "bad field", Rules: []WeightedRule{{Rule: Rule{Expression: "sale.Prodcut == 'pizza'"}, Weight: 1}}, FactID: "y", FactValue: true},
+	)
+	kb.exprs = nil
+	err := kb.TypeCheck()
+	if err == nil {
+		t.Fatalf("Expected type errors")
+	}
+	for _, want := range []string{"inferences[3].rules[0].expression", "inferences[4].rules[0].expression: sale has no field Prodcut"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in %v", want, err)
+		}
+	}
+}
+
+func TestPipeline_RunValidatesInput(t *testing.T) {
+	kb := &KnowledgeBase{Facts: map[string]Fact{}}
+	pipeline := NewPipeline(PipelineConfig{KnowledgeBase: kb, Schema: triageSchema})
+
+	_, err := pipeline.Run(map[string]Fact{
+		"temperature": {ID: "temperature", Value: 60},
+		"level":       {ID: "level", Value: "blue"},
+	})
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("Expected two validation errors, got %v", err)
+	}
+	if len(kb.Facts) != 0 {
+		t.Errorf("Expected no fact to be added, got %v", kb.Facts)
+	}
+
+	_, err = pipeline.Run(map[string]Fact{"level": {ID: "level", Value: "red"}})
+	if !errors.As(err, &errs) || errs[0].Reason != ReasonRequired {
+		t.Errorf("Expected the required temperature to be reported, got %v", err)
+	}
+
+	if _, err := pipeline.Run(map[string]Fact{"temperature": {ID: "temperature", Value: 39}}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package inference
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// RuleSet holds the rule definitions of a knowledge base (inferences,
+// contradictions and conclusions) compiled once, so they can back any number
+// of independent sessions. A RuleSet is read only and safe for concurrent use.
+type RuleSet struct {
+	inferences     []Inference
+	contradictions []Contradiction
+	conclusions    []Conclusion
+	maxIterations  int
+	schema         Schema
+	bayes          *BayesianNetwork
+	sources        map[string]float64
+	resolution     ConflictResolution
+	seed           int64
+	stepThrough    bool
+	strategy       Strategy
+	closedWorld    bool
+	clock          func() time.Time
+	retention      Duration
+	budget         *Budget
+	strict         bool
+	// facts are the initial facts every session starts with
+	facts   map[string]Fact
+	network *matchNetwork
+	exprs   *ExpressionCache
+}
+
+// NewRuleSet compiles the definitions of a knowledge base into a rule set.
+// The definitions are copied: later changes to kb do not affect the rule set
+// nor its sessions.
+func NewRuleSet(kb *KnowledgeBase) *RuleSet {
+	rs := &RuleSet{
+		inferences:     slices.Clip(slices.Clone(kb.Inferences)),
+		contradictions: slices.Clip(slices.Clone(kb.Contradictions)),
+		conclusions:    slices.Clip(slices.Clone(kb.Conclusions)),
+		maxIterations:  kb.MaxIterations,
+		schema:         kb.Schema,
+		bayes:          kb.BayesianNetwork,
+		sources:        maps.Clone(kb.Sources),
+		resolution:     kb.ConflictResolution,
+		seed:           kb.Seed,
+		stepThrough:    kb.StepThrough,
+		strategy:       kb.strategy,
+		facts:          maps.Clone(kb.Facts),
+		closedWorld:    kb.ClosedWorld,
+		clock:          kb.Clock,
+		retention:      kb.Retention,
+		budget:         kb.Budget,
+		strict:         kb.Strict,
+		exprs:          newWorldExpressions(kb.Schema, kb.ClosedWorld),
+	}
+	if kb.Clock != nil {
+		rs.exprs.now = kb.Clock
+	}
+	rs.network = newMatchNetwork(rs.inferences)
+	return rs
+}
+
+// NewSession starts a session with its own working memory over the rules.
+func (rs *RuleSet) NewSession() *Session {
+	s := &Session{rules: rs}
+	s.reset()
+	return s
+}
+
+// Session is the working memory of a single user over a shared RuleSet.
+// Unlike KnowledgeBase, all its methods are safe for concurrent use: calls
+// on the same session are serialized and sessions of the same RuleSet do not
+// share any mutable state.
+type Session struct {
+	mu    sync.Mutex
+	rules *RuleSet
+	kb    *KnowledgeBase
+}
+
+func (s *Session) reset() {
+	s.kb = &KnowledgeBase{
+		Facts:              maps.Clone(s.rules.facts),
+		Inferences:         s.rules.inferences,
+		Contradictions:     s.rules.contradictions,
+		Conclusions:        s.rules.conclusions,
+		MaxIterations:      s.rules.maxIterations,
+		Schema:             s.rules.schema,
+		BayesianNetwork:    s.rules.bayes,
+		Sources:            s.rules.sources,
+		ConflictResolution: s.rules.resolution,
+		Seed:               s.rules.seed,
+		StepThrough:        s.rules.stepThrough,
+		ClosedWorld:        s.rules.closedWorld,
+		Clock:              s.rules.clock,
+		Retention:          s.rules.retention,
+		Budget:             s.rules.budget,
+		Strict:             s.rules.strict,
+		strategy:           s.rules.strategy,
+		network:            s.rules.network,
+		exprs:              s.rules.exprs,
+	}
+	if s.kb.Facts == nil {
+		s.kb.Facts = make(map[string]Fact)
+	}
+	s.kb.resetMatchState()
+}
+
+// Reset discards the facts of the session and its subscriptions, going back
+// to the initial facts of the rule set.
+func (s *Session) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+}
+
+// AddFact adds a fact to the session, see KnowledgeBase.AddFact.
+func (s *Session) AddFact(fact Fact) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.kb.AddFact(fact)
+}
+
+// AddFactContext adds a fact to the session, see
+// KnowledgeBase.AddFactContext.
+func (s *Session) AddFactContext(ctx context.Context, fact Fact) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.kb.AddFactContext(ctx, fact)
+}
+
+// UpdateFact replaces a fact of the session, see KnowledgeBase.UpdateFact.
+func (s *Session) UpdateFact(fact Fact) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.kb.UpdateFact(fact)
+}
+
+// RetractFact removes a fact from the session, see KnowledgeBase.RetractFact.
+func (s *Session) RetractFact(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.kb.RetractFact(id)
+}
+
+// Infer runs the inferences of the session, see KnowledgeBase.Infer.
+func (s *Session) Infer() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.kb.Infer()
+}
+
+// InferContext runs the inferences of the session, see
+// KnowledgeBase.InferContext.
+func (s *Session) InferContext(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.kb.InferContext(ctx)
+}
+
+// Facts returns a copy of the facts of the session.
+func (s *Session) Facts() map[string]Fact {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.kb.Facts)
+}
+
+// Fact returns a fact of the session.
+func (s *Session) Fact(id string) (Fact, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fact, ok := s.kb.Facts[id]
+	return fact, ok
+}
+
+// Prove proves a goal against the facts of the session, see
+// KnowledgeBase.Prove.
+func (s *Session) Prove(goal string) Proof {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.kb.Prove(goal)
+}
+
+// GetTrueConclusions returns the conclusions that hold in the session.
+func (s *Session) GetTrueConclusions() []Conclusion {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.kb.GetTrueConclusions()
+}
+
+// GetPendingInference returns the inferences still needed in the session.
+func (s *Session) GetPendingInference() []Inference {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.kb.GetPendingInference()
+}
+
+// Diagnostics returns the diagnostics of the last call on the session, see
+// KnowledgeBase.Diagnostics.
+func (s *Session) Diagnostics() Diagnostics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.kb.Diagnostics()
+}
+
+// Subscribe registers a listener for the changes of the session, see
+// KnowledgeBase.Subscribe. Listeners run while the session is locked and
+// must not call back into it.
+func (s *Session) Subscribe(listener func(ChangeSet)) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unsubscribe := s.kb.Subscribe(listener)
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		unsubscribe()
+	}
+}
+
+// Do runs fn with exclusive access to the working memory of the session,
+// for operations not covered by the other methods. The knowledge base must
+// not be retained after fn returns, and its Inferences, Contradictions and
+// Conclusions, shared with the other sessions, must not be modified.
+func (s *Session) Do(fn func(kb *KnowledgeBase)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.kb)
+}
@@ -0,0 +1,102 @@
+package inference
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestRuleSet_IndependentSessions(t *testing.T) {
+	rules := NewRuleSet(feverKnowledgeBase())
+	hot := rules.NewSession()
+	cold := rules.NewSession()
+
+	hot.AddFact(Fact{ID: "temperature", Value: 39})
+	cold.AddFact(Fact{ID: "temperature", Value: 36})
+
+	if _, ok := hot.Fact("fever"); !ok {
+		t.Errorf("Expected fever in the first session")
+	}
+	if _, ok := cold.Fact("fever"); ok {
+		t.Errorf("Expected no fever in the second session")
+	}
+
+	hot.Reset()
+	if len(hot.Facts()) != 0 {
+		t.Errorf("Expected no facts after reset, got %v", hot.Facts())
+	}
+}
+
+func TestRuleSet_ConcurrentSessions(t *testing.T) {
+	rules := NewRuleSet(triageKnowledgeBase())
+	shared := rules.NewSession()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			session := rules.NewSession()
+			temperature := 36 + i
+			session.AddFact(Fact{ID: "temperature", Value: temperature})
+			session.AddFact(Fact{ID: "systolic_bp", Value: 80})
+			want := "green"
+			if temperature > 38 {
+				want = "red"
+			}
+			if got, _ := session.Fact("triage_level"); got.Value != want {
+				t.Errorf("Expected %s triage for %d, got %v", want, temperature, got.Value)
+			}
+
+			shared.AddFact(Fact{ID: fmt.Sprintf("note_%d", i), Value: i})
+			shared.Prove("triage_level")
+		}(i)
+	}
+	wg.Wait()
+
+	if n := len(shared.Facts()); n != 8 {
+		t.Errorf("Expected 8 facts in the shared session, got %d", n)
+	}
+}
+
+func TestPipeline_ConcurrentRunSession(t *testing.T) {
+	kb := triageKnowledgeBase()
+	kb.Conclusions = []Conclusion{
+		{Description: "Critical patient", Facts: []Fact{{ID: "triage_level", Value: "red"}}},
+	}
+	pipeline := NewPipeline(PipelineConfig{
+		KnowledgeBase: kb,
+		RiskAnalyzer: &RiskAnalyzer{Risks: []Risk{
+			{Description: "Critical temperature", Level: RiskHigh, Expression: "temperature > 40"},
+		}},
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session, err := pipeline.NewSession()
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+			result, err := pipeline.RunSession(session, map[string]Fact{
+				"temperature": {ID: "temperature", Value: 41},
+				"systolic_bp": {ID: "systolic_bp", Value: 80},
+			})
+			if err != nil {
+				t.Errorf("Pipeline failed: %v", err)
+				return
+			}
+			if result.Result != "Critical patient" {
+				t.Errorf("Expected 'Critical patient', got %q", result.Result)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(kb.Facts) != 0 {
+		t.Errorf("Expected sessions not to modify the knowledge base, got %v", kb.Facts)
+	}
+}
@@ -30,7 +30,7 @@ func RankSolutions(solutions []RankedSolution, weights SolutionScore) []RankedSo
 	for i := range solutions {
 		solutions[i].CompositeScore = solutions[i].Score.Composite(weights)
 	}
-	sort.Slice(solutions, func(i, j int) bool {
+	sort.SliceStable(solutions, func(i, j int) bool {
 		return solutions[i].CompositeScore > solutions[j].CompositeScore
 	})
 	return solutions
@@ -0,0 +1,284 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Stage is a step of the pipeline. Stages run in order over the same
+// PipelineState: each one reads what the previous ones left and adds its
+// own results.
+type Stage interface {
+	Name() string
+	Run(ctx context.Context, state *PipelineState) error
+}
+
+// The built-in stage types, in their default order.
+const (
+	StageDomain      = "domain"
+	StageIntent      = "intent"
+	StageEntities    = "entities"
+	StageFacts       = "facts"
+	StageConstraints = "constraints"
+	StageKnowledge   = "knowledge"
+	StageRisks       = "risks"
+)
+
+// DefaultStages is the order the built-in stages run in when the config
+// does not list its stages.
+var DefaultStages = []string{StageDomain, StageIntent, StageEntities, StageFacts, StageConstraints, StageKnowledge, StageRisks}
+
+// StageConfig places a stage of a registered type in the pipeline. Params
+// are passed to the StageFactory of the type as they are.
+type StageConfig struct {
+	Type     string          `json:"type"`
+	Disabled bool            `json:"disabled,omitempty"`
+	Params   json.RawMessage `json:"params,omitempty"`
+}
+
+// StageFactory builds a stage from the pipeline config and the params of
+// its StageConfig, which may be empty.
+type StageFactory func(config *PipelineConfig, params json.RawMessage) (Stage, error)
+
+var (
+	stagesMu sync.RWMutex
+	stages   = map[string]StageFactory{
+		StageDomain:      builtinStage(func(c *PipelineConfig) Stage { return &domainStage{c.DomainDetector} }),
+		StageIntent:      builtinStage(func(c *PipelineConfig) Stage { return &intentStage{c.IntentClassifier} }),
+		StageEntities:    builtinStage(func(c *PipelineConfig) Stage { return &entityStage{c.EntityExtractor} }),
+		StageFacts:       builtinStage(func(c *PipelineConfig) Stage { return factsStage{} }),
+		StageConstraints: builtinStage(func(c *PipelineConfig) Stage { return &constraintStage{c.ConstraintSet} }),
+		StageKnowledge:   builtinStage(func(c *PipelineConfig) Stage { return knowledgeStage{} }),
+		StageRisks:       builtinStage(func(c *PipelineConfig) Stage { return &riskStage{c.RiskAnalyzer} }),
+	}
+)
+
+// RegisterStage makes a stage type available to the pipeline configs by
+// name, replacing the type registered with the same name, built-in ones
+// included.
+func RegisterStage(name string, factory StageFactory) {
+	stagesMu.Lock()
+	defer stagesMu.Unlock()
+	stages[name] = factory
+}
+
+// StageTypes returns the names of the registered stage types, sorted.
+func StageTypes() []string {
+	stagesMu.RLock()
+	defer stagesMu.RUnlock()
+	return sortedKeys(stages)
+}
+
+func stageFactory(name string) (StageFactory, bool) {
+	stagesMu.RLock()
+	defer stagesMu.RUnlock()
+	factory, ok := stages[name]
+	return factory, ok
+}
+
+// builtinStage adapts the constructor of a built-in stage, which takes no
+// params, to a StageFactory.
+func builtinStage(build func(c *PipelineConfig) Stage) StageFactory {
+	return func(config *PipelineConfig, params json.RawMessage) (Stage, error) {
+		return build(config), nil
+	}
+}
+
+// BuildStages returns the stages listed in the config, leaving out the
+// disabled ones, or the built-in stages in their default order when none are
+// listed.
+func (c *PipelineConfig) BuildStages() ([]Stage, error) {
+	configs := c.Stages
+	if len(configs) == 0 {
+		for _, name := range DefaultStages {
+			configs = append(configs, StageConfig{Type: name})
+		}
+	}
+	var built []Stage
+	for i, sc := range configs {
+		if sc.Disabled {
+			continue
+		}
+		factory, ok := stageFactory(sc.Type)
+		if !ok {
+			return nil, fmt.Errorf("stages[%d]: unknown stage type %q", i, sc.Type)
+		}
+		stage, err := factory(c, sc.Params)
+		if err != nil {
+			return nil, fmt.Errorf("stages[%d]: %s: %w", i, sc.Type, err)
+		}
+		built = append(built, stage)
+	}
+	return built, nil
+}
+
+// domainStage detects the domain of the input facts.
+type domainStage struct {
+	detector *DomainDetector
+}
+
+func (s *domainStage) Name() string { return StageDomain }
+
+func (s *domainStage) Run(ctx context.Context, state *PipelineState) error {
+	if s.detector != nil {
+		domain, err := s.detector.detect(state.Input, state.KnowledgeBase.spend)
+		if err != nil {
+			return fmt.Errorf("domain detection failed: %w", err)
+		}
+		state.Domain = domain
+	}
+	state.Signals = append(state.Signals, "Detected domain: "+string(state.Domain))
+	return nil
+}
+
+// intentStage classifies the intent of the input facts.
+type intentStage struct {
+	classifier *IntentClassifier
+}
+
+func (s *intentStage) Name() string { return StageIntent }
+
+func (s *intentStage) Run(ctx context.Context, state *PipelineState) error {
+	if s.classifier != nil {
+		kb := state.KnowledgeBase
+		intent, err := s.classifier.classify(kb.expressions(), state.Input, kb.report, kb.spend)
+		if err != nil {
+			return fmt.Errorf("intent classification failed: %w", err)
+		}
+		state.Intent = intent
+	}
+	state.Signals = append(state.Signals, "Classified intent: "+string(state.Intent.Type))
+	return nil
+}
+
+// entityStage extracts entities from the input facts and adds them as facts
+// to the knowledge base.
+type entityStage struct {
+	extractor *EntityExtractor
+}
+
+func (s *entityStage) Name() string { return StageEntities }
+
+func (s *entityStage) Run(ctx context.Context, state *PipelineState) error {
+	if s.extractor == nil {
+		return nil
+	}
+	kb := state.KnowledgeBase
+	entities, err := s.extractor.extract(kb.expressions(), state.Input, kb.report, kb.spend)
+	if err != nil {
+		return fmt.Errorf("entity extraction failed: %w", err)
+	}
+	state.Entities = entities
+	for _, entity := range entities {
+		fact := Fact{ID: entity.FactID, Value: entity.Value, Source: "extracted"}
+		// rules that do not set a confidence extract certain entities
+		if entity.Confidence != 0 {
+			fact.Certainty = recorded(entity.Confidence)
+		}
+		err := kb.AddFactContext(ctx, fact)
+		if err != nil {
+			return fmt.Errorf("adding entity %q: %w", fact.ID, err)
+		}
+	}
+	if len(entities) > 0 {
+		state.Signals = append(state.Signals, fmt.Sprintf("Extracted %d entities", len(entities)))
+	}
+	return nil
+}
+
+// factsStage adds the input facts to the knowledge base.
+type factsStage struct{}
+
+func (factsStage) Name() string { return StageFacts }
+
+func (factsStage) Run(ctx context.Context, state *PipelineState) error {
+	for _, id := range sortedKeys(state.Input) {
+		fact := state.Input[id]
+		if fact.Source == "" {
+			fact.Source = "input"
+		}
+		if err := state.KnowledgeBase.AddFactContext(ctx, fact); err != nil {
+			return fmt.Errorf("adding fact %q: %w", fact.ID, err)
+		}
+	}
+	return nil
+}
+
+// constraintStage identifies the constraints on the facts and records the
+// ones not met as tradeoffs or assumptions.
+type constraintStage struct {
+	set *ConstraintSet
+}
+
+func (s *constraintStage) Name() string { return StageConstraints }
+
+func (s *constraintStage) Run(ctx context.Context, state *PipelineState) error {
+	if s.set == nil {
+		return nil
+	}
+	kb := state.KnowledgeBase
+	constraints, err := s.set.identify(kb.expressions(), kb.Facts, kb.report, kb.spend)
+	if err != nil {
+		return fmt.Errorf("constraint identification failed: %w", err)
+	}
+	state.Constraints = constraints
+
+	// Check constraint satisfaction
+	for _, c := range constraints {
+		if err := kb.spend(1); err != nil {
+			return err
+		}
+		satisfied, err := c.satisfied(kb.expressions(), kb.Facts)
+		if err != nil {
+			continue
+		}
+		if !satisfied && c.Type == ConstraintHard {
+			state.Tradeoffs = append(state.Tradeoffs, "Hard constraint not met: "+c.Description)
+		} else if !satisfied && c.Type == ConstraintSoft {
+			state.Assumptions = append(state.Assumptions, "Soft constraint relaxed: "+c.Description)
+		}
+	}
+	return nil
+}
+
+// knowledgeStage runs the inferences and resolves the contradictions.
+type knowledgeStage struct{}
+
+func (knowledgeStage) Name() string { return StageKnowledge }
+
+func (knowledgeStage) Run(ctx context.Context, state *PipelineState) error {
+	kb := state.KnowledgeBase
+	if err := kb.InferContext(ctx); err != nil {
+		return fmt.Errorf("knowledge application failed: %w", err)
+	}
+	kb.ResolveContradictions()
+	state.Signals = append(state.Signals, fmt.Sprintf("Knowledge base has %d facts after inference", len(kb.Facts)))
+	return nil
+}
+
+// riskStage analyzes the risks of the facts and records the high ones as
+// tradeoffs.
+type riskStage struct {
+	analyzer *RiskAnalyzer
+}
+
+func (s *riskStage) Name() string { return StageRisks }
+
+func (s *riskStage) Run(ctx context.Context, state *PipelineState) error {
+	if s.analyzer == nil {
+		return nil
+	}
+	risks, err := s.analyzer.AnalyzeContext(ctx, state.KnowledgeBase)
+	if err != nil {
+		return fmt.Errorf("risk analysis failed: %w", err)
+	}
+	state.Risks = risks
+	for _, r := range risks {
+		if r.Level == RiskHigh {
+			state.Tradeoffs = append(state.Tradeoffs, "High risk: "+r.Description)
+		}
+	}
+	return nil
+}
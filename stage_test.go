@@ -0,0 +1,124 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// lookupStage adds a fact read from a table, like a lookup in an external
+// system would.
+type lookupStage struct {
+	FactID string                 `json:"fact_id"`
+	Table  map[string]interface{} `json:"table"`
+	Key    string                 `json:"key"`
+}
+
+func (s *lookupStage) Name() string { return "lookup" }
+
+func (s *lookupStage) Run(ctx context.Context, state *PipelineState) error {
+	key, ok := state.Input[s.Key]
+	if !ok {
+		return nil
+	}
+	value, ok := s.Table[key.Value.(string)]
+	if !ok {
+		return nil
+	}
+	state.Signals = append(state.Signals, "Looked up "+s.FactID)
+	return state.KnowledgeBase.AddFact(Fact{ID: s.FactID, Value: value, Source: "lookup"})
+}
+
+func init() {
+	RegisterStage("lookup", func(config *PipelineConfig, params json.RawMessage) (Stage, error) {
+		stage := &lookupStage{}
+		if err := json.Unmarshal(params, stage); err != nil {
+			return nil, err
+		}
+		return stage, nil
+	})
+}
+
+func TestPipeline_CustomStages(t *testing.T) {
+	data := `{
+		"knowledge_base": {
+			"inferences": [{"description": "vip", "rules": [{"expression": "tier == \"gold\""}], "fact_id": "vip", "fact_value": true}],
+			"conclusions": [{"description": "VIP customer", "facts": [{"id": "vip", "value": true}]}]
+		},
+		"stages": [
+			{"type": "domain", "disabled": true},
+			{"type": "facts"},
+			{"type": "lookup", "params": {"fact_id": "tier", "key": "customer", "table": {"ann": "gold"}}},
+			{"type": "knowledge"}
+		]
+	}`
+	var config PipelineConfig
+	if err := json.Unmarshal([]byte(data), &config); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.TypeCheck(); err != nil {
+		t.Fatal(err)
+	}
+	config.KnowledgeBase.Start()
+	result, err := NewPipeline(config).Run(map[string]Fact{"customer": {ID: "customer", Value: "ann"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Result != "VIP customer" {
+		t.Errorf("Expected the looked up fact to reach the inferences, got %q", result.Result)
+	}
+	signals := strings.Join(result.Reasoning.Signals, "\n")
+	if strings.Contains(signals, "Detected domain") || !strings.Contains(signals, "Looked up tier") {
+		t.Errorf("Expected the domain stage disabled and the lookup run, got %v", result.Reasoning.Signals)
+	}
+}
+
+func TestPipelineConfig_BuildStages(t *testing.T) {
+	config := PipelineConfig{}
+	stages, err := config.BuildStages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, stage := range stages {
+		names = append(names, stage.Name())
+	}
+	if !slices.Equal(names, DefaultStages) {
+		t.Errorf("Expected the default stages, got %v", names)
+	}
+
+	config.Stages = []StageConfig{{Type: "knowledge"}, {Type: "enrich"}, {Type: "lookup", Params: json.RawMessage(`[]`)}}
+	if _, err := config.BuildStages(); err == nil {
+		t.Errorf("Expected an unknown stage type to fail")
+	}
+	diags := config.Validate()
+	if !diags.HasErrors() || !slices.ContainsFunc(diags, func(d Diagnostic) bool { return d.Path == "stages[1].type" }) ||
+		!slices.ContainsFunc(diags, func(d Diagnostic) bool { return d.Path == "stages[2].params" }) {
+		t.Errorf("Expected the unknown type and the bad params reported, got %v", diags)
+	}
+}
+
+func TestPipeline_ProgrammaticStages(t *testing.T) {
+	kb := &KnowledgeBase{}
+	kb.Start()
+	pipeline := NewPipeline(PipelineConfig{KnowledgeBase: kb})
+	pipeline.Stages = []Stage{factsStage{}, &lookupStage{FactID: "tier", Key: "customer", Table: map[string]interface{}{"bob": "silver"}}}
+	if _, err := pipeline.Run(map[string]Fact{"customer": {ID: "customer", Value: "bob"}}); err != nil {
+		t.Fatal(err)
+	}
+	if kb.Facts["tier"].Value != "silver" {
+		t.Errorf("Expected the stage to run, got %v", kb.Facts)
+	}
+}
+
+func TestPipeline_FactsStageError(t *testing.T) {
+	kb := runaway()
+	kb.MaxIterations = 5
+	_, err := NewPipeline(PipelineConfig{KnowledgeBase: kb}).Run(map[string]Fact{"n": {ID: "n", Value: 0}})
+	if !errors.Is(err, ErrMaxIterations) || !strings.Contains(err.Error(), `adding fact "n"`) {
+		t.Errorf("Expected the failing fact named in the error, got %v", err)
+	}
+}
@@ -0,0 +1,297 @@
+package inference
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Duration is a time.Duration written in JSON as a string like "24h".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON reads a string like "24h" or a number of nanoseconds.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n int64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("duration %s is not a string nor a number", data)
+		}
+		*d = Duration(n)
+		return nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Observation is an earlier value of a fact, see KnowledgeBase.Retention.
+type Observation struct {
+	Value      interface{} `json:"value"`
+	ObservedAt time.Time   `json:"observed_at"`
+}
+
+// temporalOperators are the expression functions that read the clock:
+//
+//	age(sale)                     how long ago sale was observed
+//	within(sale, "24h")           sale was observed in the last 24 hours
+//	window_count(sale, "168h")    how many times sale was observed this week
+//	window_sum(sale, "168h")      the sum of its values in that time
+//	window_sum(sale, "1h", "qty") the sum of the qty field of its values
+//
+// The windows count the current value and the History of the fact. The
+// durations are strings like "24h" or the result of expr-lang duration(),
+// and now() is the time of the clock of the knowledge base.
+var temporalOperators = []string{"age", "within", "window_count", "window_sum", "now"}
+
+// now returns the time of the clock of the knowledge base.
+func (kb *KnowledgeBase) now() time.Time {
+	if kb.Clock != nil {
+		return kb.Clock()
+	}
+	return time.Now()
+}
+
+// expiry returns when the fact stops being valid, or the zero time.
+func (f *Fact) expiry() time.Time {
+	if !f.ValidUntil.IsZero() {
+		return f.ValidUntil
+	}
+	if f.TTL > 0 && !f.ObservedAt.IsZero() {
+		return f.ObservedAt.Add(time.Duration(f.TTL))
+	}
+	return time.Time{}
+}
+
+// observe stamps the fact with the clock and keeps the value it replaces in
+// its history, dropping the observations older than the retention.
+func (kb *KnowledgeBase) observe(fact Fact) Fact {
+	now := kb.now()
+	if fact.ObservedAt.IsZero() {
+		fact.ObservedAt = now
+	}
+	if kb.Retention <= 0 {
+		return fact
+	}
+	old, ok := kb.Facts[fact.ID]
+	if !ok {
+		old, ok = kb.scheduled[fact.ID]
+	}
+	if !ok || old.ObservedAt.IsZero() {
+		return fact
+	}
+	history := append(slices.Clone(old.History), Observation{Value: old.Value, ObservedAt: old.ObservedAt})
+	since := now.Add(-time.Duration(kb.Retention))
+	fact.History = slices.DeleteFunc(history, func(o Observation) bool { return o.ObservedAt.Before(since) })
+	return fact
+}
+
+// schedule holds the fact until it becomes valid, reporting whether it is
+// not valid yet.
+func (kb *KnowledgeBase) schedule(fact Fact) bool {
+	if !fact.ValidFrom.After(kb.now()) {
+		delete(kb.scheduled, fact.ID)
+		return false
+	}
+	if kb.scheduled == nil {
+		kb.scheduled = make(map[string]Fact)
+	}
+	kb.scheduled[fact.ID] = fact
+	return true
+}
+
+// advanceClock adds the scheduled facts that became valid, removes the
+// facts past their validity or TTL with what was derived from them, retracts
+// what the inferences reading the clock no longer support and marks them to
+// be evaluated again.
+func (kb *KnowledgeBase) advanceClock() error {
+	now := kb.now()
+	for _, id := range sortedKeys(kb.scheduled) {
+		fact := kb.scheduled[id]
+		if fact.ValidFrom.After(now) {
+			continue
+		}
+		delete(kb.scheduled, id)
+		kb.Facts[id] = fact
+		kb.touch(id, -1)
+		kb.RemoveDerivedFrom(id)
+	}
+	for _, id := range sortedKeys(kb.Facts) {
+		fact, ok := kb.Facts[id]
+		if !ok {
+			continue
+		}
+		if expiry := fact.expiry(); !expiry.IsZero() && !now.Before(expiry) {
+			kb.removeFact(id)
+			kb.RemoveDerivedFrom(id)
+		}
+	}
+	err := kb.retractStale()
+	for _, i := range kb.matchNetwork().timed {
+		kb.dirty[i] = true
+	}
+	return err
+}
+
+// retractStale removes the justifications of the inferences reading the
+// clock that no longer derive the fact they justify. Like RemoveDerivedFrom,
+// facts left without justifications are removed with what was derived from
+// them, unless accumulative. Each inference is evaluated at most once, its
+// errors are reported and the retraction stops when the report or the
+// budget fails.
+func (kb *KnowledgeBase) retractStale() error {
+	timed := make(map[string]int)
+	net := kb.matchNetwork()
+	for _, i := range net.timed {
+		timed[net.justifiers[i]] = i
+	}
+	if len(timed) == 0 {
+		return nil
+	}
+	derived := make(map[int][]inferred)
+	var failed error
+	stillDerives := func(i int, fact Fact) bool {
+		results, ok := derived[i]
+		if !ok {
+			// without patterns there is a single, empty, binding
+			var err error
+			results, err = kb.Inferences[i].inferEach(kb.expressions(), kb.Facts, fmt.Sprintf("inferences[%d]", i), kb.report, kb.spend)
+			if err != nil {
+				// stopped: the justifications are kept
+				failed = err
+				return true
+			}
+			derived[i] = results
+		}
+		return slices.ContainsFunc(results, func(result inferred) bool {
+			return result.id == fact.ID && sameFactValue(result.value, fact.Value)
+		})
+	}
+	for _, id := range sortedKeys(kb.Facts) {
+		if failed != nil {
+			return failed
+		}
+		fact, ok := kb.Facts[id]
+		if !ok {
+			continue
+		}
+		before := len(fact.Justifications)
+		fact.Justifications = slices.DeleteFunc(slices.Clone(fact.Justifications), func(j Justification) bool {
+			i, ok := timed[j.Inference]
+			return ok && !stillDerives(i, fact)
+		})
+		if len(fact.Justifications) == before {
+			continue
+		}
+		if len(fact.Justifications) == 0 && !fact.Accumulative {
+			kb.removeFact(id)
+			kb.RemoveDerivedFrom(id)
+			continue
+		}
+		fact.DerivedFrom = fact.premises()
+		fact.Certainty = fact.justifiedCertainty()
+		kb.Facts[id] = fact
+		kb.touch(id, -1)
+	}
+	return failed
+}
+
+// temporal returns the functions of the temporal operators evaluated
+// against the facts at the time now returns.
+func temporal(facts map[string]Fact, now func() time.Time) map[string]interface{} {
+	observed := func(id string) (Fact, error) {
+		fact, ok := facts[id]
+		if !ok {
+			return Fact{}, fmt.Errorf("%w %s", ErrUnknownFact, id)
+		}
+		if fact.ObservedAt.IsZero() {
+			return Fact{}, fmt.Errorf("%s has no observation time", id)
+		}
+		return fact, nil
+	}
+	// observations returns the values of the fact observed in the window
+	observations := func(id string, window interface{}) ([]interface{}, error) {
+		fact, err := observed(id)
+		if err != nil {
+			return nil, err
+		}
+		d, err := toDuration(window)
+		if err != nil {
+			return nil, err
+		}
+		since := now().Add(-d)
+		var values []interface{}
+		for _, o := range append(slices.Clone(fact.History), Observation{Value: fact.Value, ObservedAt: fact.ObservedAt}) {
+			if !o.ObservedAt.Before(since) {
+				values = append(values, o.Value)
+			}
+		}
+		return values, nil
+	}
+	return map[string]interface{}{
+		"now": now,
+		"age": func(id string) (time.Duration, error) {
+			fact, err := observed(id)
+			if err != nil {
+				return 0, err
+			}
+			return now().Sub(fact.ObservedAt), nil
+		},
+		"within": func(id string, window interface{}) (bool, error) {
+			fact, err := observed(id)
+			if err != nil {
+				return false, err
+			}
+			d, err := toDuration(window)
+			if err != nil {
+				return false, err
+			}
+			return !fact.ObservedAt.Before(now().Add(-d)), nil
+		},
+		"window_count": func(id string, window interface{}) (int, error) {
+			values, err := observations(id, window)
+			return len(values), err
+		},
+		"window_sum": func(id string, window interface{}, field ...string) (float64, error) {
+			values, err := observations(id, window)
+			if err != nil {
+				return 0, err
+			}
+			sum := 0.0
+			for _, value := range values {
+				if len(field) > 0 {
+					object, ok := value.(map[string]interface{})
+					if !ok {
+						return 0, fmt.Errorf("%s is %T, not an object with %s", id, value, field[0])
+					}
+					value = object[field[0]]
+				}
+				n, ok := toFloat(value)
+				if !ok {
+					return 0, fmt.Errorf("%s is %T, not a number", id, value)
+				}
+				sum += n
+			}
+			return sum, nil
+		},
+	}
+}
+
+// toDuration reads a window given as a string like "24h" or as a duration.
+func toDuration(window interface{}) (time.Duration, error) {
+	switch w := window.(type) {
+	case time.Duration:
+		return w, nil
+	case string:
+		return time.ParseDuration(w)
+	}
+	return 0, fmt.Errorf("window %v is %T, not a duration", window, window)
+}
@@ -0,0 +1,169 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock the tests move by hand.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)}
+}
+
+func TestKnowledgeBase_Expiry(t *testing.T) {
+	clock := newFakeClock()
+	kb := &KnowledgeBase{
+		Clock:      clock.Now,
+		Inferences: []Inference{{ID: "fever", Rules: rule("temperature > 38"), FactID: "fever", FactValue: true}},
+	}
+	kb.Start()
+	kb.AddFact(Fact{ID: "temperature", Value: 39, TTL: Duration(4 * time.Hour)})
+	if !kb.Facts["temperature"].ObservedAt.Equal(clock.now) || !kb.Facts["fever"].ObservedAt.Equal(clock.now) {
+		t.Errorf("Expected the facts to be stamped with the clock, got %v", kb.Facts)
+	}
+
+	clock.advance(3 * time.Hour)
+	kb.Infer()
+	if _, ok := kb.Facts["fever"]; !ok {
+		t.Errorf("Expected the fact to hold before its TTL")
+	}
+	clock.advance(time.Hour)
+	kb.Infer()
+	if _, ok := kb.Facts["temperature"]; ok {
+		t.Errorf("Expected the fact to expire after its TTL")
+	}
+	if _, ok := kb.Facts["fever"]; ok {
+		t.Errorf("Expected the derived fact to be retracted with it")
+	}
+}
+
+func TestKnowledgeBase_ValidityWindow(t *testing.T) {
+	clock := newFakeClock()
+	kb := &KnowledgeBase{Clock: clock.Now}
+	kb.Start()
+	kb.AddFact(Fact{ID: "promotion", Value: true, ValidFrom: clock.now.Add(time.Hour), ValidUntil: clock.now.Add(2 * time.Hour)})
+	if _, ok := kb.Facts["promotion"]; ok {
+		t.Errorf("Expected the fact to be held back until it is valid")
+	}
+	clock.advance(time.Hour)
+	kb.Infer()
+	if _, ok := kb.Facts["promotion"]; !ok {
+		t.Errorf("Expected the fact once valid")
+	}
+	clock.advance(time.Hour)
+	kb.Infer()
+	if _, ok := kb.Facts["promotion"]; ok {
+		t.Errorf("Expected the fact to expire at ValidUntil")
+	}
+}
+
+func TestKnowledgeBase_WindowOperators(t *testing.T) {
+	clock := newFakeClock()
+	kb := &KnowledgeBase{
+		Clock:     clock.Now,
+		Retention: Duration(30 * 24 * time.Hour),
+		Inferences: []Inference{
+			{ID: "regular", Rules: rule(`window_count(sale, "168h") > 3`), FactID: "regular", FactValue: true},
+			{ID: "spent", Rules: rule(`true`), FactID: "spent", FactValue: `window_sum(sale, "168h", "price")`, IsValeCalculated: true, OverWrite: true},
+			{ID: "recent", Rules: rule(`within(sale, "1h") && age(sale) < duration("30m")`), FactID: "recent", FactValue: true},
+		},
+	}
+	kb.Start()
+	sale := map[string]interface{}{"product": "pizza", "price": 10}
+	// one old sale, out of the week
+	kb.AddFact(Fact{ID: "sale", Value: sale})
+	clock.advance(10 * 24 * time.Hour)
+	for i := 0; i < 3; i++ {
+		kb.AddFact(Fact{ID: "sale", Value: sale})
+		clock.advance(24 * time.Hour)
+	}
+	kb.Infer()
+	if _, ok := kb.Facts["regular"]; ok {
+		t.Errorf("Expected 3 pizzas this week not to make a regular")
+	}
+	if kb.Facts["spent"].Value != 30.0 {
+		t.Errorf("Expected 30 spent this week, got %v", kb.Facts["spent"].Value)
+	}
+	if _, ok := kb.Facts["recent"]; ok {
+		t.Errorf("Expected no recent sale a day later")
+	}
+	kb.AddFact(Fact{ID: "sale", Value: sale})
+	if _, ok := kb.Facts["regular"]; !ok {
+		t.Errorf("Expected 4 pizzas this week to make a regular")
+	}
+	if _, ok := kb.Facts["recent"]; !ok {
+		t.Errorf("Expected the sale to be recent")
+	}
+	if n := len(kb.Facts["sale"].History); n != 4 {
+		t.Errorf("Expected 4 earlier observations, got %d", n)
+	}
+}
+
+func TestDuration_JSON(t *testing.T) {
+	var fact Fact
+	if err := json.Unmarshal([]byte(`{"id": "x", "ttl": "24h"}`), &fact); err != nil || time.Duration(fact.TTL) != 24*time.Hour {
+		t.Fatalf("Expected a TTL of 24h, got %v, %v", fact.TTL, err)
+	}
+	data, _ := json.Marshal(fact)
+	var back map[string]interface{}
+	json.Unmarshal(data, &back)
+	if back["ttl"] != "24h0m0s" {
+		t.Errorf("Expected the TTL as a string, got %v", back["ttl"])
+	}
+	if _, ok := back["observed_at"]; ok {
+		t.Errorf("Expected no observation time, got %v", back["observed_at"])
+	}
+}
+
+func TestKnowledgeBase_RetractStaleOnce(t *testing.T) {
+	clock := newFakeClock()
+	kb := &KnowledgeBase{
+		Clock: clock.Now,
+		Inferences: []Inference{{
+			ID:             "open",
+			ForEach:        []Pattern{{Var: "order", ID: "order_*"}},
+			Rules:          rule("now() < order.value"),
+			FactID:         `"open_" + order.key`,
+			IsIDCalculated: true,
+			FactValue:      true,
+		}},
+	}
+	kb.Start()
+	const orders = 5
+	for i := 0; i < orders; i++ {
+		kb.AddFact(Fact{ID: fmt.Sprintf("order_%d", i), Value: clock.now.Add(time.Duration(i+1) * time.Hour)})
+	}
+	clock.advance(90 * time.Minute)
+	evaluations := 0
+	counter := ObserverFunc(func(e Event) {
+		if e.Type == EventEvaluated && e.Expression == "now() < order.value" {
+			evaluations++
+		}
+	})
+	kb.InferContext(WithObserver(context.Background(), counter))
+	if _, ok := kb.Facts["open_0"]; ok {
+		t.Errorf("Expected the passed order to be retracted")
+	}
+	if _, ok := kb.Facts["open_1"]; !ok {
+		t.Errorf("Expected the later orders to stay open")
+	}
+	// once to retract, once to fire
+	if evaluations > 2*orders {
+		t.Errorf("Expected the inference to be evaluated once per order and pass, got %d evaluations", evaluations)
+	}
+}
@@ -0,0 +1,152 @@
+package inference
+
+import (
+	"reflect"
+	"slices"
+)
+
+// Justification records why a derived fact holds: the inference that
+// produced it and the facts its expressions referenced.
+type Justification struct {
+	// Inference names the inference, followed by its index when other
+	// inferences share the name, like alert[2]
+	Inference string   `json:"inference"`
+	Premises  []string `json:"premises"`
+	// Certainty is the certainty the inference gave the fact, nil when
+	// fully certain
+	Certainty *float64 `json:"certainty,omitempty"`
+}
+
+// isBase reports whether the fact was given rather than derived.
+func (f *Fact) isBase() bool {
+	return len(f.Justifications) == 0 && len(f.DerivedFrom) == 0
+}
+
+// justify adds a justification to the fact, replacing the previous one of
+// the same inference.
+func (f *Fact) justify(justification Justification) {
+	f.Justifications = slices.DeleteFunc(f.Justifications, func(j Justification) bool {
+		return j.Inference == justification.Inference
+	})
+	f.Justifications = append(f.Justifications, justification)
+	f.DerivedFrom = f.premises()
+	f.Certainty = f.justifiedCertainty()
+}
+
+// unjustify drops the justifications that use the premise and reports
+// whether the fact depended on it. Facts without justifications fall back
+// to DerivedFrom.
+func (f *Fact) unjustify(premise string) bool {
+	if len(f.Justifications) == 0 {
+		return slices.Contains(f.DerivedFrom, premise)
+	}
+	before := len(f.Justifications)
+	f.Justifications = slices.DeleteFunc(slices.Clone(f.Justifications), func(j Justification) bool {
+		return slices.Contains(j.Premises, premise)
+	})
+	if len(f.Justifications) == before {
+		return false
+	}
+	f.DerivedFrom = f.premises()
+	f.Certainty = f.justifiedCertainty()
+	return true
+}
+
+// justifiedCertainty combines the certainty factors of the justifications,
+// see CombineCF. A combined CF that reads fully certain is not recorded.
+func (f *Fact) justifiedCertainty() *float64 {
+	var cfs []float64
+	for _, j := range f.Justifications {
+		cfs = append(cfs, j.certainty())
+	}
+	return recorded(CombineCF(cfs...))
+}
+
+func (f *Fact) premises() []string {
+	var premises []string
+	for _, j := range f.Justifications {
+		premises = append(premises, j.Premises...)
+	}
+	return unique(premises)
+}
+
+// derive records the fact produced by the inference at index i. A fact that
+// already holds the same value gains the justification and keeps the others;
+// a new value replaces the fact and retracts what was derived from the old
+// one. Given facts with the same value are left untouched.
+func (kb *KnowledgeBase) derive(i int, result inferred) {
+	justification := Justification{Inference: kb.matchNetwork().justifiers[i], Premises: result.premises, Certainty: recorded(result.certainty)}
+	old, ok := kb.Facts[result.id]
+	if ok && reflect.DeepEqual(old.Value, result.value) {
+		if !old.isBase() {
+			certainty := old.certainty()
+			old.justify(justification)
+			kb.Facts[result.id] = old
+			// facts derived from it take the new certainty
+			if old.certainty() != certainty {
+				kb.touch(result.id, i)
+			}
+		}
+		return
+	}
+	if ok {
+		kb.RemoveDerivedFrom(result.id)
+	}
+	fact := Fact{
+		ID:             result.id,
+		Value:          result.value,
+		DerivedFrom:    result.premises,
+		Accumulative:   old.Accumulative,
+		Justifications: []Justification{justification},
+		ObservedAt:     kb.now(),
+	}
+	fact.Certainty = fact.justifiedCertainty()
+	kb.Facts[result.id] = fact
+	kb.touch(result.id, i)
+	kb.countDerived()
+}
+
+// corroborate adds the justification of an inference that is not needed
+// because its fact is already derived, so the fact survives as long as any
+// of the inferences supporting it holds.
+func (kb *KnowledgeBase) corroborate(i int) {
+	inference := kb.Inferences[i]
+	if inference.IsIDCalculated || inference.OverWrite || len(inference.ForEach) > 0 {
+		return
+	}
+	fact, ok := kb.Facts[inference.FactID]
+	if !ok || fact.isBase() {
+		return
+	}
+	result, err := inference.infer(kb.expressions(), kb.Facts)
+	if err != nil || result.id != fact.ID || !reflect.DeepEqual(fact.Value, result.value) {
+		return
+	}
+	kb.derive(i, result)
+}
+
+// RemoveDerivedFrom retracts the justifications that use the given fact as
+// a premise. Facts left without justifications are removed, and so are the
+// facts derived from them, transitively. Accumulative facts are kept.
+func (kb *KnowledgeBase) RemoveDerivedFrom(id string) {
+	pending := []string{id}
+	// no fact is added while retracting
+	keys := sortedKeys(kb.Facts)
+	for len(pending) > 0 {
+		premise := pending[0]
+		pending = pending[1:]
+		for _, key := range keys {
+			fact, ok := kb.Facts[key]
+			// a fact is replaced, not retracted, when its own value changes
+			if !ok || key == premise || !fact.unjustify(premise) {
+				continue
+			}
+			if len(fact.Justifications) > 0 || fact.Accumulative {
+				kb.Facts[key] = fact
+				continue
+			}
+			kb.removeFact(key)
+			pending = append(pending, key)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package inference
+
+import "testing"
+
+func TestKnowledgeBase_RetractionCascades(t *testing.T) {
+	kb := KnowledgeBase{
+		Inferences: []Inference{
+			{
+				Rules:     []WeightedRule{{Rule: Rule{Expression: "temperature > 38"}, Weight: 1}},
+				FactID:    "fever",
+				FactValue: true,
+			},
+			{
+				Rules:     []WeightedRule{{Rule: Rule{Expression: "fever == true"}, Weight: 1}},
+				FactID:    "infection",
+				FactValue: true,
+			},
+			{
+				Rules:     []WeightedRule{{Rule: Rule{Expression: "infection == true"}, Weight: 1}},
+				FactID:    "antibiotics",
+				FactValue: true,
+			},
+		},
+	}
+	kb.Start()
+	kb.AddFact(Fact{ID: "temperature", Value: 39})
+	if _, ok := kb.Facts["antibiotics"]; !ok {
+		t.Fatalf("Expected antibiotics to be inferred")
+	}
+	kb.AddFact(Fact{ID: "temperature", Value: 37})
+	for _, id := range []string{"fever", "infection", "antibiotics"} {
+		if _, ok := kb.Facts[id]; ok {
+			t.Errorf("Expected %s to be retracted", id)
+		}
+	}
+}
+
+func TestKnowledgeBase_MultipleJustifications(t *testing.T) {
+	kb := KnowledgeBase{
+		Inferences: []Inference{
+			{
+				ID:        "alert_fever",
+				Rules:     []WeightedRule{{Rule: Rule{Expression: "fever == true"}, Weight: 1}},
+				FactID:    "alert",
+				FactValue: true,
+			},
+			{
+				ID:        "alert_tachycardia",
+				Rules:     []WeightedRule{{Rule: Rule{Expression: "tachycardia == true"}, Weight: 1}},
+				FactID:    "alert",
+				FactValue: true,
+			},
+		},
+	}
+	kb.Start()
+	kb.AddFact(Fact{ID: "fever", Value: true})
+	kb.AddFact(Fact{ID: "tachycardia", Value: true})
+	if n := len(kb.Facts["alert"].Justifications); n != 2 {
+		t.Fatalf("Expected 2 justifications, got %d", n)
+	}
+
+	kb.AddFact(Fact{ID: "fever", Value: false})
+	alert, ok := kb.Facts["alert"]
+	if !ok {
+		t.Fatalf("Expected alert to survive with one justification")
+	}
+	if len(alert.Justifications) != 1 || alert.Justifications[0].Inference != "alert_tachycardia" {
+		t.Errorf("Expected only the tachycardia justification, got %v", alert.Justifications)
+	}
+
+	kb.AddFact(Fact{ID: "tachycardia", Value: false})
+	if _, ok := kb.Facts["alert"]; ok {
+		t.Errorf("Expected alert to be retracted")
+	}
+}
+
+func TestContradiction_ResolveCascades(t *testing.T) {
+	kb := &KnowledgeBase{
+		Contradictions: []Contradiction{{
+			Facts: []Fact{{ID: "status_ok", Value: true}, {ID: "status_error", Value: true}},
+		}},
+		Facts: map[string]Fact{
+			"status_ok":    {ID: "status_ok", Value: true},
+			"status_error": {ID: "status_error", Value: true},
+			"deploy": {ID: "deploy", Value: true, Justifications: []Justification{
+				{Inference: "deploy", Premises: []string{"status_ok"}},
+			}},
+			"notify": {ID: "notify", Value: true, Justifications: []Justification{
+				{Inference: "notify", Premises: []string{"deploy"}},
+			}},
+		},
+	}
+	kb.ResolveContradictions()
+	if _, ok := kb.Facts["notify"]; ok {
+		t.Errorf("Expected notify to be retracted with deploy")
+	}
+}
+
+func TestKnowledgeBase_JustificationsWithoutIDs(t *testing.T) {
+	kb := KnowledgeBase{
+		Inferences: []Inference{
+			{Description: "raise alert", Rules: rule("fever"), FactID: "alert", FactValue: true, CF: 0.5},
+			{Description: "raise alert", Rules: rule("tachycardia"), FactID: "alert", FactValue: true, CF: 0.5},
+		},
+	}
+	kb.Start()
+	kb.AddFact(Fact{ID: "fever", Value: true})
+	kb.AddFact(Fact{ID: "tachycardia", Value: true})
+	alert := kb.Facts["alert"]
+	if len(alert.Justifications) != 2 || !almostEqual(alert.certainty(), 0.75) {
+		t.Fatalf("Expected both inferences to justify the alert, got %+v", alert)
+	}
+	tree := kb.Explain("alert")
+	if len(tree.Derivations) != 2 || tree.Derivations[0].Rules[0].Expression == tree.Derivations[1].Rules[0].Expression {
+		t.Errorf("Expected both derivations explained, got %+v", tree.Derivations)
+	}
+	kb.RetractFact("fever")
+	if alert := kb.Facts["alert"]; len(alert.Justifications) != 1 || !almostEqual(alert.certainty(), 0.5) {
+		t.Errorf("Expected the alert to keep the other justification, got %+v", alert)
+	}
+}
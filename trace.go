@@ -0,0 +1,211 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// EventType tells what an Event reports.
+type EventType string
+
+const (
+	EventStageStarted          EventType = "stage_started"
+	EventStageFinished         EventType = "stage_finished"
+	EventEvaluated             EventType = "evaluated"
+	EventFactAdded             EventType = "fact_added"
+	EventFactRemoved           EventType = "fact_removed"
+	EventFactOverwritten       EventType = "fact_overwritten"
+	EventContradictionDetected EventType = "contradiction_detected"
+	EventContradictionResolved EventType = "contradiction_resolved"
+	EventConclusionAsserted    EventType = "conclusion_asserted"
+)
+
+// Event is something that happened while running the rules. Only the
+// fields that apply to its Type are set.
+type Event struct {
+	Type EventType `json:"type"`
+	// Stage is the pipeline stage running when the event happened
+	Stage string `json:"stage,omitempty"`
+	// Rule names the inference that changed a fact
+	Rule string `json:"rule,omitempty"`
+	// Expression was evaluated reading the Inputs, with Result or Error
+	Expression string                 `json:"expression,omitempty"`
+	Inputs     map[string]interface{} `json:"inputs,omitempty"`
+	Result     interface{}            `json:"result,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	// Duration is how long a stage or an evaluation took
+	Duration Duration `json:"duration,omitempty"`
+	// Fact is the fact added, removed or overwritten, Previous the one
+	// it overwrote
+	Fact     *Fact `json:"fact,omitempty"`
+	Previous *Fact `json:"previous,omitempty"`
+	// Contradiction and Conclusion are the descriptions of the
+	// contradiction detected or resolved and of the conclusion asserted
+	Contradiction string `json:"contradiction,omitempty"`
+	Conclusion    string `json:"conclusion,omitempty"`
+}
+
+// Observer receives the events of the knowledge base, as they happen.
+// Observers run on the goroutine of the call and must not call back into
+// the knowledge base.
+type Observer interface {
+	Observe(event Event)
+}
+
+// ObserverFunc adapts a function to an Observer.
+type ObserverFunc func(event Event)
+
+func (f ObserverFunc) Observe(event Event) {
+	f(event)
+}
+
+type observerKey struct{}
+
+// WithObserver returns a context whose calls, like Pipeline.RunContext or
+// KnowledgeBase.InferContext, send their events to the observer as well, so
+// concurrent runs can be traced apart.
+func WithObserver(ctx context.Context, observer Observer) context.Context {
+	return context.WithValue(ctx, observerKey{}, observer)
+}
+
+func observerFrom(ctx context.Context) Observer {
+	observer, _ := ctx.Value(observerKey{}).(Observer)
+	return observer
+}
+
+// Trace is the list of events of a run.
+type Trace struct {
+	Events []Event `json:"events"`
+}
+
+// ReadTrace decodes a trace written by Recorder.WriteJSON.
+func ReadTrace(r io.Reader) (Trace, error) {
+	var trace Trace
+	err := json.NewDecoder(r).Decode(&trace)
+	return trace, err
+}
+
+// Recorder is an Observer that keeps the events it receives. It is safe for
+// concurrent use.
+type Recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// NewRecorder returns an empty recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) Observe(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+// Trace returns the events recorded so far.
+func (r *Recorder) Trace() Trace {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Trace{Events: append([]Event(nil), r.events...)}
+}
+
+// WriteJSON writes the events recorded so far as a JSON trace.
+func (r *Recorder) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r.Trace())
+}
+
+// observed reports whether anyone receives the events of the knowledge
+// base.
+func (kb *KnowledgeBase) observed() bool {
+	return kb.Observer != nil || (kb.guard != nil && kb.guard.observer != nil)
+}
+
+// emit sends the event to the Observer and to the observer of the call in
+// progress.
+func (kb *KnowledgeBase) emit(event Event) {
+	if kb.guard != nil {
+		if event.Stage == "" {
+			event.Stage = kb.guard.stage
+		}
+		if kb.guard.observer != nil {
+			kb.guard.observer.Observe(event)
+		}
+	}
+	if kb.Observer != nil {
+		kb.Observer.Observe(event)
+	}
+}
+
+// evaluated returns the event of an evaluation, with the values of the
+// facts the expression reads.
+func (c *ExpressionCache) evaluated(sExpression string, facts map[string]Fact, output interface{}, err error, d time.Duration) Event {
+	event := Event{Type: EventEvaluated, Expression: sExpression, Duration: Duration(d)}
+	if err != nil {
+		event.Error = err.Error()
+	} else {
+		event.Result = output
+	}
+	if expression, cerr := c.Compile(sExpression); cerr == nil {
+		for _, id := range expression.facts {
+			if fact, ok := facts[id]; ok {
+				if event.Inputs == nil {
+					event.Inputs = make(map[string]interface{})
+				}
+				event.Inputs[id] = fact.Value
+			}
+		}
+	}
+	return event
+}
+
+// observedExpressions returns a view of the cache that emits an event for
+// every evaluation, sharing its compiled programs.
+func (kb *KnowledgeBase) observedExpressions(c *ExpressionCache) *ExpressionCache {
+	view := *c
+	view.observe = kb.emit
+	return &view
+}
+
+// factChanged emits the event of a fact that changed from before, which
+// did not exist when existed is false, to its current state. source is the
+// index of the inference that changed it, or -1.
+func (kb *KnowledgeBase) factChanged(id string, before Fact, existed bool, source int) {
+	after, exists := kb.Facts[id]
+	event := Event{}
+	switch {
+	case !existed && exists:
+		event = Event{Type: EventFactAdded, Fact: &after}
+	case existed && !exists:
+		event = Event{Type: EventFactRemoved, Fact: &before}
+	case exists && !reflect.DeepEqual(before.Value, after.Value):
+		event = Event{Type: EventFactOverwritten, Fact: &after, Previous: &before}
+	default:
+		return
+	}
+	if source >= 0 {
+		event.Rule = kb.Inferences[source].name()
+	}
+	kb.emit(event)
+}
+
+// assertConclusions emits an event for the conclusions that hold now and
+// did not when it was last called.
+func (kb *KnowledgeBase) assertConclusions() {
+	if len(kb.asserted) != len(kb.Conclusions) {
+		kb.asserted = make([]bool, len(kb.Conclusions))
+	}
+	for i, conclusion := range kb.Conclusions {
+		holds := conclusion.Assert(kb.Facts)
+		if holds && !kb.asserted[i] {
+			kb.emit(Event{Type: EventConclusionAsserted, Conclusion: conclusion.Description})
+		}
+		kb.asserted[i] = holds
+	}
+}
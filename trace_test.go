@@ -0,0 +1,94 @@
+package inference
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestKnowledgeBase_Observer(t *testing.T) {
+	recorder := NewRecorder()
+	kb := &KnowledgeBase{
+		Observer: recorder,
+		Inferences: []Inference{
+			{ID: "fever", Rules: rule("temperature > 38"), FactID: "fever", FactValue: true},
+			{ID: "flu", Rules: rule("fever && cough"), FactID: "diagnosis", FactValue: "flu"},
+		},
+		Contradictions: []Contradiction{{Description: "flu despite the vaccine", Facts: []Fact{{ID: "diagnosis", Value: "flu"}, {ID: "vaccinated", Value: true}}}},
+		Conclusions:    []Conclusion{{Description: "Has fever", Facts: []Fact{{ID: "fever", Value: true}}}},
+	}
+	kb.Start()
+	kb.AddFact(Fact{ID: "cough", Value: true})
+	kb.AddFact(Fact{ID: "temperature", Value: 39})
+	kb.UpdateFact(Fact{ID: "temperature", Value: 40})
+	kb.AddFact(Fact{ID: "vaccinated", Value: true})
+
+	events := recorder.Trace().Events
+	index := func(match func(Event) bool) int {
+		return slices.IndexFunc(events, match)
+	}
+	evaluated := index(func(e Event) bool {
+		return e.Type == EventEvaluated && e.Expression == "temperature > 38" && e.Result == true && e.Inputs["temperature"] == 39
+	})
+	added := index(func(e Event) bool { return e.Type == EventFactAdded && e.Rule == "fever" && e.Fact.ID == "fever" })
+	asserted := index(func(e Event) bool { return e.Type == EventConclusionAsserted && e.Conclusion == "Has fever" })
+	if evaluated < 0 || added < evaluated || asserted < added {
+		t.Errorf("Expected the evaluation, the derived fact and the conclusion in order, got %d, %d, %d", evaluated, added, asserted)
+	}
+	if index(func(e Event) bool {
+		return e.Type == EventEvaluated && e.Error != "" && e.Expression == "fever && cough"
+	}) < 0 {
+		t.Errorf("Expected the evaluation waiting for fever to be traced with its error")
+	}
+	overwritten := index(func(e Event) bool {
+		return e.Type == EventFactOverwritten && e.Fact.ID == "temperature" && e.Fact.Value == 40 && e.Previous.Value == 39
+	})
+	detected := index(func(e Event) bool {
+		return e.Type == EventContradictionDetected && e.Contradiction == "flu despite the vaccine"
+	})
+	resolved := index(func(e Event) bool { return e.Type == EventContradictionResolved })
+	removed := index(func(e Event) bool { return e.Type == EventFactRemoved && e.Fact.ID == "vaccinated" })
+	if overwritten < 0 || detected < overwritten || removed < detected || resolved < removed {
+		t.Errorf("Expected the overwrite, then the contradiction resolved by removing its facts, got %d, %d, %d, %d", overwritten, detected, removed, resolved)
+	}
+}
+
+func TestPipeline_Trace(t *testing.T) {
+	kb := &KnowledgeBase{Inferences: []Inference{{ID: "vip", Rules: rule(`tier == "gold"`), FactID: "vip", FactValue: true}}}
+	kb.Start()
+	config := PipelineConfig{KnowledgeBase: kb, Stages: []StageConfig{{Type: StageFacts}, {Type: StageKnowledge}}}
+	recorder := NewRecorder()
+	ctx := WithObserver(context.Background(), recorder)
+	if _, err := NewPipeline(config).RunContext(ctx, map[string]Fact{"tier": {ID: "tier", Value: "gold"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := recorder.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	trace, err := ReadTrace(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stages []string
+	for _, e := range trace.Events {
+		if e.Type == EventStageStarted || e.Type == EventStageFinished {
+			stages = append(stages, string(e.Type)+" "+e.Stage)
+		}
+		if e.Type == EventFactAdded && e.Fact.ID == "vip" && e.Stage != StageFacts {
+			t.Errorf("Expected the fact derived while adding the input, got stage %q", e.Stage)
+		}
+	}
+	want := []string{"stage_started facts", "stage_finished facts", "stage_started knowledge", "stage_finished knowledge"}
+	if !slices.Equal(stages, want) {
+		t.Errorf("Expected %v, got %v", want, stages)
+	}
+
+	// the observer of a run does not outlive it
+	kb.AddFact(Fact{ID: "tier", Value: "silver"})
+	if n := len(recorder.Trace().Events); n != len(trace.Events) {
+		t.Errorf("Expected no events after the run, got %d more", n-len(trace.Events))
+	}
+}
@@ -1,37 +1,40 @@
 package inference
 
 import (
+	"cmp"
 	"encoding/json"
-	"fmt"
-	"github.com/expr-lang/expr"
 	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/parser"
 	log "github.com/sirupsen/logrus"
 	"os"
+	"slices"
 )
 
+// Calculate evaluates an expression against the facts, returning its output
+// and the facts it references. The most recently used programs are cached.
 func Calculate(sExpression string, params map[string]Fact) (interface{}, []string, error) {
-	par := make(map[string]interface{})
-	for k, v := range params {
-		par[k] = v.Value
-	}
-	expression, err := expr.Compile(sExpression, expr.Env(par))
-	out := make([]string, 0)
-	if err != nil {
-		return "", nil, err
-	}
-	fmt.Println(expression)
-	output, err := expr.Run(expression, par)
+	return defaultExpressions.Evaluate(sExpression, params)
+}
+
+// expressionFacts returns the identifiers an expression references without
+// evaluating it, with the facts passed by name to the operators
+func expressionFacts(sExpression string) ([]string, error) {
+	visitor, err := visitExpression(sExpression)
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
-	out = extractFacts(expression.Node(), out)
-	return output, out, nil
+	return unique(append(visitor.facts(), visitor.tested...)), nil
 }
 
-func extractFacts(node ast.Node, out []string) []string {
-	visitor := &NodeVisitor{Dependencies: out}
-	ast.Walk(&node, visitor)
-	return visitor.Dependencies
+// visitExpression parses the expression and walks it.
+func visitExpression(sExpression string) (*NodeVisitor, error) {
+	tree, err := parser.Parse(rewriteOperators(sExpression))
+	if err != nil {
+		return nil, err
+	}
+	visitor := &NodeVisitor{}
+	ast.Walk(&tree.Node, visitor)
+	return visitor, nil
 }
 
 func unique(slice []string) []string {
@@ -46,17 +49,62 @@ func unique(slice []string) []string {
 	return list
 }
 
+// sortedKeys returns the keys of a map in order.
+func sortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
 type NodeVisitor struct {
 	Dependencies []string
+
+	// callees are the names of the called functions, which are not facts
+	callees []string
+	// tested are the facts passed by name to the operators, which need not
+	// be known
+	tested []string
+	// absent are the facts tested by unknown, which need not be known
+	// for the expression to hold
+	absent []string
+	// locals are the variables declared with let, which are not facts
+	locals []string
 }
 
 func (v *NodeVisitor) Visit(node *ast.Node) {
-	identifierNode, ok := (*node).(*ast.IdentifierNode)
-	if ok {
-		v.Dependencies = append(v.Dependencies, identifierNode.Value)
+	switch n := (*node).(type) {
+	case *ast.IdentifierNode:
+		v.Dependencies = append(v.Dependencies, n.Value)
+	case *ast.VariableDeclaratorNode:
+		v.locals = append(v.locals, n.Name)
+	case *ast.CallNode:
+		callee, ok := n.Callee.(*ast.IdentifierNode)
+		if !ok {
+			return
+		}
+		v.callees = append(v.callees, callee.Value)
+		if slices.Contains(namedOperators, callee.Value) && len(n.Arguments) > 0 {
+			if arg, ok := n.Arguments[0].(*ast.StringNode); ok {
+				v.tested = append(v.tested, arg.Value)
+				if callee.Value == "unknown" {
+					v.absent = append(v.absent, arg.Value)
+				}
+			}
+		}
 	}
 }
 
+// facts returns the dependencies that are not called functions nor local
+// variables.
+func (v *NodeVisitor) facts() []string {
+	return slices.DeleteFunc(slices.Clone(v.Dependencies), func(id string) bool {
+		return slices.Contains(v.callees, id) || slices.Contains(v.locals, id)
+	})
+}
+
 func (kb *KnowledgeBase) Dump(filename string) {
 	data, err := json.Marshal(kb)
 	if err != nil {
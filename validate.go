@@ -0,0 +1,539 @@
+package inference
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/parser"
+)
+
+// Severity tells whether a diagnostic makes a rule pack unusable.
+type Severity string
+
+const (
+	// SeverityError is a mistake that fails or panics at run time.
+	SeverityError Severity = "error"
+	// SeverityWarning is a likely mistake, such as a rule that can never
+	// hold.
+	SeverityWarning Severity = "warning"
+	// SeverityPending is an expression that could not be evaluated yet
+	// because it reads facts that are not known.
+	SeverityPending Severity = "pending"
+)
+
+// Diagnostic is a problem found in a rule pack, located by the JSON path of
+// the offending element, like knowledge_base.inferences[2].rules[0].expression.
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Path     string   `json:"path"`
+	Message  string   `json:"message"`
+	// Stage is the pipeline stage and Rule the name of the rule that
+	// reported a diagnostic found while running
+	Stage string `json:"stage,omitempty"`
+	Rule  string `json:"rule,omitempty"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Severity, d.Path, d.Message)
+}
+
+// Diagnostics lists the problems found by Validate, or while running the
+// rules, see KnowledgeBase.Diagnostics.
+type Diagnostics []Diagnostic
+
+// HasErrors reports whether any diagnostic is an error.
+func (d Diagnostics) HasErrors() bool {
+	for _, diagnostic := range d {
+		if diagnostic.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Err joins the errors in a single error, warnings are left out. It returns
+// nil when there are no errors.
+func (d Diagnostics) Err() error {
+	var errs []error
+	for _, diagnostic := range d {
+		if diagnostic.Severity == SeverityError {
+			errs = append(errs, fmt.Errorf("%s: %s", diagnostic.Path, diagnostic.Message))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks the knowledge base without running it: expressions that do
+// not compile against the Schema or do not evaluate to the expected type,
+// calculated values that are not expressions, inferences that form cycles or
+// can never fire, and conclusions or contradictions on facts or values no
+// inference can produce.
+func (kb *KnowledgeBase) Validate() Diagnostics {
+	v := newValidator(kb.Schema)
+	v.knowledgeBase("", kb)
+	return v.diags
+}
+
+// Validate checks every step of the pipeline without running it. Besides the
+// knowledge base checks, it reports the expressions of the other steps that
+// do not compile and the constraints on facts that nothing provides. When a
+// schema is declared it is taken as the complete list of input facts and
+// such constraints are errors, otherwise they are warnings.
+func (c *PipelineConfig) Validate() Diagnostics {
+	v := newValidator(c.schema())
+	if c.KnowledgeBase == nil {
+		v.errorf("knowledge_base", "knowledge base is required")
+	} else {
+		v.knowledgeBase("knowledge_base.", c.KnowledgeBase)
+	}
+	v.budget("budget", c.Budget)
+	if c.IntentClassifier != nil {
+		for i, rule := range c.IntentClassifier.Rules {
+			v.expression(fmt.Sprintf("intent_classifier.rules[%d].expression", i), rule.Expression, reflect.Bool)
+		}
+	}
+
+	provided := v.provided(c.KnowledgeBase)
+	if c.EntityExtractor != nil {
+		for i, rule := range c.EntityExtractor.Rules {
+			path := fmt.Sprintf("entity_extractor.rules[%d]", i)
+			if rule.FactID == "" {
+				v.errorf(path+".fact_id", "extraction rule has no fact ID")
+			}
+			v.expression(path+".expression", rule.Expression, reflect.Invalid)
+			provided[rule.FactID] = true
+		}
+	}
+	if c.ConstraintSet != nil {
+		for i, constraint := range c.ConstraintSet.Constraints {
+			path := fmt.Sprintf("constraint_set.constraints[%d].expression", i)
+			if !v.expression(path, constraint.Expression, reflect.Bool) {
+				continue
+			}
+			ids, _ := expressionFacts(constraint.Expression)
+			for _, id := range ids {
+				if provided[id] {
+					continue
+				}
+				if len(v.schema) > 0 {
+					v.errorf(path, "constraint can never be evaluated: fact %s is not declared nor produced", id)
+				} else {
+					v.warnf(path, "fact %s is not produced nor read by any inference", id)
+				}
+			}
+		}
+	}
+	if c.RiskAnalyzer != nil {
+		for i, risk := range c.RiskAnalyzer.Risks {
+			v.expression(fmt.Sprintf("risk_analyzer.risks[%d].expression", i), risk.Expression, reflect.Bool)
+		}
+	}
+	for i, stage := range c.Stages {
+		factory, ok := stageFactory(stage.Type)
+		if !ok {
+			v.errorf(fmt.Sprintf("stages[%d].type", i), "unknown stage type %q, expected one of %v", stage.Type, StageTypes())
+			continue
+		}
+		if _, err := factory(c, stage.Params); err != nil {
+			v.errorf(fmt.Sprintf("stages[%d].params", i), "%s", err)
+		}
+	}
+	return v.diags
+}
+
+type validator struct {
+	schema Schema
+	exprs  *ExpressionCache
+	diags  Diagnostics
+}
+
+func newValidator(schema Schema) *validator {
+	return &validator{schema: schema, exprs: NewExpressionCache(schema)}
+}
+
+func (v *validator) errorf(path, format string, args ...interface{}) {
+	v.diags = append(v.diags, Diagnostic{Severity: SeverityError, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) warnf(path, format string, args ...interface{}) {
+	v.diags = append(v.diags, Diagnostic{Severity: SeverityWarning, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// expression compiles an expression against the schema, checks the fields
+// it reads from declared object facts and, unless want is reflect.Invalid,
+// that its result can be of the wanted kind. It reports whether the
+// expression compiled.
+func (v *validator) expression(path, expression string, want reflect.Kind) bool {
+	compiled, err := v.exprs.Compile(expression)
+	if err != nil {
+		v.errorf(path, "%s", err)
+		return false
+	}
+	tree, err := parser.Parse(rewriteOperators(expression))
+	if err != nil {
+		v.errorf(path, "%s", err)
+		return false
+	}
+	visitor := &fieldVisitor{schema: v.schema}
+	ast.Walk(&tree.Node, visitor)
+	for _, err := range visitor.errs {
+		v.errorf(path, "%s", err)
+	}
+	if want != reflect.Invalid {
+		if t := compiled.program.Node().Type(); t != nil && t.Kind() != reflect.Interface && t.Kind() != want {
+			v.errorf(path, "expression evaluates to %s, expected %s", t, want)
+		}
+	}
+	return true
+}
+
+func (v *validator) knowledgeBase(prefix string, kb *KnowledgeBase) {
+	for _, id := range sortedKeys(kb.Schema) {
+		for _, term := range sortedKeys(kb.Schema[id].Terms) {
+			if err := kb.Schema[id].Terms[term].check(); err != nil {
+				v.errorf(fmt.Sprintf("%sschema.%s.terms.%s", prefix, id, term), "%s", err)
+			}
+		}
+	}
+	dynamic := false
+	for i, inf := range kb.Inferences {
+		path := fmt.Sprintf("%sinferences[%d]", prefix, i)
+		for j, rule := range inf.Rules {
+			v.expression(fmt.Sprintf("%s.rules[%d].expression", path, j), rule.Expression, reflect.Bool)
+		}
+		switch {
+		case inf.FactID == "":
+			v.errorf(path+".fact_id", "inference has no fact ID")
+		case inf.IsIDCalculated:
+			dynamic = true
+			v.expression(path+".fact_id", inf.FactID, reflect.String)
+		case len(inf.ForEach) > 0:
+			if strings.Count(inf.FactID, "{") != strings.Count(inf.FactID, "}") {
+				v.errorf(path+".fact_id", "fact ID template %q has unbalanced braces", inf.FactID)
+			}
+			for _, expression := range inf.idExpressions() {
+				dynamic = true
+				v.expression(path+".fact_id", expression, reflect.Invalid)
+			}
+		}
+		if inf.Threshold < 0 || inf.Threshold > 1 {
+			v.errorf(path+".threshold", "threshold %v is not between 0 and 1", inf.Threshold)
+		}
+		if inf.CF < -1 || inf.CF > 1 {
+			v.errorf(path+".cf", "certainty factor %v is not between -1 and 1", inf.CF)
+		}
+		if inf.Fuzzy != nil {
+			v.fuzzy(path+".fuzzy", kb.Schema, &inf)
+		}
+		vars := make(map[string]bool)
+		for j, p := range inf.ForEach {
+			patternPath := fmt.Sprintf("%s.for_each[%d]", path, j)
+			switch {
+			case p.Var == "":
+				v.errorf(patternPath+".var", "pattern binds no variable")
+			case vars[p.Var]:
+				v.errorf(patternPath+".var", "variable %s is bound twice", p.Var)
+			}
+			vars[p.Var] = true
+			if p.ID == "" && p.Type == "" {
+				v.errorf(patternPath, "pattern matches no ID nor type")
+			}
+		}
+		if inf.IsValeCalculated {
+			if sValue, ok := inf.FactValue.(string); ok {
+				v.expression(path+".fact_value", sValue, reflect.Invalid)
+			} else {
+				v.errorf(path+".fact_value", "calculated value must be an expression string, got %T", inf.FactValue)
+			}
+		}
+	}
+
+	if _, err := NewStrategy(kb.ConflictResolution); err != nil {
+		v.errorf(prefix+"conflict_resolution", "%s", err)
+	}
+	for _, source := range sortedKeys(kb.Sources) {
+		if r := kb.Sources[source]; r < 0 || r > 1 {
+			v.errorf(prefix+"sources."+source, "reliability %v is not between 0 and 1", r)
+		}
+	}
+	if kb.Retention < 0 {
+		v.errorf(prefix+"retention", "retention %s is negative", time.Duration(kb.Retention))
+	}
+	v.budget(prefix+"budget", kb.Budget)
+	if kb.BayesianNetwork != nil {
+		v.bayesianNetwork(prefix+"bayesian_network", kb.BayesianNetwork)
+	}
+
+	graph := kb.DependencyGraph()
+	for _, cycle := range graph.Cycles() {
+		names := make([]string, len(cycle))
+		for k, i := range cycle {
+			names[k] = fmt.Sprintf("%q", kb.Inferences[i].name())
+		}
+		v.warnf(fmt.Sprintf("%sinferences[%d]", prefix, cycle[0]), "inferences %s feed each other and may oscillate", strings.Join(names, ", "))
+	}
+	for _, i := range graph.Unreachable() {
+		v.warnf(fmt.Sprintf("%sinferences[%d]", prefix, i), "inference can never fire, the facts it reads are never produced")
+	}
+
+	// with calculated IDs any fact may be produced
+	if dynamic {
+		return
+	}
+	values := v.producedValues(kb)
+	check := func(path string, facts []Fact) {
+		for j, fact := range facts {
+			factPath := fmt.Sprintf("%s.facts[%d]", path, j)
+			produced, ok := values[fact.ID]
+			switch {
+			case ok && produced != nil && !containsValue(produced, fact.Value) && !v.isInput(kb, fact.ID):
+				v.warnf(factPath+".value", "no inference produces %s = %v", fact.ID, fact.Value)
+			case !ok && !v.isInput(kb, fact.ID):
+				v.warnf(factPath+".id", "no inference produces %s and it is not an input fact", fact.ID)
+			}
+		}
+	}
+	for i, conclusion := range kb.Conclusions {
+		path := fmt.Sprintf("%sconclusions[%d]", prefix, i)
+		if len(conclusion.Facts) == 0 {
+			v.warnf(path+".facts", "conclusion has no facts and never holds")
+		}
+		check(path, conclusion.Facts)
+	}
+	for i, contradiction := range kb.Contradictions {
+		check(fmt.Sprintf("%scontradictions[%d]", prefix, i), contradiction.Facts)
+	}
+}
+
+// fuzzy checks the linguistic variables and terms the fuzzy rules of an
+// inference refer to, which are declared in the knowledge base schema.
+func (v *validator) fuzzy(path string, schema Schema, inf *Inference) {
+	f := inf.Fuzzy
+	switch f.Operators {
+	case "", FuzzyMinMax, FuzzyProduct:
+	default:
+		v.errorf(path+".operators", "unknown fuzzy operators %q", f.Operators)
+	}
+	switch f.Method {
+	case "", FuzzyMamdani, FuzzySugeno:
+	default:
+		v.errorf(path+".method", "unknown fuzzy method %q", f.Method)
+	}
+	switch f.Defuzzification {
+	case "", DefuzzifyCentroid, DefuzzifyMeanOfMax:
+	default:
+		v.errorf(path+".defuzzification", "unknown defuzzification %q", f.Defuzzification)
+	}
+	output := f.Output
+	if output == "" {
+		output = inf.FactID
+	}
+	mamdani := f.consequents() && f.Method != FuzzySugeno
+	if spec := schema[output]; mamdani && (spec.Min == nil || spec.Max == nil) {
+		v.errorf(path+".output", "output variable %s needs a declared min and max", output)
+	}
+	if f.Term && f.consequents() && len(schema[output].Terms) == 0 {
+		v.errorf(path+".term", "output variable %s has no linguistic terms", output)
+	}
+	for i, rule := range f.Rules {
+		rulePath := fmt.Sprintf("%s.rules[%d]", path, i)
+		if len(rule.If) == 0 {
+			v.errorf(rulePath+".if", "fuzzy rule has no conditions")
+		}
+		for j, c := range rule.If {
+			spec, ok := schema[c.Variable]
+			switch {
+			case !ok || len(spec.Terms) == 0:
+				v.errorf(fmt.Sprintf("%s.if[%d].variable", rulePath, j), "%s is not a linguistic variable", c.Variable)
+			case spec.Terms[c.Is].Type == "":
+				v.errorf(fmt.Sprintf("%s.if[%d].is", rulePath, j), "%s has no linguistic term %q", c.Variable, c.Is)
+			}
+		}
+		switch {
+		case f.consequents() && rule.Then == "":
+			v.errorf(rulePath+".then", "fuzzy rule has no consequent while others do")
+		case mamdani:
+			if schema[output].Terms[rule.Then].Type == "" {
+				v.errorf(rulePath+".then", "%s has no linguistic term %q", output, rule.Then)
+			}
+		case rule.Then != "":
+			v.expression(rulePath+".then", rule.Then, reflect.Invalid)
+		}
+	}
+}
+
+// bayesianNetwork checks the states, parents and CPTs of the nodes and that
+// the network has no cycles.
+func (v *validator) bayesianNetwork(path string, bn *BayesianNetwork) {
+	seen := make(map[string]bool)
+	for i, node := range bn.Nodes {
+		nodePath := fmt.Sprintf("%s.nodes[%d]", path, i)
+		switch {
+		case node.ID == "":
+			v.errorf(nodePath+".id", "node has no ID")
+		case seen[node.ID]:
+			v.errorf(nodePath+".id", "node %s is declared twice", node.ID)
+		}
+		seen[node.ID] = true
+		if len(node.States) < 2 || len(unique(node.States)) != len(node.States) {
+			v.errorf(nodePath+".states", "node %s needs at least two distinct states", node.ID)
+		}
+		for j, parent := range node.Parents {
+			if bn.node(parent) == nil {
+				v.errorf(fmt.Sprintf("%s.parents[%d]", nodePath, j), "unknown parent %s", parent)
+			}
+		}
+		if _, err := bn.cptFactor(&bn.Nodes[i]); err != nil {
+			v.errorf(nodePath+".cpt", "%s", err)
+			continue
+		}
+		for j, row := range node.CPT {
+			total := 0.0
+			for _, p := range row {
+				if p < 0 {
+					total = math.NaN()
+					break
+				}
+				total += p
+			}
+			if !(math.Abs(total-1) < 1e-6) {
+				v.errorf(fmt.Sprintf("%s.cpt[%d]", nodePath, j), "probabilities %v do not add up to 1", row)
+			}
+		}
+	}
+
+	// depth first search for a parent that is its own ancestor
+	const visiting, done = 1, 2
+	state := make(map[string]int)
+	var visit func(id string) bool
+	visit = func(id string) bool {
+		switch state[id] {
+		case visiting:
+			return true
+		case done:
+			return false
+		}
+		state[id] = visiting
+		if node := bn.node(id); node != nil {
+			for _, parent := range node.Parents {
+				if visit(parent) {
+					return true
+				}
+			}
+		}
+		state[id] = done
+		return false
+	}
+	for i, node := range bn.Nodes {
+		if visit(node.ID) {
+			v.errorf(fmt.Sprintf("%s.nodes[%d].parents", path, i), "node %s is its own ancestor", node.ID)
+			return
+		}
+	}
+}
+
+// producedValues maps the facts produced by inferences to the values they
+// can take, nil when one of the values is calculated.
+func (v *validator) producedValues(kb *KnowledgeBase) map[string][]interface{} {
+	values := make(map[string][]interface{})
+	calculated := make(map[string]bool)
+	for _, inf := range kb.Inferences {
+		if inf.IsValeCalculated || inf.Fuzzy != nil && inf.Fuzzy.consequents() {
+			calculated[inf.FactID] = true
+		}
+		values[inf.FactID] = append(values[inf.FactID], inf.FactValue)
+	}
+	if kb.BayesianNetwork != nil {
+		for _, node := range kb.BayesianNetwork.Nodes {
+			for _, state := range node.States {
+				values[node.ID] = append(values[node.ID], state)
+			}
+			calculated[node.posteriorID()] = true
+		}
+	}
+	for id := range calculated {
+		values[id] = nil
+	}
+	return values
+}
+
+// isInput reports whether a fact can be provided from outside the
+// inferences: it is an initial fact, declared in the schema or read by a
+// rule, which asks for it.
+func (v *validator) isInput(kb *KnowledgeBase, id string) bool {
+	if _, ok := kb.Facts[id]; ok {
+		return true
+	}
+	if _, ok := v.schema[id]; ok {
+		return true
+	}
+	return v.consumed(kb)[id]
+}
+
+// consumed returns the facts the inference expressions read.
+func (v *validator) consumed(kb *KnowledgeBase) map[string]bool {
+	consumed := make(map[string]bool)
+	for _, inf := range kb.Inferences {
+		node, ok := inferenceInputs(&inf)
+		if !ok {
+			continue
+		}
+		for _, id := range node.inputs {
+			consumed[id] = true
+		}
+	}
+	return consumed
+}
+
+// provided returns the facts that can be known once the input facts are
+// added: the declared, initial, produced and consumed facts.
+func (v *validator) provided(kb *KnowledgeBase) map[string]bool {
+	provided := make(map[string]bool)
+	for id := range v.schema {
+		provided[id] = true
+	}
+	if kb == nil {
+		return provided
+	}
+	for id := range kb.Facts {
+		provided[id] = true
+	}
+	for _, inf := range kb.Inferences {
+		provided[inf.FactID] = true
+	}
+	for id := range v.consumed(kb) {
+		provided[id] = true
+	}
+	return provided
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if sameValue(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// budget checks that the limits of the budget, which may be nil, are not
+// negative.
+func (v *validator) budget(path string, b *Budget) {
+	if b == nil {
+		return
+	}
+	if b.MaxEvaluations < 0 {
+		v.errorf(path+".max_evaluations", "max evaluations %d is negative", b.MaxEvaluations)
+	}
+	if b.MaxDerivedFacts < 0 {
+		v.errorf(path+".max_derived_facts", "max derived facts %d is negative", b.MaxDerivedFacts)
+	}
+	if b.MaxDuration < 0 {
+		v.errorf(path+".max_duration", "max duration %s is negative", time.Duration(b.MaxDuration))
+	}
+}
@@ -0,0 +1,123 @@
+package inference
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func rule(expression string) []WeightedRule {
+	return []WeightedRule{{Rule: Rule{Expression: expression}, Weight: 1}}
+}
+
+// diagnostic finds the diagnostic reported at path
+func diagnostic(diags Diagnostics, path string) (Diagnostic, bool) {
+	for _, d := range diags {
+		if d.Path == path {
+			return d, true
+		}
+	}
+	return Diagnostic{}, false
+}
+
+func TestKnowledgeBase_Validate(t *testing.T) {
+	kb := &KnowledgeBase{
+		Facts: map[string]Fact{},
+		Inferences: []Inference{
+			{Rules: rule("temperature > 38"), FactID: "fever", FactValue: true},
+			{Rules: rule("temperature >"), FactID: "broken", FactValue: true},
+			{Rules: rule("1 + 2"), FactID: "number", FactValue: true},
+			{Rules: rule("fever"), FactID: "level", FactValue: 3, IsValeCalculated: true},
+			{Rules: rule("fever"), FactID: "triage_level", FactValue: "red"},
+		},
+		Conclusions: []Conclusion{
+			{Description: "urgent", Facts: []Fact{{ID: "triage_level", Value: "red"}}},
+			{Description: "calm", Facts: []Fact{{ID: "triage_level", Value: "green"}}},
+			{Description: "typo", Facts: []Fact{{ID: "triage_levle", Value: "red"}}},
+			{Description: "vital", Facts: []Fact{{ID: "temperature", Value: 37}}},
+		},
+	}
+	diags := kb.Validate()
+
+	tests := []struct {
+		path     string
+		severity Severity
+	}{
+		{"inferences[1].rules[0].expression", SeverityError},
+		{"inferences[2].rules[0].expression", SeverityError},
+		{"inferences[3].fact_value", SeverityError},
+		{"conclusions[1].facts[0].value", SeverityWarning},
+		{"conclusions[2].facts[0].id", SeverityWarning},
+	}
+	for _, tt := range tests {
+		d, ok := diagnostic(diags, tt.path)
+		if !ok {
+			t.Errorf("Expected a diagnostic at %s, got %v", tt.path, diags)
+			continue
+		}
+		if d.Severity != tt.severity {
+			t.Errorf("Expected %s at %s, got %v", tt.severity, tt.path, d)
+		}
+	}
+	if len(diags) != len(tests) {
+		t.Errorf("Expected %d diagnostics, got %v", len(tests), diags)
+	}
+	if !diags.HasErrors() || diags.Err() == nil {
+		t.Errorf("Expected the diagnostics to have errors")
+	}
+}
+
+func TestInference_CalculatedValueNotExpression(t *testing.T) {
+	inf := Inference{Rules: rule("true"), FactID: "level", FactValue: 3, IsValeCalculated: true}
+	if _, err := inf.infer(defaultExpressions, map[string]Fact{}); err == nil {
+		t.Errorf("Expected an error instead of a panic")
+	}
+}
+
+func TestPipelineConfig_ValidateConstraints(t *testing.T) {
+	config := PipelineConfig{
+		KnowledgeBase: &KnowledgeBase{
+			Inferences: []Inference{{Rules: rule("temperature > 38"), FactID: "fever", FactValue: true}},
+		},
+		ConstraintSet: &ConstraintSet{Constraints: []Constraint{
+			{Description: "valid", Expression: "temperature < 45 && !fever"},
+			{Description: "unknown", Expression: "pressure > 0"},
+			{Description: "not a condition", Expression: "level"},
+		}},
+	}
+
+	diags := config.Validate()
+	if d, ok := diagnostic(diags, "constraint_set.constraints[1].expression"); !ok || d.Severity != SeverityWarning {
+		t.Errorf("Expected a warning for an unknown fact, got %v", diags)
+	}
+	if diags.HasErrors() {
+		t.Errorf("Unexpected errors: %v", diags)
+	}
+
+	config.Schema = Schema{"temperature": {Type: FactNumber}, "level": {Type: FactString}}
+	diags = config.Validate()
+	if d, ok := diagnostic(diags, "constraint_set.constraints[1].expression"); !ok || d.Severity != SeverityError {
+		t.Errorf("Expected an error for an undeclared fact, got %v", diags)
+	}
+	if d, ok := diagnostic(diags, "constraint_set.constraints[2].expression"); !ok || d.Severity != SeverityError {
+		t.Errorf("Expected an error for a string constraint, got %v", diags)
+	}
+	if _, ok := diagnostic(diags, "constraint_set.constraints[0].expression"); ok {
+		t.Errorf("Unexpected diagnostic for a valid constraint: %v", diags)
+	}
+}
+
+func TestLoadPipelineConfig_Invalid(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.json")
+	data := `{"knowledge_base": {"inferences": [{"rules": [{"expression": "temperature >"}], "fact_id": "fever", "fact_value": true}]}}`
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPipelineConfig(filename); err == nil {
+		t.Errorf("Expected the syntax error to fail the load")
+	}
+
+	if _, err := LoadPipelineConfig("examples/triage/definition.json"); err != nil {
+		t.Errorf("Unexpected error loading the triage example: %v", err)
+	}
+}